@@ -0,0 +1,14 @@
+package ack
+
+import "time"
+
+// Batch 批量确认策略
+// 处理结果累计达到Size条, 或距首条未确认结果超过Interval时一并确认
+// 适用于逐条确认开销较大的中间件, 如Kafka的偏移量提交, AMQP的multiple ack
+type Batch struct {
+	// Size 单批确认的最大数量, 若 <= 0 则由驱动决定
+	Size int
+
+	// Interval 结果等待确认的最长时长, 若 <= 0 则由驱动决定
+	Interval time.Duration
+}
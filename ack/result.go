@@ -0,0 +1,66 @@
+// Package ack 定义消息处理结果
+// 驱动据此将处理结果映射为中间件原生的确认机制
+package ack
+
+import "time"
+
+// Action 确认动作
+type Action int
+
+const (
+	// ActionAck 确认消息, 从队列中移除
+	ActionAck Action = iota
+	// ActionRetry 拒绝消息, 延迟后重新投递
+	ActionRetry
+	// ActionDeadLetter 拒绝消息, 转入死信队列
+	ActionDeadLetter
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionAck:
+		return "ack"
+	case ActionRetry:
+		return "retry"
+	case ActionDeadLetter:
+		return "dead-letter"
+	}
+	return "unknown"
+}
+
+// Result 消息处理结果
+type Result struct {
+	// Action 确认动作
+	Action Action
+
+	// Delay 重新投递的延迟时长, 仅ActionRetry有效
+	Delay time.Duration
+
+	// Reason 拒绝原因
+	Reason error
+}
+
+// Acked 是否确认
+func (r Result) Acked() bool { return r.Action == ActionAck }
+
+// Ack 确认消息
+func Ack() Result { return Result{Action: ActionAck} }
+
+// NackRetry 拒绝消息, 延迟后重新投递
+func NackRetry(delay time.Duration, reason error) Result {
+	return Result{Action: ActionRetry, Delay: delay, Reason: reason}
+}
+
+// NackDeadLetter 拒绝消息, 转入死信队列
+func NackDeadLetter(reason error) Result {
+	return Result{Action: ActionDeadLetter, Reason: reason}
+}
+
+// Of 将布尔值转换为处理结果, 便于兼容原有回调
+// false 将立即重新投递
+func Of(done bool) Result {
+	if done {
+		return Ack()
+	}
+	return NackRetry(0, nil)
+}
@@ -0,0 +1,50 @@
+package bus
+
+import "time"
+
+// 归档记录来源
+const (
+	SourceSender  = "sender"
+	SourceHandler = "handler"
+)
+
+// 消息处理结果
+const (
+	OutcomeSent      = "sent"      // 发送成功
+	OutcomePending   = "pending"   // 事务消息待补偿发送
+	OutcomeRollback  = "rollback"  // 本地事务失败, 消息撤销
+	OutcomeRejected  = "rejected"  // 校验不通过, 拒绝发送
+	OutcomeDuplicate = "duplicate" // 去重窗口内重复发送, 跳过
+	OutcomeDone      = "done"      // 处理成功
+	OutcomeSkipped   = "skipped"   // 幂等判断已处理, 跳过
+	OutcomeFiltered  = "filtered"  // 解码前被过滤, 跳过
+	OutcomeExpired   = "expired"   // 消息已过期, 丢弃
+	OutcomeDeferred  = "deferred"  // 未到投递时间, 延迟处理
+	OutcomeRetry     = "retry"     // 处理失败, 延迟重试
+	OutcomeDead      = "dead"      // 进入死信存储
+	OutcomeFailed    = "failed"    // 发送或处理异常
+)
+
+// ArchiveRecord 消息归档记录
+type ArchiveRecord struct {
+	// Source 记录来源, sender 或 handler
+	Source string
+
+	// Name 主题或队列名称
+	Name string
+
+	// Data 消息原始内容
+	Data []byte
+
+	// Outcome 处理结果
+	Outcome string
+
+	// Error 异常信息
+	Error string
+
+	// StartedAt 开始时间
+	StartedAt time.Time
+
+	// FinishedAt 结束时间
+	FinishedAt time.Time
+}
@@ -0,0 +1,56 @@
+package bus
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"math/rand"
+)
+
+// SampledArchive 抽样归档
+// 按比例或条件将消息的完整内容及处理结果写入审计存储, 无需归档全部消息
+type SampledArchive struct {
+	// Sink 审计存储
+	Sink ArchiveInterface
+
+	// Rate 抽样比例, 取值[0, 1], 例如0.01表示抽取1%的记录
+	Rate float64
+
+	// Predicate 抽样条件, 返回true的记录全部写入, 不受抽样比例影响
+	Predicate func(record *ArchiveRecord) bool
+
+	// Consistent 按消息标识一致抽样
+	// 开启后同一消息在发送器及各处理器中的抽样结果相同, 便于追踪完整链路
+	Consistent bool
+}
+
+func (sa *SampledArchive) Store(record *ArchiveRecord) error {
+	if sa.sampled(record) {
+		return sa.Sink.Store(record)
+	}
+	return nil
+}
+
+// sampled 记录是否被抽中
+func (sa *SampledArchive) sampled(record *ArchiveRecord) bool {
+	if sa.Predicate != nil && sa.Predicate(record) {
+		return true
+	}
+	if sa.Rate <= 0 {
+		return false
+	} else if sa.Rate >= 1 {
+		return true
+	}
+	if !sa.Consistent {
+		return rand.Float64() < sa.Rate
+	}
+	var msg struct {
+		BizUID string `json:"b"`
+	}
+	if json.Unmarshal(record.Data, &msg) != nil || msg.BizUID == "" {
+		return rand.Float64() < sa.Rate
+	}
+	h := fnv.New64a()
+	h.Write([]byte(msg.BizUID))
+	return float64(h.Sum64())/math.MaxUint64 < sa.Rate
+}
@@ -0,0 +1,224 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/easy-bus/bus"
+)
+
+// Config 压测配置
+type Config struct {
+	// Driver 被测驱动实例
+	Driver bus.DriverInterface
+
+	// Topic 发布主题, 默认为 bench
+	Topic string
+
+	// Queue 消费队列, 默认为 {Topic}.consume
+	Queue string
+
+	// Messages 消息总数
+	Messages int
+
+	// Publishers 并发发布协程数, 默认为1
+	Publishers int
+
+	// Consumers 并发消费数量, 默认不限制
+	Consumers int
+
+	// PayloadSize 消息内容填充字节数
+	PayloadSize int
+
+	// Timeout 等待全部消息消费完成的超时时长, 默认为1分钟
+	Timeout time.Duration
+}
+
+// Latency 延迟分位统计
+type Latency struct {
+	P50, P90, P99, Max time.Duration
+}
+
+// Report 压测报告
+type Report struct {
+	// Published 发布成功数量
+	Published int64
+
+	// PublishErrors 发布失败数量
+	PublishErrors int64
+
+	// Consumed 消费完成数量
+	Consumed int64
+
+	// PublishDuration 发布耗时
+	PublishDuration time.Duration
+
+	// Duration 从开始发布至全部消费完成的耗时
+	Duration time.Duration
+
+	// Latency 发布至消费的端到端延迟
+	Latency Latency
+}
+
+// PublishThroughput 发布吞吐量, 条/秒
+func (r *Report) PublishThroughput() float64 {
+	return throughput(r.Published, r.PublishDuration)
+}
+
+// ConsumeThroughput 消费吞吐量, 条/秒
+func (r *Report) ConsumeThroughput() float64 {
+	return throughput(r.Consumed, r.Duration)
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf(
+		"published %d (errors %d) at %.0f msg/s, consumed %d at %.0f msg/s, latency p50 %s p90 %s p99 %s max %s",
+		r.Published, r.PublishErrors, r.PublishThroughput(), r.Consumed, r.ConsumeThroughput(),
+		r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max,
+	)
+}
+
+// payload 压测消息内容
+type payload struct {
+	Seq     int    `json:"s"`
+	SentAt  int64  `json:"t"`
+	Padding string `json:"p,omitempty"`
+}
+
+// Run 执行压测
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Driver == nil {
+		return nil, errors.New("bench: missing driver instance")
+	}
+	if cfg.Messages <= 0 {
+		return nil, errors.New("bench: messages must > 0")
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = "bench"
+	}
+	if cfg.Queue == "" {
+		cfg.Queue = cfg.Topic + ".consume"
+	}
+	if cfg.Publishers <= 0 {
+		cfg.Publishers = 1
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Minute
+	}
+	report := new(Report)
+	latencies := make([]time.Duration, cfg.Messages)
+	finished := make(chan struct{})
+	var consumed sync.Map
+	sender := &bus.Sender{Topic: cfg.Topic, Driver: cfg.Driver}
+	handler := &bus.Handler{
+		Queue:       cfg.Queue,
+		Driver:      cfg.Driver,
+		Subscribe:   bus.Subscribe{Topic: cfg.Topic},
+		Concurrency: cfg.Consumers,
+		HandleFunc: func(msg *bus.Message) bool {
+			var p payload
+			msg.Scan(&p)
+			if p.Seq < 0 || p.Seq >= len(latencies) {
+				return true // 非本次压测发送的消息不计入统计
+			}
+			if _, loaded := consumed.LoadOrStore(p.Seq, true); loaded {
+				return true // 重复投递不计入统计
+			}
+			latencies[p.Seq] = time.Since(time.Unix(0, p.SentAt))
+			if atomic.AddInt64(&report.Consumed, 1) == int64(cfg.Messages) {
+				close(finished)
+			}
+			return true
+		},
+		EnsureFunc: func(*bus.Message) bool { return true },
+	}
+	if err := prepare(sender, handler); err != nil {
+		return nil, err
+	}
+	hctx, cancel := context.WithCancel(ctx)
+	go handler.RunCtx(hctx)
+
+	padding := strings.Repeat("x", cfg.PayloadSize)
+	start := time.Now()
+	var seq int64 = -1
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Publishers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n := int(atomic.AddInt64(&seq, 1))
+				if n >= cfg.Messages || ctx.Err() != nil {
+					return
+				}
+				p := payload{Seq: n, SentAt: time.Now().UnixNano(), Padding: padding}
+				if sender.Send(bus.MessageWithId(strconv.Itoa(n), p, "")) != nil {
+					atomic.AddInt64(&report.PublishErrors, 1)
+				} else {
+					atomic.AddInt64(&report.Published, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	report.PublishDuration = time.Since(start)
+
+	var err error
+	if report.Published == int64(cfg.Messages) {
+		select {
+		case <-finished:
+		case <-time.After(cfg.Timeout):
+			err = fmt.Errorf("bench: timeout after %s, consumed %d", cfg.Timeout, atomic.LoadInt64(&report.Consumed))
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	report.Duration = time.Since(start)
+	cancel()
+	handler.Wait() // 处理器退出后再统计延迟, 避免与处理中的消息竞争
+	report.Latency = percentiles(latencies, &consumed)
+	return report, err
+}
+
+// prepare 准备发送器及处理器, 将panic转换为错误
+func prepare(sender *bus.Sender, handler *bus.Handler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("bench: prepare failed, %v", r)
+		}
+	}()
+	sender.Prepare()
+	handler.Prepare()
+	return nil
+}
+
+// percentiles 计算已消费消息的延迟分位
+func percentiles(latencies []time.Duration, consumed *sync.Map) Latency {
+	rows := make([]time.Duration, 0, len(latencies))
+	consumed.Range(func(key, _ interface{}) bool {
+		rows = append(rows, latencies[key.(int)])
+		return true
+	})
+	if len(rows) == 0 {
+		return Latency{}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i] < rows[j] })
+	at := func(p float64) time.Duration {
+		return rows[int(float64(len(rows)-1)*p)]
+	}
+	return Latency{P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: rows[len(rows)-1]}
+}
+
+func throughput(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
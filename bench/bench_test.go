@@ -0,0 +1,69 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/easy-bus/bus"
+	"github.com/easy-bus/bus/boltdriver"
+	"github.com/easy-bus/bus/memdriver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	report, err := Run(context.Background(), Config{
+		Driver:      &memdriver.Driver{Capacity: 64},
+		Messages:    200,
+		Publishers:  4,
+		PayloadSize: 32,
+	})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 200, report.Published)
+	assert.EqualValues(t, 200, report.Consumed)
+	assert.True(t, report.Latency.Max >= report.Latency.P50)
+	t.Log(report)
+	_, err = Run(context.Background(), Config{})
+	assert.NotNil(t, err)
+}
+
+func TestRunStray(t *testing.T) {
+	drv := &memdriver.Driver{Capacity: 64}
+	assert.Nil(t, drv.CreateQueue("bench.consume", 0))
+	for _, seq := range []int{-1, 1000} {
+		data, _ := json.Marshal(bus.MessageWithId("stray", payload{Seq: seq}, ""))
+		assert.Nil(t, drv.SendToQueue("bench.consume", data, 0))
+	}
+	report, err := Run(context.Background(), Config{Driver: drv, Messages: 20, Publishers: 2})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 20, report.Consumed)
+}
+
+func BenchmarkMemDriver(b *testing.B) {
+	benchmark(b, &memdriver.Driver{Capacity: 1024})
+}
+
+func BenchmarkBoltDriver(b *testing.B) {
+	drv, err := boltdriver.Open(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer drv.Close()
+	benchmark(b, drv)
+}
+
+func benchmark(b *testing.B, drv bus.DriverInterface) {
+	b.ReportAllocs()
+	report, err := Run(context.Background(), Config{
+		Driver:      drv,
+		Messages:    b.N,
+		Publishers:  4,
+		PayloadSize: 256,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(report.ConsumeThroughput(), "msg/s")
+	b.ReportMetric(float64(report.Latency.P99.Microseconds()), "p99-us")
+}
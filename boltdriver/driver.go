@@ -0,0 +1,574 @@
+package boltdriver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/easy-bus/bus/ack"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	queuesBucket = []byte("queues")
+	topicsBucket = []byte("topics")
+)
+
+// 默认配置
+const (
+	DefaultPollInterval = 100 * time.Millisecond
+	DefaultVisibility   = 30 * time.Second
+	DefaultBatchSize    = 64
+)
+
+// Driver 基于bbolt的嵌入式持久化驱动
+// 队列消息以可见时间排序存储, 延迟消息到期后方可被获取
+// 获取的消息在Visibility时长内不可见, 处理成功后删除
+// 进程崩溃时未确认的消息将在可见性超时后重新投递
+type Driver struct {
+	// PollInterval 轮询间隔
+	PollInterval time.Duration
+
+	// Visibility 消息处理的可见性超时
+	// 超时仍未确认的消息将被重新投递
+	Visibility time.Duration
+
+	// BatchSize 单次获取的最大消息数量
+	BatchSize int
+
+	// MaxInflight 每个接收者已获取但尚未确认的最大消息数量, 默认为BatchSize
+	// 达到上限后暂停获取, 避免处理能力不足时消息超出可见性超时被重复投递
+	MaxInflight int
+
+	db     *bbolt.DB
+	mu     sync.Mutex
+	notify map[string]chan struct{}
+}
+
+// Open 打开或创建数据文件
+func Open(path string) (*Driver, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("boltdriver: open [%s] failed, %v", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{queuesBucket, topicsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("boltdriver: init [%s] failed, %v", path, err)
+	}
+	return &Driver{
+		PollInterval: DefaultPollInterval,
+		Visibility:   DefaultVisibility,
+		BatchSize:    DefaultBatchSize,
+		db:           db,
+		notify:       make(map[string]chan struct{}),
+	}, nil
+}
+
+// Close 关闭数据文件
+func (d *Driver) Close() error { return d.db.Close() }
+
+// CreateQueue 创建队列, 重复创建仅更新延迟, 保留已绑定的死信队列
+func (d *Driver) CreateQueue(name string, delay time.Duration) error {
+	return d.createQueue(name, delay, func(old []byte) string {
+		if len(old) > 8 {
+			return string(old[8:])
+		}
+		return ""
+	})
+}
+
+// CreateQueueWithDL 创建队列并绑定死信队列
+// 处理结果为NackDeadLetter的消息将转入dlQueue, 为空则删除
+func (d *Driver) CreateQueueWithDL(name string, delay time.Duration, dlQueue string) error {
+	return d.createQueue(name, delay, func([]byte) string { return dlQueue })
+}
+
+// createQueue 创建队列并写入元数据, dlQueue 根据已有元数据确定死信队列名称
+func (d *Driver) createQueue(name string, delay time.Duration, dlQueue func(old []byte) string) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(queueBucket(name)); err != nil {
+			return err
+		}
+		// 队列元数据, 前8字节为延迟, 其后为死信队列名称
+		queues := tx.Bucket(queuesBucket)
+		meta := append(encodeUint64(uint64(delay)), dlQueue(queues.Get([]byte(name)))...)
+		return queues.Put([]byte(name), meta)
+	})
+}
+
+func (d *Driver) CreateTopic(name string) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.Bucket(topicsBucket).CreateBucketIfNotExists([]byte(name))
+		return err
+	})
+}
+
+func (d *Driver) Subscribe(topic, queue, routeKey string) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(topicsBucket).Bucket([]byte(topic))
+		if bkt == nil {
+			return fmt.Errorf("boltdriver: topic [%s] not exists", topic)
+		}
+		if tx.Bucket(queuesBucket).Get([]byte(queue)) == nil {
+			return fmt.Errorf("boltdriver: queue [%s] not exists", queue)
+		}
+		return bkt.Put(subscription(queue, routeKey), []byte{})
+	})
+}
+
+func (d *Driver) UnSubscribe(topic, queue, routeKey string) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(topicsBucket).Bucket([]byte(topic))
+		if bkt == nil {
+			return nil
+		}
+		return bkt.Delete(subscription(queue, routeKey))
+	})
+}
+
+func (d *Driver) SendToQueue(queue string, content []byte, delay time.Duration) error {
+	err := d.db.Update(func(tx *bbolt.Tx) error {
+		return push(tx, queue, content, time.Now().Add(delay))
+	})
+	if err == nil {
+		d.wakeup(queue)
+	}
+	return err
+}
+
+func (d *Driver) SendToTopic(topic string, content []byte, routeKey string) error {
+	_, err := d.SendToTopicConfirmed(topic, content, routeKey)
+	return err
+}
+
+// SendToTopicConfirmed 发送消息至主题, 事务提交后返回主题内自增的消息序号
+func (d *Driver) SendToTopicConfirmed(topic string, content []byte, routeKey string) (string, error) {
+	return d.sendToTopic(topic, content, routeKey, time.Time{})
+}
+
+// SendToTopicAt 发送消息至主题, 消息在at之后方可被获取
+// 队列延迟晚于at时以队列延迟为准
+func (d *Driver) SendToTopicAt(topic string, content []byte, routeKey string, at time.Time) error {
+	_, err := d.sendToTopic(topic, content, routeKey, at)
+	return err
+}
+
+// sendToTopic 发送消息至主题, 消息的可见时间不早于at
+func (d *Driver) sendToTopic(topic string, content []byte, routeKey string, at time.Time) (string, error) {
+	var id uint64
+	var queues []string
+	err := d.db.Update(func(tx *bbolt.Tx) (err error) {
+		bkt := tx.Bucket(topicsBucket).Bucket([]byte(topic))
+		if bkt == nil {
+			return fmt.Errorf("boltdriver: topic [%s] not exists", topic)
+		}
+		if id, err = bkt.NextSequence(); err != nil {
+			return err
+		}
+		now := time.Now()
+		return bkt.ForEach(func(k, _ []byte) error {
+			queue, rk := parseSubscription(k)
+			if rk != routeKey {
+				return nil
+			}
+			var delay time.Duration
+			if meta := tx.Bucket(queuesBucket).Get([]byte(queue)); len(meta) >= 8 {
+				delay = time.Duration(decodeUint64(meta[:8]))
+			}
+			queues = append(queues, queue)
+			visibleAt := now.Add(delay)
+			if at.After(visibleAt) {
+				visibleAt = at
+			}
+			return push(tx, queue, content, visibleAt)
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, queue := range queues {
+		d.wakeup(queue)
+	}
+	return strconv.FormatUint(id, 10), nil
+}
+
+func (d *Driver) ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) ack.Result) {
+	d.ReceiveMessageExtend(ctx, queue, errChan, func(data []byte, _ func(time.Duration) error) ack.Result {
+		return handler(data)
+	})
+}
+
+// ReceiveMessageExtend 监听队列获取消息
+// handler 可通过extend延长消息的可见性超时, 避免长耗时处理期间被重新投递
+func (d *Driver) ReceiveMessageExtend(ctx context.Context, queue string, errChan chan error, handler func(data []byte, extend func(time.Duration) error) ack.Result) {
+	var wg sync.WaitGroup
+	d.receive(ctx, queue, errChan, func(row *leased, release func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer release()
+			if err := d.handle(queue, row, handler); err != nil {
+				report(ctx, errChan, err)
+			}
+		}()
+	})
+	wg.Wait() // 等待处理中的消息确认后退出
+}
+
+// ReceiveMessageBatchAck 监听队列获取消息, 处理结果在同一事务中批量确认
+// 默认单批数量为BatchSize, 等待时长为PollInterval
+// 单批数量超出MaxInflight时按等待时长确认
+func (d *Driver) ReceiveMessageBatchAck(ctx context.Context, queue string, errChan chan error, batch ack.Batch, handler func([]byte) ack.Result) {
+	if batch.Size <= 0 {
+		batch.Size = d.BatchSize
+	}
+	if batch.Interval <= 0 {
+		batch.Interval = d.PollInterval
+	}
+	var wg sync.WaitGroup
+	settled := make(chan settlement, batch.Size)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.commit(queue, batch, settled, func(err error) { report(ctx, errChan, err) })
+	}()
+	d.receive(ctx, queue, errChan, func(row *leased, release func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			settled <- settlement{row: row, result: handler(row.data), release: release}
+		}()
+	})
+	wg.Wait() // 等待处理中的消息, 退出前全部确认
+	close(settled)
+	<-done
+}
+
+// receive 循环获取到期消息并分发
+// 已获取未确认的消息达到MaxInflight时等待, 消息确认后调用release释放名额
+func (d *Driver) receive(ctx context.Context, queue string, errChan chan error, dispatch func(row *leased, release func())) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	notify := d.channel(queue)
+	inflight := make(chan struct{}, d.maxInflight())
+	release := func() { <-inflight }
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case inflight <- struct{}{}:
+		}
+		n := 1
+	acquire:
+		for n < d.BatchSize {
+			select {
+			case inflight <- struct{}{}:
+				n++
+			default:
+				break acquire
+			}
+		}
+		rows, err := d.lease(queue, n)
+		if err != nil {
+			report(ctx, errChan, err)
+		}
+		for _, row := range rows {
+			dispatch(row, release)
+		}
+		for i := len(rows); i < n; i++ {
+			release() // 归还未使用的名额
+		}
+		if len(rows) == n && ctx.Err() == nil {
+			continue // 可能仍有到期消息, 获取名额后立即继续
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-notify:
+		}
+	}
+}
+
+// maxInflight 获取未确认消息的上限
+func (d *Driver) maxInflight() int {
+	if d.MaxInflight <= 0 {
+		return d.BatchSize
+	}
+	return d.MaxInflight
+}
+
+// report 上报队列级错误, 退出接收后不再上报
+func report(ctx context.Context, errChan chan error, err error) {
+	select {
+	case errChan <- err:
+	case <-ctx.Done():
+	}
+}
+
+// settlement 待确认的处理结果
+type settlement struct {
+	row     *leased
+	result  ack.Result
+	release func()
+}
+
+// commit 按批量策略累计处理结果并确认, 确认失败时调用fail
+func (d *Driver) commit(queue string, batch ack.Batch, settled chan settlement, fail func(err error)) {
+	var pending []settlement
+	var timer *time.Timer
+	var expired <-chan time.Time
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		if len(pending) > 0 {
+			if err := d.settle(queue, pending...); err != nil {
+				fail(err)
+			}
+			pending = nil
+		}
+	}
+	defer flush()
+	for {
+		select {
+		case s, ok := <-settled:
+			if !ok {
+				return
+			}
+			if pending = append(pending, s); len(pending) >= batch.Size {
+				flush()
+			} else if timer == nil {
+				timer = time.NewTimer(batch.Interval)
+				expired = timer.C
+			}
+		case <-expired:
+			timer, expired = nil, nil
+			flush()
+		}
+	}
+}
+
+// QueueDepth 获取队列中的消息数量, 包含延迟及处理中的消息
+func (d *Driver) QueueDepth(queue string) (n int) {
+	_ = d.db.View(func(tx *bbolt.Tx) error {
+		if bkt := tx.Bucket(queueBucket(queue)); bkt != nil {
+			n = bkt.Stats().KeyN
+		}
+		return nil
+	})
+	return n
+}
+
+// leased 已租约的消息
+type leased struct {
+	sync.Mutex
+	key  []byte
+	data []byte
+}
+
+// lease 获取至多n条到期消息并延长其可见时间
+func (d *Driver) lease(queue string, n int) ([]*leased, error) {
+	var rows []*leased
+	err := d.db.Update(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(queueBucket(queue))
+		if bkt == nil {
+			return fmt.Errorf("boltdriver: queue [%s] not exists", queue)
+		}
+		now := time.Now()
+		limit := encodeUint64(uint64(now.UnixNano()))
+		cursor := bkt.Cursor()
+		var expired [][]byte
+		for k, v := cursor.First(); k != nil && len(expired) < n; k, v = cursor.Next() {
+			if bytes.Compare(k[:8], limit) > 0 {
+				break
+			}
+			expired = append(expired, k)
+			rows = append(rows, &leased{data: append([]byte(nil), v...)})
+		}
+		for i, k := range expired {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+			key, err := messageKey(bkt, now.Add(d.Visibility))
+			if err != nil {
+				return err
+			}
+			rows[i].key = key
+			if err := bkt.Put(key, rows[i].data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// handle 处理消息并确认
+func (d *Driver) handle(queue string, row *leased, handler func([]byte, func(time.Duration) error) ack.Result) error {
+	result := handler(row.data, func(visibility time.Duration) error {
+		return d.extend(queue, row, visibility)
+	})
+	row.Lock()
+	defer row.Unlock()
+	return d.settle(queue, settlement{row: row, result: result})
+}
+
+// settle 在同一事务中确认处理结果, 确认则删除, 拒绝重试则在指定延迟后重新可见
+// 拒绝转入死信的消息移入绑定的死信队列, 未绑定时删除
+func (d *Driver) settle(queue string, rows ...settlement) error {
+	defer func() {
+		for _, s := range rows {
+			if s.release != nil {
+				s.release()
+			}
+		}
+	}()
+	var retried bool
+	var dlQueues = map[string]bool{}
+	err := d.db.Update(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(queueBucket(queue))
+		if bkt == nil {
+			return nil
+		}
+		for _, s := range rows {
+			if bkt.Get(s.row.key) == nil {
+				continue // 租约已过期, 消息已重新投递
+			}
+			if err := bkt.Delete(s.row.key); err != nil {
+				return err
+			}
+			switch s.result.Action {
+			case ack.ActionRetry:
+				if err := push(tx, queue, s.row.data, time.Now().Add(s.result.Delay)); err != nil {
+					return err
+				}
+				retried = true
+			case ack.ActionDeadLetter:
+				meta := tx.Bucket(queuesBucket).Get([]byte(queue))
+				if len(meta) > 8 {
+					dlQueue := string(meta[8:])
+					if err := push(tx, dlQueue, s.row.data, time.Now()); err != nil {
+						return err
+					}
+					dlQueues[dlQueue] = true
+				}
+			}
+		}
+		return nil
+	})
+	for dlQueue := range dlQueues {
+		d.wakeup(dlQueue)
+	}
+	if err != nil {
+		return fmt.Errorf("boltdriver: settle queue [%s] failed, %v", queue, err)
+	}
+	if retried {
+		d.wakeup(queue)
+	}
+	return nil
+}
+
+// extend 将处理中消息的可见时间延长至当前时间之后的指定时长
+func (d *Driver) extend(queue string, row *leased, visibility time.Duration) error {
+	row.Lock()
+	defer row.Unlock()
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(queueBucket(queue))
+		if bkt == nil || bkt.Get(row.key) == nil {
+			return fmt.Errorf("boltdriver: message lease in queue [%s] has expired", queue)
+		}
+		if err := bkt.Delete(row.key); err != nil {
+			return err
+		}
+		key, err := messageKey(bkt, time.Now().Add(visibility))
+		if err != nil {
+			return err
+		}
+		if err := bkt.Put(key, row.data); err != nil {
+			return err
+		}
+		row.key = key
+		return nil
+	})
+}
+
+// channel 获取队列的唤醒通道
+func (d *Driver) channel(queue string) chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.notify[queue]; !ok {
+		d.notify[queue] = make(chan struct{}, 1)
+	}
+	return d.notify[queue]
+}
+
+// wakeup 唤醒队列的接收协程
+func (d *Driver) wakeup(queue string) {
+	select {
+	case d.channel(queue) <- struct{}{}:
+	default:
+	}
+}
+
+// push 写入消息, 以可见时间及序号作为键
+func push(tx *bbolt.Tx, queue string, content []byte, visibleAt time.Time) error {
+	bkt := tx.Bucket(queueBucket(queue))
+	if bkt == nil {
+		return fmt.Errorf("boltdriver: queue [%s] not exists", queue)
+	}
+	key, err := messageKey(bkt, visibleAt)
+	if err != nil {
+		return err
+	}
+	return bkt.Put(key, content)
+}
+
+// messageKey 生成消息键, 前8字节为可见时间, 后8字节为序号
+func messageKey(bkt *bbolt.Bucket, visibleAt time.Time) ([]byte, error) {
+	seq, err := bkt.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+	return append(encodeUint64(uint64(visibleAt.UnixNano())), encodeUint64(seq)...), nil
+}
+
+func queueBucket(name string) []byte { return []byte("queue." + name) }
+
+func subscription(queue, routeKey string) []byte {
+	return []byte(queue + "\x00" + routeKey)
+}
+
+func parseSubscription(key []byte) (queue, routeKey string) {
+	parts := bytes.SplitN(key, []byte{0}, 2)
+	return string(parts[0]), string(parts[1])
+}
+
+func encodeUint64(v uint64) []byte {
+	bts := make([]byte, 8)
+	binary.BigEndian.PutUint64(bts, v)
+	return bts
+}
+
+func decodeUint64(bts []byte) uint64 {
+	if len(bts) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(bts)
+}
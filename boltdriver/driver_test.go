@@ -0,0 +1,209 @@
+package boltdriver
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/easy-bus/bus/ack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDriver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bus.db")
+	d, err := Open(path)
+	assert.Nil(t, err)
+	assert.Nil(t, d.CreateTopic("topic"))
+	assert.Nil(t, d.CreateQueue("queue", 0))
+	assert.Nil(t, d.Subscribe("topic", "queue", "key"))
+	assert.NotNil(t, d.Subscribe("topic", "missing", "key"))
+	id, err := d.SendToTopicConfirmed("topic", []byte("a"), "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", id)
+	assert.Nil(t, d.SendToTopic("topic", []byte("b"), "other"))
+	_, err = d.SendToTopicConfirmed("missing", nil, "")
+	assert.NotNil(t, err)
+	assert.Nil(t, d.SendToQueue("queue", []byte("c"), 50*time.Millisecond))
+	assert.Nil(t, d.Close())
+
+	// 重新打开后消息依然存在
+	d, err = Open(path)
+	assert.Nil(t, err)
+	defer d.Close()
+	assert.Equal(t, 2, d.QueueDepth("queue"))
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan string, 3)
+	var failed bool
+	go d.ReceiveMessage(ctx, "queue", make(chan error), func(data []byte) ack.Result {
+		if string(data) == "a" && !failed {
+			failed = true // 首次处理失败重新投递
+			return ack.NackRetry(0, nil)
+		}
+		received <- string(data)
+		return ack.Ack()
+	})
+	start := time.Now()
+	assert.Equal(t, "a", <-received)
+	assert.Equal(t, "c", <-received)
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, d.QueueDepth("queue"))
+}
+
+func TestVisibility(t *testing.T) {
+	d, err := Open(filepath.Join(t.TempDir(), "bus.db"))
+	assert.Nil(t, err)
+	defer d.Close()
+	d.Visibility = 20 * time.Millisecond
+	assert.Nil(t, d.CreateQueue("queue", 0))
+	assert.Nil(t, d.SendToQueue("queue", []byte("a"), 0))
+	rows, err := d.lease("queue", d.BatchSize)
+	assert.Nil(t, err)
+	assert.Len(t, rows, 1)
+	rows, _ = d.lease("queue", d.BatchSize)
+	assert.Len(t, rows, 0)
+	// 未确认的消息超时后重新可见
+	time.Sleep(30 * time.Millisecond)
+	rows, _ = d.lease("queue", d.BatchSize)
+	assert.Len(t, rows, 1)
+}
+
+func TestExtend(t *testing.T) {
+	d, err := Open(filepath.Join(t.TempDir(), "bus.db"))
+	assert.Nil(t, err)
+	defer d.Close()
+	d.Visibility = 20 * time.Millisecond
+	assert.Nil(t, d.CreateQueue("queue", 0))
+	assert.Nil(t, d.SendToQueue("queue", []byte("a"), 0))
+	rows, _ := d.lease("queue", d.BatchSize)
+	assert.Len(t, rows, 1)
+	d.handle("queue", rows[0], func(data []byte, extend func(time.Duration) error) ack.Result {
+		assert.Nil(t, extend(time.Hour))
+		// 延长后超出原可见性超时依然不可见
+		time.Sleep(30 * time.Millisecond)
+		rows, _ := d.lease("queue", d.BatchSize)
+		assert.Len(t, rows, 0)
+		return ack.Ack()
+	})
+	assert.Equal(t, 0, d.QueueDepth("queue"))
+	assert.NotNil(t, d.extend("queue", rows[0], time.Hour))
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	d, err := Open(filepath.Join(t.TempDir(), "bus.db"))
+	assert.Nil(t, err)
+	defer d.Close()
+	assert.Nil(t, d.CreateTopic("topic"))
+	assert.Nil(t, d.CreateQueue("queue.dl", 0))
+	assert.Nil(t, d.CreateQueueWithDL("queue", 20*time.Millisecond, "queue.dl"))
+	assert.Nil(t, d.Subscribe("topic", "queue", ""))
+	assert.Nil(t, d.SendToTopic("topic", []byte("a"), ""))
+	rows, _ := d.lease("queue", d.BatchSize)
+	assert.Len(t, rows, 0) // 队列延迟依然有效
+	time.Sleep(30 * time.Millisecond)
+	rows, _ = d.lease("queue", d.BatchSize)
+	assert.Len(t, rows, 1)
+	d.handle("queue", rows[0], func([]byte, func(time.Duration) error) ack.Result {
+		return ack.NackDeadLetter(nil)
+	})
+	assert.Equal(t, 0, d.QueueDepth("queue"))
+	assert.Equal(t, 1, d.QueueDepth("queue.dl"))
+	// 重复创建队列保留已绑定的死信队列
+	assert.Nil(t, d.CreateQueue("queue", 0))
+	assert.Nil(t, d.SendToQueue("queue", []byte("b"), 0))
+	rows, _ = d.lease("queue", d.BatchSize)
+	assert.Len(t, rows, 1)
+	d.handle("queue", rows[0], func([]byte, func(time.Duration) error) ack.Result {
+		return ack.NackDeadLetter(nil)
+	})
+	assert.Equal(t, 2, d.QueueDepth("queue.dl"))
+}
+
+func TestBatchAck(t *testing.T) {
+	d, err := Open(filepath.Join(t.TempDir(), "bus.db"))
+	assert.Nil(t, err)
+	defer d.Close()
+	assert.Nil(t, d.CreateQueue("queue", 0))
+	for _, s := range []string{"a", "b", "c", "d", "e", "f"} {
+		assert.Nil(t, d.SendToQueue("queue", []byte(s), 0))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.ReceiveMessageBatchAck(ctx, "queue", make(chan error), ack.Batch{Size: 4, Interval: time.Hour}, func([]byte) ack.Result {
+			return ack.Ack()
+		})
+	}()
+	// 累计满4条后一并确认, 其余等待确认
+	assert.Eventually(t, func() bool { return d.QueueDepth("queue") == 2 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 2, d.QueueDepth("queue"))
+	// 退出前确认全部已处理的消息
+	cancel()
+	<-done
+	assert.Equal(t, 0, d.QueueDepth("queue"))
+}
+
+func TestSendToTopicAt(t *testing.T) {
+	d, err := Open(filepath.Join(t.TempDir(), "bus.db"))
+	assert.Nil(t, err)
+	defer d.Close()
+	assert.Nil(t, d.CreateTopic("topic"))
+	assert.Nil(t, d.CreateQueue("queue", 0))
+	assert.Nil(t, d.Subscribe("topic", "queue", ""))
+	assert.Nil(t, d.SendToTopicAt("topic", []byte("a"), "", time.Now().Add(30*time.Millisecond)))
+	assert.NotNil(t, d.SendToTopicAt("missing", nil, "", time.Now()))
+	rows, _ := d.lease("queue", d.BatchSize)
+	assert.Len(t, rows, 0)
+	time.Sleep(40 * time.Millisecond)
+	rows, _ = d.lease("queue", d.BatchSize)
+	assert.Len(t, rows, 1)
+}
+
+func TestMaxInflight(t *testing.T) {
+	d, err := Open(filepath.Join(t.TempDir(), "bus.db"))
+	assert.Nil(t, err)
+	defer d.Close()
+	d.PollInterval, d.MaxInflight = 5*time.Millisecond, 2
+	assert.Nil(t, d.CreateQueue("queue", 0))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, d.SendToQueue("queue", []byte{byte(i)}, 0))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	block := make(chan struct{})
+	var running, handled int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.ReceiveMessage(ctx, "queue", make(chan error), func([]byte) ack.Result {
+			atomic.AddInt32(&running, 1)
+			<-block
+			atomic.AddInt32(&handled, 1)
+			return ack.Ack()
+		})
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&running) == 2 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond) // 名额已满时不再获取
+	assert.EqualValues(t, 2, atomic.LoadInt32(&running))
+	close(block)
+	assert.Eventually(t, func() bool { return d.QueueDepth("queue") == 0 }, time.Second, time.Millisecond)
+	assert.EqualValues(t, 10, atomic.LoadInt32(&handled))
+	cancel()
+	<-done
+}
+
+func TestSettleError(t *testing.T) {
+	d, err := Open(filepath.Join(t.TempDir(), "bus.db"))
+	assert.Nil(t, err)
+	assert.Nil(t, d.CreateQueue("queue", 0))
+	assert.Nil(t, d.SendToQueue("queue", []byte("a"), 0))
+	rows, _ := d.lease("queue", d.BatchSize)
+	assert.Len(t, rows, 1)
+	assert.Nil(t, d.Close())
+	err = d.handle("queue", rows[0], func([]byte, func(time.Duration) error) ack.Result { return ack.Ack() })
+	assert.NotNil(t, err)
+}
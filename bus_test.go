@@ -1,15 +1,28 @@
 package bus
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/easy-bus/bus/ack"
+	"github.com/easy-bus/bus/clock"
+	"github.com/easy-bus/bus/memdriver"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/websocket"
 )
 
 type User struct {
@@ -46,7 +59,7 @@ func prepare() {
 	itDLS = &internalDLStorage{}
 	itTXS = &internalTXStorage{}
 	driver = &mockDriver{
-		itd: &internalDriver{},
+		itd: &memdriver.Driver{},
 	}
 	sender = Sender{
 		Topic:  "sender.basic",
@@ -92,7 +105,7 @@ func TestIdempotent(t *testing.T) {
 	handler.Idempotent = &internalIdempotent{}
 	handler.HandleFunc = func(msg *Message) bool {
 		atomic.AddUint32(&num1, 1)
-		assert.EqualValues(t, originMsg, msg)
+		assert.EqualValues(t, originMsg, withoutHops(msg))
 		exitChan <- struct{}{}
 		return true
 	}
@@ -121,7 +134,7 @@ func TestDLStorage(t *testing.T) {
 	originMsg := MessageAutoId("message.dl-storage", "")
 	handler.DLStorage = itDLS
 	handler.HandleFunc = func(msg *Message) bool {
-		assert.EqualValues(t, originMsg, msg)
+		assert.EqualValues(t, originMsg, withoutHops(msg))
 		return false
 	}
 	handler.EnsureFunc = func(msg *Message) bool {
@@ -138,7 +151,25 @@ func TestDLStorage(t *testing.T) {
 	<-exitChan
 	cancelFunc()
 	handler.Wait()
-	assert.Equal(t, encode(originMsg), itDLS.dataMap[handler.Queue]["0"])
+	assert.Eventually(t, func() bool {
+		rows, _ := itDLS.Fetch(handler.Queue)
+		return len(rows) == 1
+	}, time.Second, time.Millisecond)
+	var dlMsg Message
+	rows, _ := itDLS.Fetch(handler.Queue)
+	decode(rows["0"], &dlMsg)
+	assert.Equal(t, 1, dlMsg.Retried)
+	assert.Len(t, dlMsg.Hops, 1)
+	assert.Equal(t, handler.Queue, dlMsg.Hops[0].Queue)
+	assert.Equal(t, 1, dlMsg.Hops[0].Attempt)
+	dlMsg.Retried = 0
+	assert.EqualValues(t, originMsg, withoutHops(&dlMsg))
+}
+
+func withoutHops(msg *Message) *Message {
+	m := *msg
+	m.Hops = nil
+	return &m
 }
 
 func TestTransaction(t *testing.T) {
@@ -188,3 +219,1946 @@ func TestTransaction(t *testing.T) {
 	assert.Equal(t, uint32(0), num1)
 	assert.Equal(t, uint32(3), num2)
 }
+
+func TestSchemaValidator(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	schema, err := ParseJSONSchema([]byte(`{
+		"type": "object",
+		"required": ["Id", "Name"],
+		"properties": {
+			"Id": {"type": "string", "pattern": "^u[0-9]+$"},
+			"Name": {"type": "string", "minLength": 1},
+			"Info": {"type": ["object", "null"], "additionalProperties": false, "properties": {"age": {"type": "string"}}}
+		}
+	}`))
+	assert.Nil(t, err)
+	assert.Len(t, schema.patterns, 1) // 正则表达式在解析时编译
+	assert.Nil(t, schema.Validate(encode(User{Id: "u1", Name: "Jim"})))
+	assert.NotNil(t, schema.Validate(encode(User{Id: "x1", Name: "Jim"})))
+	assert.NotNil(t, schema.Validate(encode(User{Id: "u1"})))
+	assert.NotNil(t, schema.Validate(encode(User{Id: "u1", Name: "Jim", Info: map[string]string{"sex": "Male"}})))
+	_, err = ParseJSONSchema([]byte(`{"pattern": "("}`))
+	assert.NotNil(t, err)
+
+	exitChan := make(chan struct{})
+	sender.Validator = schema
+	handler.Validator = schema
+	handler.DLStorage = itDLS
+	handler.HandleFunc = func(msg *Message) bool {
+		exitChan <- struct{}{}
+		return true
+	}
+	handler.EnsureFunc = func(msg *Message) bool {
+		return true
+	}
+	sender.Prepare()
+	handler.Prepare()
+	assert.NotNil(t, sender.Send(MessageAutoId(User{Id: "u1"}, "")))
+	assert.Nil(t, sender.Send(MessageAutoId(User{Id: "u1", Name: "Jim"}, "")))
+	invalid := MessageAutoId(User{Id: "x1"}, "")
+	assert.True(t, handler.handleMsg(encode(invalid)))
+	var dlMsg Message
+	decode(itDLS.dataMap[handler.Queue]["0"], &dlMsg)
+	assert.EqualValues(t, invalid, withoutHops(&dlMsg))
+	var ctx, cancelFunc = context.WithCancel(context.TODO())
+	go handler.RunCtx(ctx)
+	<-exitChan
+	cancelFunc()
+	handler.Wait()
+}
+
+func TestUpcaster(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	var received []User
+	handler.Version = 2
+	handler.DLStorage = itDLS
+	handler.Upcasters = map[int]Upcaster{
+		0: func(payload []byte) ([]byte, error) {
+			var name string
+			decode(payload, &name)
+			return encode(User{Name: name}), nil
+		},
+		1: func(payload []byte) ([]byte, error) {
+			var u User
+			decode(payload, &u)
+			u.Id = "u1"
+			return encode(u), nil
+		},
+	}
+	handler.HandleFunc = func(msg *Message) bool {
+		var u User
+		msg.Scan(&u)
+		received = append(received, u)
+		assert.Equal(t, 2, msg.Version)
+		return true
+	}
+	handler.EnsureFunc = func(msg *Message) bool {
+		return true
+	}
+	sender.Prepare()
+	handler.Prepare()
+	assert.True(t, handler.handleMsg(encode(MessageAutoId("Jim", ""))))
+	m1 := MessageAutoId(User{Name: "Tom"}, "")
+	m1.Version = 1
+	assert.True(t, handler.handleMsg(encode(m1)))
+	assert.Equal(t, []User{{Id: "u1", Name: "Jim"}, {Id: "u1", Name: "Tom"}}, received)
+	m3 := MessageAutoId(User{}, "")
+	m3.Version = 3
+	assert.True(t, handler.handleMsg(encode(m3)))
+	var dlMsg Message
+	decode(itDLS.dataMap[handler.Queue]["0"], &dlMsg)
+	assert.EqualValues(t, m3, withoutHops(&dlMsg))
+	assert.Len(t, received, 2)
+}
+
+type recordArchive struct {
+	sync.Mutex
+	records []*ArchiveRecord
+}
+
+func (ra *recordArchive) Store(record *ArchiveRecord) error {
+	ra.Lock()
+	defer ra.Unlock()
+	ra.records = append(ra.records, record)
+	return nil
+}
+
+func TestArchive(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	archive := &recordArchive{}
+	sender.Archive = archive
+	handler.Archive = archive
+	handler.DLStorage = itDLS
+	handler.HandleFunc = func(msg *Message) bool {
+		var u User
+		msg.Scan(&u)
+		return u.Name != ""
+	}
+	handler.EnsureFunc = func(msg *Message) bool {
+		return true
+	}
+	sender.Prepare()
+	handler.Prepare()
+	m1 := MessageAutoId(User{Id: "u1", Name: "Jim"}, "")
+	assert.Nil(t, sender.Send(m1))
+	assert.True(t, handler.handleMsg(encode(m1)))
+	assert.True(t, handler.handleMsg(encode(MessageAutoId(User{Id: "u2"}, ""))))
+	assert.Len(t, archive.records, 3)
+	assert.Equal(t, SourceSender, archive.records[0].Source)
+	assert.Equal(t, OutcomeSent, archive.records[0].Outcome)
+	assert.Equal(t, encode(m1), archive.records[0].Data)
+	assert.Equal(t, SourceHandler, archive.records[1].Source)
+	assert.Equal(t, OutcomeDone, archive.records[1].Outcome)
+	assert.Equal(t, OutcomeDead, archive.records[2].Outcome)
+	assert.False(t, archive.records[2].FinishedAt.Before(archive.records[2].StartedAt))
+}
+
+func TestHandlerOpt(t *testing.T) {
+	prepare()
+	var h Handler
+	for _, opt := range []HandlerOpt{
+		WithSubscribe("topic", "key"),
+		WithDriver(driver),
+		WithDLStorage(itDLS),
+		WithUpcaster(0, func(payload []byte) ([]byte, error) { return payload, nil }),
+		WithVersion(1),
+		WithConcurrency(2),
+	} {
+		opt(&h)
+	}
+	assert.Equal(t, Subscribe{Topic: "topic", RouteKey: "key"}, h.Subscribe)
+	assert.Equal(t, driver, h.Driver)
+	assert.Equal(t, itDLS, h.DLStorage)
+	assert.Len(t, h.Upcasters, 1)
+	assert.Equal(t, 1, h.Version)
+	var running, peak int32
+	var wg sync.WaitGroup
+	fn := h.limit(context.TODO(), func([]byte, Extender) Result {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return Ack()
+	})
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(nil, nil)
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 2, peak)
+}
+
+func TestSendAsync(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	var ctx, cancelFunc = context.WithCancel(context.TODO())
+	var sent int32
+	errChan := make(chan error, 1)
+	sender.Context = ctx
+	sender.Prepare()
+	for i := 0; i < 5; i++ {
+		sender.SendAsync(MessageAutoId(i, ""), func(err error) {
+			assert.Nil(t, err)
+			atomic.AddInt32(&sent, 1)
+		})
+	}
+	cancelFunc()
+	sender.Wait()
+	assert.EqualValues(t, 5, sent)
+	sender.SendAsync(MessageAutoId(5, ""), func(err error) { errChan <- err })
+	assert.NotNil(t, <-errChan)
+	driver.AssertNumberOfCalls(t, "SendToTopic", 5)
+}
+
+func TestPipeline(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	pool := []*mockDriver{
+		{itd: &memdriver.Driver{Capacity: 16}},
+		{itd: &memdriver.Driver{Capacity: 16}},
+	}
+	for _, d := range pool {
+		d.On("CreateTopic", mock.Anything).Return(nil)
+		d.On("SendToTopic", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		sender.DriverPool = append(sender.DriverPool, d)
+	}
+	var ctx, cancelFunc = context.WithCancel(context.TODO())
+	sender.Context = ctx
+	sender.Pipeline = true
+	sender.AsyncWorkers = 2
+	sender.Prepare()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, sender.Send(MessageAutoId(i, "")))
+		}(i)
+	}
+	wg.Wait()
+	cancelFunc()
+	sender.Wait()
+	driver.AssertNotCalled(t, "SendToTopic", mock.Anything, mock.Anything, mock.Anything)
+	assert.Equal(t, 8, len(pool[0].Calls)+len(pool[1].Calls)-2)
+}
+
+func TestDLRetrier(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	var handled int32
+	handler.DLStorage = itDLS
+	handler.EnsureFunc = func(msg *Message) bool { return true }
+	handler.HandleFunc = func(msg *Message) bool {
+		atomic.AddInt32(&handled, 1)
+		return true
+	}
+	sender.Prepare()
+	handler.Prepare()
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, itDLS.Store(handler.Queue, encode(MessageAutoId(i, ""))))
+	}
+	retrier := &DLRetrier{Concurrency: 2, RateLimit: 100}
+	start := time.Now()
+	assert.Equal(t, 0, retrier.redrive(context.TODO(), &handler))
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+	assert.EqualValues(t, 5, handled)
+	rows, _ := itDLS.Fetch(handler.Queue)
+	assert.Len(t, rows, 0)
+
+	var ctx, cancelFunc = context.WithCancel(context.TODO())
+	backoff := make(chan int, 1)
+	retrier = &DLRetrier{
+		Interval: time.Millisecond,
+		Backoff: func(failures int) time.Duration {
+			backoff <- failures
+			cancelFunc()
+			return 0
+		},
+	}
+	handler.HandleFunc = func(msg *Message) bool { return false }
+	assert.Nil(t, itDLS.Store(handler.Queue, encode(MessageAutoId(5, ""))))
+	retrier.run(ctx, &handler)
+	assert.Equal(t, 1, <-backoff) // 处理失败计入退避
+	rows, _ = itDLS.Fetch(handler.Queue)
+	assert.Len(t, rows, 1) // 保留原死信, 不重复转入
+
+	// 尚有重试次数时也不重新投递
+	handler.RetryDelay = func(int) time.Duration { return time.Second }
+	assert.Equal(t, 1, retrier.redrive(context.TODO(), &handler))
+	driver.AssertNotCalled(t, "SendToQueue", handler.Queue, mock.Anything, time.Second)
+	rows, _ = itDLS.Fetch(handler.Queue)
+	assert.Len(t, rows, 1)
+	handler.HandleFunc = func(msg *Message) bool { return true }
+	assert.Equal(t, 0, retrier.redrive(context.TODO(), &handler))
+	rows, _ = itDLS.Fetch(handler.Queue)
+	assert.Len(t, rows, 0)
+}
+
+type failedDLStorage struct {
+	*internalDLStorage
+}
+
+func (fd *failedDLStorage) Store(queue string, data []byte) error {
+	return errors.New("mock error")
+}
+
+func TestRetryAfter(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	handler.DLStorage = itDLS
+	handler.EnsureFunc = func(msg *Message) bool { return true }
+	handler.HandleFunc = func(msg *Message) bool {
+		var delay time.Duration
+		msg.Scan(&delay)
+		if d, ok := RetryDelayOf(RetryAfter(errors.New("rate limited"), delay)); ok {
+			msg.RetryAfter(d)
+		}
+		return false
+	}
+	sender.Prepare()
+	handler.Prepare()
+	assert.True(t, handler.handleMsg(encode(MessageAutoId(time.Hour, ""))))
+	driver.AssertCalled(t, "SendToQueue", handler.Queue, mock.Anything, time.Hour)
+	assert.True(t, handler.handleMsg(encode(MessageAutoId(time.Duration(-1), ""))))
+	assert.Len(t, itDLS.dataMap[handler.Queue], 1)
+	_, ok := RetryDelayOf(errors.New("error"))
+	assert.False(t, ok)
+}
+
+func TestRoutingDriver(t *testing.T) {
+	d1, d2 := &memdriver.Driver{}, &memdriver.Driver{}
+	rd := &RoutingDriver{
+		Routes:  []Route{{Topic: "order.*", Queue: "order.*", Driver: d1}},
+		Default: d2,
+	}
+	assert.Nil(t, rd.CreateTopic("order.created"))
+	assert.Nil(t, rd.CreateTopic("user.created"))
+	assert.Nil(t, rd.CreateQueue("order.notify", 0))
+	assert.Nil(t, rd.CreateQueue("user.notify", 0))
+	assert.Nil(t, rd.Subscribe("order.created", "order.notify", ""))
+	assert.Nil(t, rd.Subscribe("user.created", "user.notify", ""))
+	assert.NotNil(t, rd.Subscribe("order.created", "user.notify", ""))
+	assert.Nil(t, rd.SendToTopic("order.created", []byte("o"), ""))
+	assert.Nil(t, rd.SendToTopic("user.created", []byte("u"), ""))
+	assert.Nil(t, rd.SendToQueue("user.notify", []byte("u"), 0))
+	assert.Equal(t, 1, d1.QueueDepth("order.notify"))
+	assert.Equal(t, 2, d2.QueueDepth("user.notify"))
+	assert.Equal(t, 0, d2.QueueDepth("order.notify"))
+	assert.NotNil(t, (&RoutingDriver{}).CreateTopic("any"))
+}
+
+func TestFailoverDriver(t *testing.T) {
+	primary := &mockDriver{itd: &memdriver.Driver{}}
+	secondary := &mockDriver{itd: &memdriver.Driver{}}
+	for _, d := range []*mockDriver{primary, secondary} {
+		d.On("CreateTopic", mock.Anything).Return(nil)
+		d.On("CreateQueue", mock.Anything, mock.Anything).Return(nil)
+		d.On("Subscribe", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	}
+	primary.On("SendToTopic", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("mock error")).Once()
+	primary.On("SendToTopic", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	secondary.On("SendToTopic", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	var recovered int32
+	fd := &FailoverDriver{
+		Primary:       primary,
+		Secondary:     secondary,
+		ProbeInterval: time.Millisecond,
+		Probe: func(driver DriverInterface) error {
+			if atomic.LoadInt32(&recovered) == 0 {
+				return errors.New("still down")
+			}
+			return nil
+		},
+	}
+	assert.Nil(t, fd.CreateTopic("topic"))
+	assert.Nil(t, fd.CreateQueue("queue", 0))
+	assert.Nil(t, fd.Subscribe("topic", "queue", ""))
+	assert.Nil(t, fd.SendToTopic("topic", []byte("a"), ""))
+	assert.False(t, fd.Healthy())
+	assert.Nil(t, fd.SendToTopic("topic", []byte("b"), ""))
+	primary.AssertNumberOfCalls(t, "SendToTopic", 1)
+	secondary.AssertNumberOfCalls(t, "SendToTopic", 2)
+	atomic.StoreInt32(&recovered, 1)
+	for !fd.Healthy() {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Nil(t, fd.SendToTopic("topic", []byte("c"), ""))
+	primary.AssertNumberOfCalls(t, "SendToTopic", 2)
+
+	var ctx, cancelFunc = context.WithCancel(context.TODO())
+	received := make(chan string, 3)
+	go fd.ReceiveMessage(ctx, "queue", make(chan error), func(data []byte) Result {
+		received <- string(data)
+		return Ack()
+	})
+	got := []string{<-received, <-received, <-received}
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, got)
+	cancelFunc()
+	assert.Nil(t, fd.Close())
+}
+
+func TestFailoverDriverTrial(t *testing.T) {
+	primary := &mockDriver{itd: &memdriver.Driver{}}
+	secondary := &mockDriver{itd: &memdriver.Driver{}}
+	primary.On("SendToQueue", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("mock error")).Twice()
+	primary.On("SendToQueue", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	secondary.On("SendToQueue", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	assert.Nil(t, primary.itd.CreateQueue("queue", 0))
+	assert.Nil(t, secondary.itd.CreateQueue("queue", 0))
+	mc := clock.NewMock(time.Now())
+	fd := &FailoverDriver{Primary: primary, Secondary: secondary, ProbeInterval: time.Second, Clock: mc}
+	assert.Nil(t, fd.SendToQueue("queue", []byte("a"), 0))
+	assert.False(t, fd.Healthy())
+	assert.False(t, fd.probing) // 未指定Probe时不启动探测协程
+	assert.Nil(t, fd.SendToQueue("queue", []byte("b"), 0))
+	primary.AssertNumberOfCalls(t, "SendToQueue", 1)
+	mc.Add(time.Second)
+	assert.Nil(t, fd.SendToQueue("queue", []byte("c"), 0)) // 试探失败, 继续使用备用驱动
+	assert.False(t, fd.Healthy())
+	primary.AssertNumberOfCalls(t, "SendToQueue", 2)
+	secondary.AssertNumberOfCalls(t, "SendToQueue", 3)
+	mc.Add(time.Second)
+	assert.Nil(t, fd.SendToQueue("queue", []byte("d"), 0))
+	assert.True(t, fd.Healthy())
+	secondary.AssertNumberOfCalls(t, "SendToQueue", 3)
+}
+
+func TestFailoverDriverClose(t *testing.T) {
+	primary := &mockDriver{itd: &memdriver.Driver{}}
+	secondary := &mockDriver{itd: &memdriver.Driver{}}
+	primary.On("SendToQueue", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("mock error"))
+	secondary.On("SendToQueue", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	assert.Nil(t, secondary.itd.CreateQueue("queue", 0))
+	var probes int32
+	mc := clock.NewMock(time.Now())
+	fd := &FailoverDriver{
+		Primary:       primary,
+		Secondary:     secondary,
+		ProbeInterval: time.Second,
+		Clock:         mc,
+		Probe: func(driver DriverInterface) error {
+			atomic.AddInt32(&probes, 1)
+			return errors.New("still down")
+		},
+	}
+	assert.Nil(t, fd.SendToQueue("queue", []byte("a"), 0))
+	mc.BlockUntil(1)
+	mc.Add(time.Second)
+	mc.BlockUntil(1)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&probes))
+	assert.Nil(t, fd.Close())
+	assert.Nil(t, fd.Close())
+	assert.Eventually(t, func() bool {
+		fd.mu.RLock()
+		defer fd.mu.RUnlock()
+		return !fd.probing
+	}, time.Second, time.Millisecond)
+	assert.Nil(t, fd.SendToQueue("queue", []byte("b"), 0))
+	fd.mu.RLock()
+	assert.False(t, fd.probing) // 关闭后不再启动探测
+	fd.mu.RUnlock()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&probes))
+}
+
+func TestMirrorDriver(t *testing.T) {
+	primary := &memdriver.Driver{}
+	mirror := &mockDriver{itd: &memdriver.Driver{}}
+	mirror.On("CreateTopic", mock.Anything).Return(nil)
+	mirror.On("CreateQueue", mock.Anything, mock.Anything).Return(nil)
+	mirror.On("Subscribe", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mirror.On("SendToQueue", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mirror.On("SendToTopic", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	mirror.On("SendToTopic", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("mock error"))
+	md := &MirrorDriver{Primary: primary, Mirror: mirror}
+	assert.Nil(t, md.CreateTopic("topic"))
+	assert.Nil(t, md.CreateQueue("queue", 0))
+	assert.Nil(t, md.Subscribe("topic", "queue", ""))
+	assert.Nil(t, md.SendToTopic("topic", []byte("a"), ""))
+	assert.Nil(t, md.SendToTopic("topic", []byte("b"), ""))
+	assert.Nil(t, md.SendToQueue("queue", []byte("c"), 0))
+	assert.NotNil(t, md.SendToQueue("missing", []byte("d"), 0))
+	assert.Equal(t, []MirrorParity{
+		{Kind: "queue", Name: "queue", Primary: 1, Mirrored: 1},
+		{Kind: "topic", Name: "topic", Primary: 2, Mirrored: 1, Failed: 1},
+	}, md.Parity())
+	assert.False(t, md.Parity()[1].Consistent())
+	assert.Equal(t, 3, primary.QueueDepth("queue"))
+	assert.Equal(t, 2, mirror.itd.QueueDepth("queue"))
+}
+
+func TestWrapperCapabilities(t *testing.T) {
+	d1, d2 := &memdriver.Driver{}, &memdriver.Driver{}
+	native := CapabilityDeadLetter | CapabilityConfirm | CapabilitySchedule
+	assert.Equal(t, native, Capabilities(d1))
+
+	rd := &RoutingDriver{Routes: []Route{{Topic: "order.*", Queue: "order.*", Driver: d1}}, Default: d2}
+	assert.Equal(t, native, Capabilities(rd))
+	assert.Nil(t, rd.CreateTopic("order.created"))
+	assert.Nil(t, rd.CreateQueueWithDL("order.notify", 0, ""))
+	assert.Nil(t, rd.Subscribe("order.created", "order.notify", ""))
+	id, err := rd.SendToTopicConfirmed("order.created", []byte("a"), "")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, id)
+	assert.Equal(t, 1, d1.QueueDepth("order.notify"))
+	rd.Default = &mockDriver{itd: &memdriver.Driver{}}
+	assert.Equal(t, Capability(0), Capabilities(rd))
+	_, err = rd.SendToTopicConfirmed("user.created", []byte("b"), "")
+	assert.NotNil(t, err)
+
+	fd := &FailoverDriver{Primary: d1, Secondary: d2}
+	assert.Equal(t, native, Capabilities(fd))
+	assert.Nil(t, fd.CreateTopic("topic"))
+	assert.Nil(t, fd.CreateQueueWithDL("queue", 0, ""))
+	assert.Nil(t, fd.Subscribe("topic", "queue", ""))
+	id, err = fd.SendToTopicConfirmed("topic", []byte("a"), "")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, id)
+	assert.Nil(t, fd.SendToTopicAt("topic", []byte("b"), "", time.Now()))
+	assert.Equal(t, 2, d1.QueueDepth("queue"))
+	assert.Equal(t, Capability(0), Capabilities(&FailoverDriver{Primary: d1, Secondary: &mockDriver{}}))
+
+	mirror := &mockDriver{itd: &memdriver.Driver{}}
+	mirror.On("SendToTopic", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	md := &MirrorDriver{Primary: d2, Mirror: mirror}
+	assert.Equal(t, CapabilityConfirm, Capabilities(md))
+	id, err = md.SendToTopicConfirmed("topic", []byte("c"), "")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, id)
+	assert.Nil(t, md.SendToTopicAt("topic", []byte("d"), "", time.Now()))
+	assert.Equal(t, []MirrorParity{{Kind: "topic", Name: "topic", Primary: 2, Mirrored: 1, Failed: 1}}, md.Parity())
+	assert.Equal(t, native, Capabilities(&MirrorDriver{Primary: d1, Mirror: d2}))
+}
+
+func TestHops(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	var hops [][]Hop
+	handler.EnsureFunc = func(msg *Message) bool { return true }
+	handler.RetryDelay = func(attempts int) time.Duration { return 0 }
+	handler.HandleFunc = func(msg *Message) bool {
+		hops = append(hops, msg.Hops)
+		time.Sleep(time.Millisecond)
+		return len(msg.Hops) == 3
+	}
+	sender.Prepare()
+	handler.Prepare()
+	data := encode(MessageAutoId("message.hops", ""))
+	for i := 0; i < 3; i++ {
+		assert.True(t, handler.handleMsg(data))
+		data = driver.Calls[len(driver.Calls)-1].Arguments.Get(1).([]byte)
+	}
+	assert.Len(t, hops, 3)
+	for i, hop := range hops[2] {
+		assert.Equal(t, handler.Queue, hop.Queue)
+		assert.Equal(t, i+1, hop.Attempt)
+		if i < 2 {
+			assert.True(t, hop.Cost >= time.Millisecond)
+		}
+	}
+}
+
+func TestDLJanitor(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	handler.DLStorage = itDLS
+	sender.Prepare()
+	handler.Prepare()
+	assert.Nil(t, itDLS.Store(handler.Queue, []byte("old")))
+	assert.Nil(t, itDLS.Store(handler.Queue, []byte("archive-failed")))
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, itDLS.Store(handler.Queue, []byte("new")))
+	var archived []string
+	janitor := &DLJanitor{
+		Retention: 10 * time.Millisecond,
+		ArchiveFunc: func(queue, id string, data []byte) error {
+			if string(data) == "archive-failed" {
+				return errors.New("mock error")
+			}
+			archived = append(archived, string(data))
+			return nil
+		},
+	}
+	assert.Equal(t, 1, janitor.clean(&handler))
+	assert.Equal(t, []string{"old"}, archived)
+	rows, _ := itDLS.Fetch(handler.Queue)
+	assert.Len(t, rows, 2)
+
+	// 按时钟判断过期, 定时清理由时钟驱动
+	past := &DLJanitor{Retention: time.Millisecond, Clock: clock.NewMock(time.Now().Add(-time.Hour))}
+	assert.Equal(t, 0, past.clean(&handler))
+	mc := clock.NewMock(time.Now().Add(time.Hour))
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan struct{})
+	go func() {
+		(&DLJanitor{Retention: time.Minute, Interval: time.Minute, Clock: mc}).run(ctx, &handler)
+		close(done)
+	}()
+	mc.BlockUntil(1)
+	mc.Add(time.Minute)
+	mc.BlockUntil(1) // 清理完成后重新计时
+	rows, _ = itDLS.Fetch(handler.Queue)
+	assert.Len(t, rows, 0)
+	cancel()
+	<-done
+
+	// 死信存储不支持过期清理
+	plain := &struct{ DLStorageInterface }{itDLS}
+	assert.Equal(t, 0, janitor.clean(&Handler{Queue: handler.Queue, DLStorage: plain}))
+	assert.Panics(t, func() {
+		(&Handler{
+			Queue:      "janitor.plain",
+			Driver:     &memdriver.Driver{},
+			DLStorage:  plain,
+			DLJanitor:  janitor,
+			HandleFunc: func(*Message) bool { return true },
+		}).Prepare()
+	})
+}
+
+type errorDriver struct {
+	DriverInterface
+	err error
+}
+
+func (ed errorDriver) ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) Result) {
+	errChan <- ed.err
+	<-ctx.Done()
+}
+
+func TestErrorHandler(t *testing.T) {
+	var queues []string
+	h := &Handler{
+		Queue:      "test.error-handler",
+		Driver:     errorDriver{DriverInterface: &memdriver.Driver{}, err: errors.New("mock error")},
+		HandleFunc: func(*Message) bool { return true },
+		ErrorHandler: ErrorHandlerFunc(func(queue string, err error) ErrorDecision {
+			queues = append(queues, queue)
+			return ErrorStop
+		}),
+	}
+	h.Prepare()
+	done := make(chan struct{})
+	go func() {
+		h.Run()
+		close(done)
+	}()
+	h.Wait()
+	<-done
+	assert.Equal(t, []string{"test.error-handler"}, queues)
+}
+
+func TestRetryScheduler(t *testing.T) {
+	_, err := ParseCron("* * *")
+	assert.NotNil(t, err)
+	_, err = ParseCron("60 * * * *")
+	assert.NotNil(t, err)
+	cron, err := ParseCron("*/15 9-17 * * 1-5")
+	assert.Nil(t, err)
+	// 2024-06-07 为周五
+	base := time.Date(2024, 6, 7, 17, 50, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 6, 10, 9, 0, 0, 0, time.UTC), cron.Next(base))
+	assert.Equal(t, time.Date(2024, 6, 7, 9, 15, 0, 0, time.UTC), cron.Next(base.Add(-8*time.Hour-45*time.Minute)))
+	cron, _ = ParseCron("0 0 1 * 0")
+	assert.Equal(t, time.Date(2024, 6, 9, 0, 0, 0, 0, time.UTC), cron.Next(base))
+
+	prepare()
+	mockAllNormal()
+	handler.DLStorage = itDLS
+	sender.Prepare()
+	handler.Prepare()
+	assert.Nil(t, itDLS.Store(handler.Queue, []byte("dead")))
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	retrier := &DLRetrier{Scheduler: &DelayScheduler{Interval: 10 * time.Millisecond, Delay: time.Second}}
+	retrier.run(ctx, &handler)
+	driver.AssertCalled(t, "SendToQueue", handler.Queue, []byte("dead"), time.Second)
+	rows, _ := itDLS.Fetch(handler.Queue)
+	assert.Len(t, rows, 0)
+}
+
+func TestFilterFunc(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	var handled int32
+	archive := &recordArchive{}
+	handler.Archive = archive
+	handler.EnsureFunc = func(msg *Message) bool { return true }
+	handler.FilterFunc = func(raw []byte) bool { return !bytes.Contains(raw, []byte("skip")) }
+	handler.HandleFunc = func(msg *Message) bool {
+		atomic.AddInt32(&handled, 1)
+		return true
+	}
+	sender.Prepare()
+	handler.Prepare()
+	assert.True(t, handler.handleMsg([]byte("skip, not a json")))
+	assert.True(t, handler.handleMsg(encode(MessageWithId("1", "keep", ""))))
+	assert.EqualValues(t, 1, handled)
+	assert.Equal(t, OutcomeFiltered, archive.records[0].Outcome)
+	assert.Equal(t, OutcomeDone, archive.records[1].Outcome)
+}
+
+func TestPipe(t *testing.T) {
+	md := &memdriver.Driver{}
+	assert.Nil(t, md.CreateTopic("pipe.in"))
+	pipe := &Pipe{
+		Source: &Handler{
+			Queue:     "pipe.in.queue",
+			Driver:    md,
+			Subscribe: Subscribe{Topic: "pipe.in"},
+		},
+		Target: &Sender{Topic: "pipe.out", Driver: md},
+		Transform: func(msg *Message) (*Message, error) {
+			var n int
+			msg.Scan(&n)
+			switch {
+			case n < 0:
+				return nil, RetryAfter(errors.New("negative"), time.Hour)
+			case n%2 == 1:
+				return nil, nil
+			}
+			msg.Payload = encode(n * 10)
+			return msg, nil
+		},
+	}
+	pipe.Prepare()
+	assert.Nil(t, md.CreateQueue("pipe.out.queue", 0))
+	assert.Nil(t, md.Subscribe("pipe.out", "pipe.out.queue", ""))
+	msg := MessageWithId("1", 2, "")
+	msg.Retried = 3
+	assert.True(t, pipe.handle(msg))
+	assert.True(t, pipe.handle(MessageWithId("2", 3, "")))
+	assert.Equal(t, 1, md.QueueDepth("pipe.out.queue"))
+	failed := MessageWithId("3", -1, "")
+	assert.False(t, pipe.handle(failed))
+	assert.Equal(t, time.Hour, *failed.retryDelay)
+
+	var out Message
+	ctx, cancel := context.WithCancel(context.TODO())
+	md.ReceiveMessage(ctx, "pipe.out.queue", nil, func(data []byte) Result {
+		decode(data, &out)
+		cancel()
+		return Ack()
+	})
+	assert.Equal(t, []byte("20"), out.Payload)
+	assert.Equal(t, 0, out.Retried)
+}
+
+func TestGroup(t *testing.T) {
+	md := &memdriver.Driver{}
+	var handled int32
+	group := &Group{}
+	group.AddSender(&Sender{Topic: "group.topic", Driver: md})
+	group.AddHandler(&Handler{
+		Queue:      "group.queue",
+		Driver:     md,
+		Subscribe:  Subscribe{Topic: "group.topic"},
+		EnsureFunc: func(*Message) bool { return true },
+		HandleFunc: func(*Message) bool {
+			atomic.AddInt32(&handled, 1)
+			return true
+		},
+	})
+	assert.Nil(t, group.Start(context.TODO()))
+	assert.NotNil(t, group.Start(context.TODO()))
+	assert.Nil(t, group.senders[0].Send(MessageWithId("1", 1, "")))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&handled) == 1 }, time.Second, time.Millisecond)
+	assert.Nil(t, group.Shutdown(context.TODO()))
+	assert.Nil(t, group.Shutdown(context.TODO()))
+
+	broken := (&Group{}).AddSender(&Sender{Topic: "group.broken"}).AddHandler(&Handler{Queue: "group.broken"})
+	err := broken.Start(context.TODO())
+	assert.Len(t, err, 2)
+	assert.Contains(t, err.Error(), "missing driver instance")
+}
+
+func TestNewMessage(t *testing.T) {
+	m := NewMessage("p", MessageRouteKey("k"), MessageHeader("trace", "t1"), MessagePriority(3))
+	assert.NotEmpty(t, m.BizUID)
+	assert.Equal(t, "k", m.RouteKey)
+	assert.Equal(t, "t1", m.Header("trace"))
+	assert.Equal(t, 3, m.Priority)
+	m2 := new(Message)
+	decode(encode(m), m2)
+	assert.Equal(t, m, m2)
+	assert.Equal(t, "id", NewMessage(nil, MessageID("id")).BizUID)
+
+	prepare()
+	mockAllNormal()
+	var handled int32
+	archive := &recordArchive{}
+	handler.Archive = archive
+	handler.EnsureFunc = func(msg *Message) bool { return true }
+	handler.HandleFunc = func(msg *Message) bool {
+		atomic.AddInt32(&handled, 1)
+		return true
+	}
+	sender.Prepare()
+	handler.Prepare()
+	expired := encode(NewMessage(1, MessageTTL(-time.Second)))
+	assert.True(t, handler.handleMsg(expired))
+	delayed := encode(NewMessage(2, MessageDelay(time.Hour)))
+	result := handler.handleMsgExtend(delayed, nil)
+	assert.Equal(t, ack.ActionRetry, result.Action)
+	assert.True(t, result.Delay > 59*time.Minute && result.Delay <= time.Hour)
+	assert.True(t, handler.handleMsg(encode(NewMessage(3, MessageTTL(time.Hour), MessageDelay(-time.Second)))))
+	assert.EqualValues(t, 1, handled)
+	assert.Equal(t, OutcomeExpired, archive.records[0].Outcome)
+	assert.Equal(t, OutcomeDeferred, archive.records[1].Outcome)
+	assert.Equal(t, OutcomeDone, archive.records[2].Outcome)
+
+	// 时钟快进后延迟消息到期可处理, TTL消息过期
+	handler.Clock = clock.NewMock(time.Now().Add(2 * time.Hour))
+	assert.True(t, handler.handleMsg(delayed))
+	assert.True(t, handler.handleMsg(encode(NewMessage(4, MessageTTL(time.Hour)))))
+	assert.EqualValues(t, 2, handled)
+	assert.Equal(t, OutcomeDone, archive.records[3].Outcome)
+	assert.Equal(t, OutcomeExpired, archive.records[4].Outcome)
+}
+
+func TestMessageContentId(t *testing.T) {
+	u := User{Id: "u1", Name: "Jim", Info: map[string]string{"b": "2", "a": "1"}}
+	m1 := MessageContentId(u, "k")
+	m2 := MessageContentId(User{Id: "u1", Name: "Jim", Info: map[string]string{"a": "1", "b": "2"}}, "k")
+	assert.Equal(t, m1.BizUID, m2.BizUID)
+	assert.Len(t, m1.BizUID, 64)
+	assert.NotEqual(t, m1.BizUID, MessageContentId(u, "other").BizUID)
+	u.Name = "Tom"
+	assert.NotEqual(t, m1.BizUID, MessageContentId(u, "k").BizUID)
+}
+
+func TestHeartbeat(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	var extended int32
+	extend := func(d time.Duration) error {
+		assert.Equal(t, 10*time.Millisecond, d)
+		atomic.AddInt32(&extended, 1)
+		return nil
+	}
+	handler.Heartbeat = 5 * time.Millisecond
+	handler.EnsureFunc = func(msg *Message) bool { return true }
+	handler.HandleFunc = func(msg *Message) bool {
+		assert.Nil(t, msg.Extend(10*time.Millisecond))
+		time.Sleep(22 * time.Millisecond)
+		return true
+	}
+	sender.Prepare()
+	handler.Prepare()
+	assert.True(t, handler.handleMsgExtend(encode(MessageWithId("1", 1, "")), extend).Acked())
+	n := atomic.LoadInt32(&extended)
+	assert.True(t, n >= 3, n)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&extended))
+	assert.Equal(t, ErrExtendUnsupported, new(Message).Extend(time.Second))
+}
+
+func TestHeartbeatClock(t *testing.T) {
+	mc := clock.NewMock(time.Now())
+	h := &Handler{Queue: "test.heartbeat", Heartbeat: time.Minute, Clock: mc}
+	extended := make(chan time.Duration, 1)
+	stop := h.heartbeat(func(d time.Duration) error {
+		extended <- d
+		return nil
+	})
+	for i := 0; i < 2; i++ {
+		mc.BlockUntil(1)
+		mc.Add(time.Minute)
+		assert.Equal(t, 2*time.Minute, <-extended)
+	}
+	stop()
+}
+
+func TestResult(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	handler.DLStorage = &failedDLStorage{internalDLStorage: itDLS}
+	handler.EnsureFunc = func(msg *Message) bool { return true }
+	handler.HandleFunc = func(msg *Message) bool { return false }
+	sender.Prepare()
+	handler.Prepare()
+	result := handler.handleMsgExtend(encode(MessageWithId("1", 1, "")), nil)
+	assert.Equal(t, ack.ActionRetry, result.Action)
+	assert.EqualError(t, result.Reason, "mock error")
+	handler.RetryDelay = func(int) time.Duration { return time.Second }
+	assert.True(t, handler.handleMsgExtend(encode(MessageWithId("2", 2, "")), nil).Acked())
+	assert.Equal(t, ack.Result{Action: ack.ActionDeadLetter}, NackDeadLetter(nil))
+	assert.Equal(t, "dead-letter", ack.ActionDeadLetter.String())
+	assert.Equal(t, NackRetry(0, nil), ack.Of(false))
+}
+
+func TestDLQueue(t *testing.T) {
+	md := &memdriver.Driver{}
+	assert.True(t, Capabilities(md).Has(CapabilityDeadLetter))
+	assert.False(t, Capabilities(md).Has(CapabilityExtend))
+	assert.Equal(t, Capability(0), Capabilities(driver))
+	h := &Handler{
+		Queue:      "dlq.queue",
+		DLQueue:    "dlq.queue.dl",
+		Driver:     md,
+		DLStorage:  &internalDLStorage{},
+		EnsureFunc: func(*Message) bool { return true },
+		HandleFunc: func(*Message) bool { return false },
+	}
+	h.Prepare()
+	assert.Equal(t, 0, md.QueueDepth("dlq.queue.dl"))
+	result := h.handleMsgExtend(encode(MessageWithId("1", 1, "")), nil)
+	assert.Equal(t, ActionDeadLetter, result.Action)
+	assert.EqualError(t, result.Reason, "retry attempts [1] exhausted")
+	rows, _ := h.DLStorage.Fetch(h.Queue)
+	assert.Len(t, rows, 0)
+
+	// 驱动不支持时回退至死信存储
+	prepare()
+	mockAllNormal()
+	handler.DLQueue = "handler.basic.dl"
+	handler.DLStorage = itDLS
+	handler.EnsureFunc = func(*Message) bool { return true }
+	handler.HandleFunc = func(*Message) bool { return false }
+	sender.Prepare()
+	handler.Prepare()
+	assert.True(t, handler.handleMsgExtend(encode(MessageWithId("1", 1, "")), nil).Acked())
+	assert.Len(t, itDLS.dataMap[handler.Queue], 1)
+}
+
+func TestSendConfirmed(t *testing.T) {
+	md := &memdriver.Driver{}
+	s := (&Sender{Topic: "confirm.topic", Driver: md}).Prepare()
+	receipt, err := s.SendConfirmed(MessageWithId("1", 1, ""))
+	assert.Nil(t, err)
+	assert.Equal(t, "confirm.topic", receipt.Topic)
+	assert.Equal(t, "1", receipt.BizUID)
+	assert.Equal(t, "1", receipt.MessageID)
+	assert.True(t, receipt.Confirmed)
+	assert.False(t, receipt.SentAt.IsZero())
+	receipt, _ = s.SendConfirmed(MessageWithId("2", 2, ""))
+	assert.Equal(t, "2", receipt.MessageID)
+
+	// 驱动不支持发布确认
+	prepare()
+	mockAllNormal()
+	sender.Prepare()
+	receipt, err = sender.SendConfirmed(MessageWithId("3", 3, ""))
+	assert.Nil(t, err)
+	assert.False(t, receipt.Confirmed)
+	assert.Empty(t, receipt.MessageID)
+	assert.False(t, receipt.SentAt.IsZero())
+
+	prepare()
+	mockSendToTopicError()
+	sender.Prepare()
+	receipt, err = sender.SendConfirmed(MessageWithId("4", 4, ""))
+	assert.NotNil(t, err)
+	assert.Nil(t, receipt)
+}
+
+func TestTwoPhaseSend(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	sender.Prepare()
+	_, err := sender.PrepareSend(MessageWithId("0", 0, ""))
+	assert.NotNil(t, err)
+
+	prepare()
+	mockAllNormal()
+	archive := &recordArchive{}
+	ctx, cancel := context.WithCancel(context.TODO())
+	sender.Archive = archive
+	sender.TxOptions = &TxOptions{
+		Context:    ctx,
+		Timeout:    time.Hour,
+		EnsureFunc: func(msg *Message) bool { return true },
+		TxStorage:  itTXS,
+	}
+	sender.Prepare()
+	id, err := sender.PrepareSend(MessageWithId("1", 1, ""))
+	assert.Nil(t, err)
+	assert.Len(t, itTXS.dataMap, 1)
+	driver.AssertCalled(t, "SendToQueue", sender.TxOptions.recordQueue, mock.Anything, time.Hour)
+	driver.AssertNotCalled(t, "SendToTopic", sender.Topic, mock.Anything, mock.Anything)
+	assert.Nil(t, sender.Commit(id))
+	driver.AssertNumberOfCalls(t, "SendToTopic", 1)
+	assert.Len(t, itTXS.dataMap, 0)
+	assert.NotNil(t, sender.Commit(id))
+
+	id, err = sender.PrepareSend(MessageWithId("2", 2, ""))
+	assert.Nil(t, err)
+	assert.Nil(t, sender.Rollback(id))
+	assert.Len(t, itTXS.dataMap, 0)
+	assert.NotNil(t, sender.Rollback(id))
+	driver.AssertNumberOfCalls(t, "SendToTopic", 1)
+	assert.Equal(t, OutcomeSent, archive.records[0].Outcome)
+	assert.Equal(t, OutcomeRollback, archive.records[1].Outcome)
+	cancel()
+	sender.Wait()
+}
+
+func TestTxRecover(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	ctx, cancel := context.WithCancel(context.TODO())
+	sender.TxOptions = &TxOptions{
+		Context:      ctx,
+		Timeout:      time.Hour,
+		StaleAfter:   10 * time.Millisecond,
+		ScanInterval: time.Hour,
+		EnsureFunc:   func(msg *Message) bool { return false },
+		TxStorage:    itTXS,
+	}
+	sender.Prepare()
+	// 模拟预存后日志发送前崩溃
+	id, _ := itTXS.Store(encode(MessageWithId("1", 1, "")))
+	assert.Equal(t, 0, sender.txRecover())
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, sender.txRecover())
+	driver.AssertCalled(t, "SendToQueue", sender.TxOptions.recordQueue, encode(MessageWithId(id, id, "")), time.Duration(0))
+	assert.Eventually(t, func() bool {
+		data, _ := itTXS.Fetch(id)
+		return data == nil
+	}, time.Second, time.Millisecond)
+	cancel()
+	sender.Wait()
+	to := &TxOptions{Timeout: time.Hour, EnsureFunc: sender.TxOptions.EnsureFunc, TxStorage: itTXS}
+	to.prepare("topic")
+	assert.Equal(t, 2*time.Hour, to.StaleAfter)
+	assert.Equal(t, 2*time.Hour, to.ScanInterval)
+}
+
+// stuckTXStorage 日志消费始终失败的事务存储
+type stuckTXStorage struct{ *internalTXStorage }
+
+func (st stuckTXStorage) Fetch(string) ([]byte, error) { return nil, errors.New("storage unavailable") }
+
+func TestTxRecoverOnce(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	storage := stuckTXStorage{&internalTXStorage{}}
+	ctx, cancel := context.WithCancel(context.TODO())
+	sender.TxOptions = &TxOptions{
+		Context:      ctx,
+		Timeout:      time.Hour,
+		StaleAfter:   50 * time.Millisecond,
+		ScanInterval: time.Hour,
+		EnsureFunc:   func(msg *Message) bool { return false },
+		RetryDelay:   func(int) time.Duration { return -1 },
+		TxStorage:    storage,
+	}
+	sender.Prepare()
+	id, _ := storage.Store(encode(MessageWithId("1", 1, "")))
+	time.Sleep(60 * time.Millisecond)
+	// 日志未被消费, 同一滞留周期内的多次扫描仅补偿一次
+	assert.Equal(t, 1, sender.txRecover())
+	assert.Equal(t, 0, sender.txRecover())
+	record := encode(MessageWithId(id, id, ""))
+	driver.AssertNumberOfCalls(t, "SendToQueue", 1)
+	driver.AssertCalled(t, "SendToQueue", sender.TxOptions.recordQueue, record, time.Duration(0))
+	cancel()
+	sender.Wait()
+
+	// 不支持滞留扫描的存储不做补偿
+	plain := &Sender{TxOptions: &TxOptions{TxStorage: &struct{ TXStorageInterface }{storage}}}
+	assert.Equal(t, 0, plain.txRecover())
+}
+
+func TestTxCompensatePublish(t *testing.T) {
+	d, retained, storage := &memdriver.Driver{}, &internalRetainStorage{}, &internalTXStorage{}
+	ctx, cancel := context.WithCancel(context.TODO())
+	s := (&Sender{
+		Topic:         "test.compensate",
+		Driver:        d,
+		RetainStorage: retained,
+		TxOptions: &TxOptions{
+			Context:      ctx,
+			Timeout:      time.Hour,
+			StaleAfter:   time.Millisecond,
+			ScanInterval: time.Hour,
+			EnsureFunc:   func(msg *Message) bool { return true },
+			TxStorage:    storage,
+		},
+	}).Prepare()
+	data := encode(MessageWithId("1", 1, "created"))
+	_, _ = storage.Store(data)
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, 1, s.txRecover())
+	// 补偿发送与正常发送一致, 同样保留路由键的最新消息
+	assert.Eventually(t, func() bool {
+		got, _ := retained.Fetch(s.Topic, "created")
+		return bytes.Equal(got, data)
+	}, time.Second, time.Millisecond)
+	cancel()
+	s.Wait()
+}
+
+func TestIdempotentState(t *testing.T) {
+	ii := &internalIdempotent{}
+	ok, _ := ii.Acquire("a", 10*time.Millisecond)
+	assert.True(t, ok)
+	ok, _ = ii.Acquire("a", 10*time.Millisecond)
+	assert.False(t, ok)
+	time.Sleep(20 * time.Millisecond)
+	ok, _ = ii.Acquire("a", 0) // 操作权超时后可重新获取
+	assert.True(t, ok)
+	assert.Nil(t, ii.Release("a"))
+	ok, _ = ii.Acquire("a", 0)
+	assert.True(t, ok)
+	assert.Nil(t, ii.Done("a"))
+	assert.Nil(t, ii.Release("a"))
+	ok, _ = ii.Acquire("a", 0)
+	assert.False(t, ok)
+
+	prepare()
+	mockAllNormal()
+	handler.Idempotent = ii
+	handler.IdempotentTTL = time.Hour
+	handler.EnsureFunc = func(msg *Message) bool { return false }
+	sender.Prepare()
+	handler.Prepare()
+	assert.True(t, handler.handleMsg(encode(MessageWithId("b", 1, ""))))
+	assert.True(t, ii.dataMap[handler.Queue+".b"].done)
+}
+
+func TestShardedIdempotent(t *testing.T) {
+	si := &ShardedIdempotent{Shards: 4, Retention: 10 * time.Millisecond}
+	ok, _ := si.Acquire("a", time.Hour)
+	assert.True(t, ok)
+	ok, _ = si.Acquire("a", time.Hour)
+	assert.False(t, ok)
+	assert.Nil(t, si.Done("a"))
+	assert.Nil(t, si.Release("a"))
+	ok, _ = si.Acquire("a", 0)
+	assert.False(t, ok)
+	time.Sleep(20 * time.Millisecond)
+	ok, _ = si.Acquire("a", 0) // 完成记录超出保留时长后被清理
+	assert.True(t, ok)
+	assert.Nil(t, si.Release("a"))
+
+	var wg sync.WaitGroup
+	var acquired int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2000; j++ {
+				if ok, _ := si.Acquire(strconv.Itoa(j), time.Millisecond); ok {
+					atomic.AddInt32(&acquired, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.True(t, acquired >= 2000)
+
+	si = &ShardedIdempotent{Shards: 1}
+	for j := 0; j < 100; j++ {
+		si.Acquire(strconv.Itoa(j), time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+	for j := 0; j < sweepEvery; j++ {
+		si.Acquire("x", 0)
+	}
+	assert.Equal(t, 1, si.Len())
+}
+
+type flakyDriver struct {
+	errorDriver
+	fails int32
+}
+
+func (fd *flakyDriver) CreateQueue(name string, delay time.Duration) error {
+	if atomic.AddInt32(&fd.fails, -1) >= 0 {
+		return errors.New("broker unavailable")
+	}
+	return fd.DriverInterface.CreateQueue(name, delay)
+}
+
+func TestReconnect(t *testing.T) {
+	r := &Reconnect{InitialInterval: 10 * time.Millisecond, MaxInterval: 50 * time.Millisecond}
+	assert.Equal(t, 10*time.Millisecond, r.Backoff(1))
+	assert.Equal(t, 40*time.Millisecond, r.Backoff(3))
+	assert.Equal(t, 50*time.Millisecond, r.Backoff(10))
+	r.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		wait := r.Backoff(2)
+		assert.True(t, wait >= 10*time.Millisecond && wait <= 30*time.Millisecond)
+	}
+
+	var mu sync.Mutex
+	var attempts []error
+	fd := &flakyDriver{errorDriver: errorDriver{DriverInterface: &memdriver.Driver{}, err: errors.New("mock error")}}
+	h := &Handler{
+		Queue:      "test.reconnect",
+		Driver:     fd,
+		HandleFunc: func(*Message) bool { return true },
+		Reconnect: &Reconnect{InitialInterval: time.Millisecond, OnReconnect: func(queue string, attempt int, err error) {
+			mu.Lock()
+			attempts = append(attempts, err)
+			mu.Unlock()
+		}},
+	}
+	h.Prepare()
+	atomic.StoreInt32(&fd.fails, 2)
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+	go h.RunCtx(ctx)
+	h.Wait()
+	mu.Lock()
+	assert.Len(t, attempts, 3)
+	assert.Nil(t, attempts[2])
+	mu.Unlock()
+
+	// 超出最大尝试次数后停止处理器
+	h = &Handler{
+		Queue:      "test.reconnect",
+		Driver:     fd,
+		HandleFunc: func(*Message) bool { return true },
+		Reconnect:  &Reconnect{InitialInterval: time.Millisecond, MaxAttempts: 2},
+	}
+	h.Prepare()
+	atomic.StoreInt32(&fd.fails, 5)
+	done := make(chan struct{})
+	go func() {
+		h.Run()
+		close(done)
+	}()
+	h.Wait()
+	<-done
+}
+
+func TestClock(t *testing.T) {
+	mock := clock.NewMock(time.Date(2024, 6, 7, 17, 50, 0, 0, time.UTC))
+	timer := mock.NewTimer(time.Minute)
+	mock.Add(59 * time.Second)
+	assert.Len(t, timer.C(), 0)
+	mock.Add(time.Second)
+	assert.Equal(t, mock.Now(), <-timer.C())
+	assert.False(t, timer.Stop())
+	assert.False(t, timer.Reset(time.Minute))
+	assert.True(t, timer.Stop())
+
+	var rounds int32
+	task := &countTask{redrive: func() { atomic.AddInt32(&rounds, 1) }}
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan struct{})
+	go func() {
+		(&TickerScheduler{Interval: time.Minute, Clock: mock}).Schedule(ctx, task)
+		close(done)
+	}()
+	for i := 1; i <= 3; i++ {
+		mock.BlockUntil(1)
+		mock.Add(time.Minute)
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(&rounds) == int32(i) }, time.Second, time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	cron, _ := ParseCron("0 9 * * *")
+	cron.Clock = mock
+	ctx, cancel = context.WithCancel(context.TODO())
+	done = make(chan struct{})
+	go func() {
+		cron.Schedule(ctx, task)
+		close(done)
+	}()
+	mock.BlockUntil(1)
+	mock.Set(time.Date(2024, 6, 8, 9, 0, 0, 0, time.UTC))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&rounds) == 4 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
+
+// countTask 记录重试轮次的任务
+type countTask struct {
+	redrive func()
+}
+
+func (ct *countTask) Redrive() int {
+	ct.redrive()
+	return 0
+}
+
+func (ct *countTask) Requeue(time.Duration) int { return 0 }
+
+type tenantKey struct{}
+
+func TestPropagation(t *testing.T) {
+	d := &memdriver.Driver{}
+	propagator := PropagateValue(tenantKey{}, "tenant")
+	s := &Sender{Topic: "test.propagation", Driver: d, Propagators: []Propagator{propagator}}
+	s.Prepare()
+	var tenant interface{}
+	h := &Handler{
+		Queue:      "test.propagation",
+		Driver:     d,
+		EnsureFunc: func(*Message) bool { return true },
+		HandleFunc: func(msg *Message) bool {
+			tenant = msg.Context().Value(tenantKey{})
+			return true
+		},
+	}
+	WithPropagators(propagator)(h)
+	h.Prepare()
+
+	msg := MessageWithId("a", 1, "")
+	assert.Equal(t, context.Background(), msg.Context())
+	ctx := context.WithValue(context.TODO(), tenantKey{}, "acme")
+	assert.Nil(t, s.SendCtx(ctx, msg))
+	assert.Equal(t, "acme", msg.Header("tenant"))
+	assert.True(t, h.handleMsg(encode(msg)))
+	assert.Equal(t, "acme", tenant)
+
+	msg = MessageWithId("b", 1, "")
+	assert.Nil(t, s.SendCtx(context.TODO(), msg))
+	assert.Nil(t, msg.Headers)
+	assert.True(t, h.handleMsg(encode(msg)))
+	assert.Nil(t, tenant)
+}
+
+func TestWebhook(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/ok":
+			assert.Equal(t, SignWebhook("secret", ts, body), r.Header.Get(WebhookSignatureHeader))
+			assert.Equal(t, "1", string(body))
+		case "/flaky":
+			if n == 1 {
+				w.Header().Set("Retry-After", "3")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer srv.Close()
+
+	prepare()
+	mockAllNormal()
+	handler.DLStorage = itDLS
+	handler.RetryDelay = func(int) time.Duration { return time.Second }
+	sender.Prepare()
+	wh := &Webhook{Source: &handler, Endpoints: []WebhookEndpoint{
+		{URL: srv.URL + "/ok", Secret: "secret"},
+		{Name: "flaky", URL: srv.URL + "/flaky"},
+	}}
+	wh.Prepare()
+	msg := MessageWithId("a", 1, "")
+	assert.True(t, handler.handleMsg(encode(msg)))
+	driver.AssertCalled(t, "SendToQueue", handler.Queue, mock.Anything, 3*time.Second)
+	var retried Message
+	decode(driver.Calls[len(driver.Calls)-1].Arguments.Get(1).([]byte), &retried)
+	assert.Equal(t, srv.URL+"/ok", retried.Header(WebhookDeliveredHeader))
+	assert.True(t, handler.handleMsg(encode(&retried)))
+	assert.Equal(t, map[string]int{"/ok": 1, "/flaky": 2}, hits)
+
+	// 永久失败直接进入死信
+	prepare()
+	mockAllNormal()
+	handler.DLStorage = itDLS
+	handler.RetryDelay = func(int) time.Duration { return time.Second }
+	sender.Prepare()
+	wh = &Webhook{Source: &handler, Endpoints: []WebhookEndpoint{{URL: srv.URL + "/gone"}}}
+	wh.Prepare()
+	assert.True(t, handler.handleMsg(encode(MessageWithId("b", 1, ""))))
+	rows, _ := itDLS.Fetch(handler.Queue)
+	assert.Len(t, rows, 1)
+}
+
+func TestStreamBridge(t *testing.T) {
+	d := &memdriver.Driver{}
+	s := (&Sender{Topic: "bridge.events", Driver: d}).Prepare()
+	ctx, cancel := context.WithCancel(context.TODO())
+	sb := (&StreamBridge{Context: ctx, Queue: "bridge", Topics: []string{s.Topic}, Driver: d}).Prepare()
+	go sb.Run()
+	defer sb.Wait()
+	defer cancel()
+	mux := http.NewServeMux()
+	mux.Handle("/sse", sb.SSE())
+	mux.Handle("/ws", sb.WebSocket())
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/sse?topic=missing")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Get(srv.URL + "/sse?topic=bridge.events&route_key=a")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	ws, err := websocket.Dial("ws"+srv.URL[4:]+"/ws?topic=bridge.events&route_key=b", "", srv.URL)
+	assert.Nil(t, err)
+	defer ws.Close()
+	assert.Eventually(t, func() bool { return sb.Connections() == 2 }, time.Second, time.Millisecond)
+	assert.Nil(t, s.Send(MessageWithId("1", "x", "b")))
+	assert.Nil(t, s.Send(MessageWithId("2", "y", "a")))
+	assert.Nil(t, s.Send(MessageWithId("3", "z", "c")))
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, []string{"id: 2", "event: bridge.events", `data: {"topic":"bridge.events","route_key":"a","id":"2","payload":"y"}`}, lines)
+	var ev BridgeEvent
+	assert.Nil(t, websocket.JSON.Receive(ws, &ev))
+	assert.Equal(t, BridgeEvent{Topic: s.Topic, RouteKey: "b", ID: "1", Payload: json.RawMessage(`"x"`)}, ev)
+
+	// 多行JSON压缩为单行data字段, 二进制内容以raw字段base64编码推送
+	binary := []byte{0xff, '\r', '\n', 0x00, '\r'}
+	sb.fanout(s.Topic, &Message{BizUID: "5", RouteKey: "a", Payload: []byte("{\n\"k\": 1\r\n}")})
+	sb.fanout(s.Topic, &Message{BizUID: "6\n", RouteKey: "a", Payload: binary})
+	sb.fanout(s.Topic, &Message{BizUID: "7", RouteKey: "b", Payload: binary})
+	for lines = nil; len(lines) < 6; {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, []string{"id: 5", "event: bridge.events", `data: {"topic":"bridge.events","route_key":"a","id":"5","payload":{"k":1}}`}, lines[:3])
+	assert.Equal(t, "id: 6", lines[3])
+	var sse BridgeEvent
+	assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[5], "data: ")), &sse))
+	assert.Equal(t, BridgeEvent{Topic: s.Topic, RouteKey: "a", ID: "6\n", Raw: binary}, sse)
+	ev = BridgeEvent{}
+	assert.Nil(t, websocket.JSON.Receive(ws, &ev))
+	assert.Equal(t, BridgeEvent{Topic: s.Topic, RouteKey: "b", ID: "7", Raw: binary}, ev)
+
+	// 缓冲已满时断开慢连接
+	sb.SlowPolicy = SlowDisconnect
+	bc, err := sb.connect(httptest.NewRequest(http.MethodGet, "/?topic=bridge.events&route_key=a", nil))
+	assert.Nil(t, err)
+	for i := 0; i <= sb.Buffer; i++ {
+		sb.fanout(s.Topic, MessageWithId("4", 1, "a"))
+	}
+	assert.Equal(t, uint64(1), sb.Dropped())
+	<-bc.closed
+	sb.disconnect(bc)
+}
+
+func TestClaimCheck(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	blobs := &internalBlobStorage{}
+	sender.BlobStorage, sender.ClaimThreshold = blobs, 8
+	handler.DLStorage = itDLS
+	handler.RetryDelay = func(int) time.Duration { return -1 }
+	var received []string
+	handler.HandleFunc = func(msg *Message) bool {
+		var s string
+		msg.Scan(&s)
+		received = append(received, s)
+		return len(received) > 1
+	}
+	handler.EnsureFunc = func(*Message) bool { return true }
+	sender.Prepare()
+	handler.Prepare()
+
+	assert.Nil(t, sender.Send(MessageWithId("a", "short", "")))
+	assert.Nil(t, sender.Send(MessageWithId("b", "a large payload", "")))
+	var small, large Message
+	decode(driver.Calls[len(driver.Calls)-2].Arguments.Get(1).([]byte), &small)
+	decode(driver.Calls[len(driver.Calls)-1].Arguments.Get(1).([]byte), &large)
+	assert.Equal(t, "", small.ClaimRef)
+	assert.Equal(t, "0", large.ClaimRef)
+	assert.Nil(t, large.Payload)
+
+	// 未配置存储时由驱动延迟重新投递
+	result := handler.handleMsgExtend(encode(&large), nil)
+	assert.Equal(t, ActionRetry, result.Action)
+	assert.Equal(t, nackBackoff, result.Delay)
+	handler.RetryDelay = func(attempts int) time.Duration { return time.Duration(attempts) * time.Minute }
+	assert.Equal(t, time.Minute, handler.handleMsgExtend(encode(&large), nil).Delay)
+	handler.RetryDelay = func(int) time.Duration { return -1 }
+	handler.BlobStorage = blobs
+	// 进入死信时仅保留引用
+	assert.True(t, handler.handleMsg(encode(&large)))
+	rows, _ := itDLS.Fetch(handler.Queue)
+	var dl Message
+	decode(rows["0"], &dl)
+	assert.Equal(t, "0", dl.ClaimRef)
+	assert.Nil(t, dl.Payload)
+	assert.True(t, handler.handleMsg(rows["0"]))
+	assert.Equal(t, []string{"a large payload", "a large payload"}, received)
+}
+
+func TestDLNotifier(t *testing.T) {
+	prepare()
+	mockAllNormal()
+	events := make(chan *DLEvent, 1)
+	handler.DLStorage = itDLS
+	handler.HandleFunc = func(*Message) bool { return false }
+	handler.EnsureFunc = func(*Message) bool { return true }
+	WithDLNotifier(DLNotifierFunc(func(event *DLEvent) error {
+		events <- event
+		return nil
+	}))(&handler)
+	sender.Prepare()
+	handler.Prepare()
+	assert.True(t, handler.handleMsg(encode(MessageWithId("a", 1, ""))))
+	event := <-events
+	assert.Equal(t, handler.Queue, event.Queue)
+	assert.Equal(t, "a", event.BizUID)
+	assert.Equal(t, 1, event.Retried)
+	assert.Equal(t, "retry attempts [1] exhausted", event.Reason)
+
+	bodies := make(chan map[string]interface{}, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	assert.Nil(t, (&WebhookNotifier{URL: srv.URL, Secret: "secret"}).Notify(event))
+	assert.Equal(t, "a", (<-bodies)["biz_uid"])
+	assert.NotNil(t, (&SlackNotifier{WebhookURL: srv.URL + "/fail"}).Notify(event))
+	assert.Contains(t, (<-bodies)["text"], "queue `handler.basic`")
+}
+
+func TestRetained(t *testing.T) {
+	d := &memdriver.Driver{}
+	retained := &internalRetainStorage{}
+	s := (&Sender{Topic: "test.rates", Driver: d, RetainStorage: retained}).Prepare()
+	assert.Nil(t, s.Send(MessageWithId("1", 6.9, "usd")))
+	assert.Nil(t, s.Send(MessageWithId("2", 7.1, "usd")))
+	assert.Nil(t, s.Send(MessageWithId("3", 7.8, "eur")))
+
+	received := make(chan float64, 2)
+	h := &Handler{
+		Queue:         "test.rates.usd",
+		Driver:        d,
+		Subscribe:     Subscribe{Topic: s.Topic, RouteKey: "usd"},
+		RetainStorage: retained,
+		EnsureFunc:    func(*Message) bool { return true },
+		HandleFunc: func(msg *Message) bool {
+			var rate float64
+			msg.Scan(&rate)
+			received <- rate
+			return true
+		},
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	go h.Prepare().RunCtx(ctx)
+	assert.Equal(t, 7.1, <-received)
+	assert.Nil(t, s.Send(MessageWithId("4", 7.2, "usd")))
+	assert.Equal(t, 7.2, <-received)
+	cancel()
+	h.Wait()
+	data, _ := retained.Fetch(s.Topic, "eur")
+	var msg Message
+	decode(data, &msg)
+	assert.Equal(t, "3", msg.BizUID)
+}
+
+func TestSampledArchive(t *testing.T) {
+	sink := &recordArchive{}
+	sa := &SampledArchive{Sink: sink, Predicate: func(record *ArchiveRecord) bool {
+		return record.Outcome == OutcomeDead
+	}}
+	assert.Nil(t, sa.Store(&ArchiveRecord{Outcome: OutcomeDone}))
+	assert.Nil(t, sa.Store(&ArchiveRecord{Outcome: OutcomeDead}))
+	assert.Len(t, sink.records, 1)
+
+	sa = &SampledArchive{Sink: sink, Rate: 0.1, Consistent: true}
+	var hits int
+	for i := 0; i < 1000; i++ {
+		record := &ArchiveRecord{Data: encode(MessageWithId(strconv.Itoa(i), 1, ""))}
+		if sa.sampled(record) {
+			hits++
+			// 同一消息的抽样结果一致
+			assert.True(t, sa.sampled(&ArchiveRecord{Source: SourceHandler, Data: record.Data}))
+		}
+	}
+	assert.InDelta(t, 100, hits, 50)
+	assert.True(t, (&SampledArchive{Rate: 1}).sampled(&ArchiveRecord{}))
+}
+
+func TestFairDispatch(t *testing.T) {
+	h := &Handler{Queue: "fair", Driver: driver, HandleFunc: func(*Message) bool { return true }}
+	WithFairDispatch(map[string]int{"user": 3})(h)
+	assert.Panics(t, func() { h.Prepare() }) // 需配合并发数量
+
+	fs := newFairScheduler(h.FairDispatch, 1)
+	fs.acquire("bulk") // 占满名额
+	granted := make(chan string)
+	wait := func(key string, n int) {
+		go func() {
+			fs.acquire(key)
+			granted <- key
+		}()
+		for fs.waiting() < n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 1; i <= 6; i++ {
+		wait("bulk", i)
+	}
+	wait("user", 7)
+	wait("user", 8)
+	var order []string
+	for i := 0; i < 8; i++ {
+		fs.release()
+		order = append(order, <-granted)
+	}
+	fs.release()
+	assert.Equal(t, 0, fs.waiting())
+	assert.Equal(t, 0, fs.running)
+	var users int
+	for _, key := range order[:3] {
+		if key == "user" {
+			users++
+		}
+	}
+	assert.Equal(t, 2, users, "order: %v", order) // 高权重路由键不被洪峰饿死
+	assert.Equal(t, []string{"bulk", "bulk", "bulk", "bulk", "bulk"}, order[3:])
+}
+
+func TestHandlerUpdate(t *testing.T) {
+	mc := clock.NewMock(time.Now())
+	h := &Handler{Concurrency: 1, RateLimit: 50, Clock: mc}
+	block := make(chan struct{})
+	var running int32
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	fn := h.limit(ctx, func([]byte, Extender) Result {
+		atomic.AddInt32(&running, 1)
+		<-block
+		return Ack()
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(nil, nil)
+		}()
+	}
+	mc.BlockUntil(2) // 限速等待中
+	mc.Add(40 * time.Millisecond)
+	assert.Eventually(t, func() bool { return h.slots.waiting() == 2 }, time.Second, time.Millisecond)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&running))
+	h.Update(WithConcurrency(3), WithRateLimit(0), WithDLQueue("ignored"))
+	assert.Eventually(t, func() bool { // 调大并发后等待者立即处理
+		return atomic.LoadInt32(&running) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "", h.DLQueue)
+	close(block)
+	wg.Wait()
+
+	// 限速调整
+	h.Update(WithRateLimit(20))
+	var handled int32
+	go func() {
+		for i := 0; i < 4; i++ {
+			fn(nil, nil)
+			atomic.AddInt32(&handled, 1)
+		}
+	}()
+	for i := 1; i < 4; i++ {
+		mc.BlockUntil(1)
+		assert.EqualValues(t, i, atomic.LoadInt32(&handled))
+		mc.Add(50 * time.Millisecond)
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&handled) == 4 }, time.Second, time.Millisecond)
+	h.Update(WithRateLimit(0))
+	for i := 0; i < 4; i++ {
+		fn(nil, nil) // 不限速时无需等待时钟
+	}
+
+	// 处理器退出时中断限速等待
+	h.Update(WithRateLimit(1))
+	var called int32
+	stop, cancelStop := context.WithCancel(context.TODO())
+	limited := h.limit(stop, func([]byte, Extender) Result {
+		atomic.AddInt32(&called, 1)
+		return Ack()
+	})
+	assert.True(t, limited(nil, nil).Acked())
+	result := make(chan Result)
+	go func() { result <- limited(nil, nil) }()
+	mc.BlockUntil(1)
+	cancelStop()
+	interrupted := <-result
+	assert.Equal(t, ActionRetry, interrupted.Action)
+	assert.Equal(t, nackBackoff, interrupted.Delay)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&called))
+
+	// 过滤及重试策略调整
+	h.Update(WithFilterFunc(func([]byte) bool { return false }))
+	filter, retryDelay := h.policies()
+	assert.False(t, filter(nil))
+	assert.Equal(t, time.Duration(-1), retryDelay(1))
+	h.Update(WithRetryDelay(func(int) time.Duration { return time.Second }))
+	filter, retryDelay = h.policies()
+	assert.NotNil(t, filter)
+	assert.Equal(t, time.Second, retryDelay(1))
+}
+
+func TestSenderDedup(t *testing.T) {
+	md := &memdriver.Driver{}
+	assert.Nil(t, md.CreateQueue("dedup.queue", 0))
+	sender := (&Sender{
+		Topic:       "dedup",
+		Driver:      md,
+		Dedup:       &internalDedupStorage{},
+		DedupWindow: 50 * time.Millisecond,
+		TxOptions: &TxOptions{
+			Timeout:    time.Minute,
+			EnsureFunc: func(*Message) bool { return false },
+			TxStorage:  &internalTXStorage{},
+		},
+	}).Prepare()
+	assert.Nil(t, md.Subscribe("dedup", "dedup.queue", ""))
+	assert.Nil(t, sender.Send(MessageWithId("1", 1, "")))
+	assert.Nil(t, sender.Send(MessageWithId("1", 1, ""))) // 重复发送直接成功
+	assert.Nil(t, sender.Send(MessageWithId("2", 2, "")))
+	assert.Equal(t, 2, md.QueueDepth("dedup.queue"))
+
+	// 本地事务失败时移除标记, 允许重新发送
+	var executed int
+	localTx := func(err error) func() error {
+		return func() error {
+			executed++
+			return err
+		}
+	}
+	assert.NotNil(t, sender.Send(MessageWithId("3", 3, ""), localTx(errors.New("tx error"))))
+	assert.Nil(t, sender.Send(MessageWithId("3", 3, ""), localTx(nil)))
+	assert.Nil(t, sender.Send(MessageWithId("3", 3, ""), localTx(nil)))
+	assert.Equal(t, 2, executed)
+	assert.Equal(t, 3, md.QueueDepth("dedup.queue"))
+
+	// 本地事务panic时同样移除标记
+	assert.NotNil(t, sender.Send(MessageWithId("4", 4, ""), func() error { panic("tx panic") }))
+	assert.Nil(t, sender.Send(MessageWithId("4", 4, ""), localTx(nil)))
+	assert.Equal(t, 3, executed)
+	assert.Equal(t, 4, md.QueueDepth("dedup.queue"))
+
+	// 超出去重窗口后可再次发送
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, sender.Send(MessageWithId("1", 1, "")))
+	assert.Equal(t, 5, md.QueueDepth("dedup.queue"))
+}
+
+type batchDriver struct {
+	*memdriver.Driver
+	batch BatchAck
+}
+
+func (bd *batchDriver) ReceiveMessageBatchAck(ctx context.Context, queue string, errChan chan error, batch BatchAck, handler func([]byte) Result) {
+	bd.batch = batch
+	bd.ReceiveMessage(ctx, queue, errChan, handler)
+}
+
+func TestBatchAck(t *testing.T) {
+	bd := &batchDriver{Driver: &memdriver.Driver{}}
+	assert.True(t, Capabilities(bd).Has(CapabilityBatchAck))
+	assert.False(t, Capabilities(bd.Driver).Has(CapabilityBatchAck))
+	received := make(chan string, 1)
+	h := &Handler{
+		Queue:      "batch.queue",
+		Driver:     bd,
+		HandleFunc: func(msg *Message) bool { received <- msg.BizUID; return true },
+		EnsureFunc: func(*Message) bool { return true },
+	}
+	WithBatchAck(100, time.Second)(h)
+	h.Prepare()
+	ctx, cancel := context.WithCancel(context.Background())
+	go h.RunCtx(ctx)
+	assert.Nil(t, bd.SendToQueue("batch.queue", encode(MessageWithId("1", 1, "")), 0))
+	assert.Equal(t, "1", <-received)
+	cancel()
+	h.Wait()
+	assert.Equal(t, BatchAck{Size: 100, Interval: time.Second}, bd.batch)
+}
+
+type scheduleDriver struct {
+	*memdriver.Driver
+	at []time.Time
+}
+
+func (sd *scheduleDriver) SendToTopicAt(topic string, content []byte, routeKey string, at time.Time) error {
+	sd.at = append(sd.at, at)
+	return sd.Driver.SendToTopicAt(topic, content, routeKey, at)
+}
+
+func TestSendAt(t *testing.T) {
+	sd := &scheduleDriver{Driver: &memdriver.Driver{}}
+	assert.True(t, Capabilities(sd).Has(CapabilitySchedule))
+	at := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	sender := (&Sender{Topic: "schedule", Driver: sd}).Prepare()
+	assert.Nil(t, sender.Send(MessageWithId("1", 1, "")))
+	assert.Nil(t, sender.SendAt(MessageWithId("2", 2, ""), at))
+	assert.Equal(t, []time.Time{at}, sd.at) // 驱动定时投递
+
+	// 驱动不支持时由处理器延迟处理
+	md := &memdriver.Driver{}
+	sender = (&Sender{Topic: "schedule", Driver: errorDriver{DriverInterface: md}}).Prepare()
+	h := (&Handler{
+		Queue:      "schedule.queue",
+		Driver:     md,
+		Subscribe:  Subscribe{Topic: "schedule"},
+		HandleFunc: func(*Message) bool { return true },
+		EnsureFunc: func(*Message) bool { return true },
+	}).Prepare()
+	assert.Nil(t, sender.SendAt(MessageWithId("3", 3, ""), at))
+	assert.Equal(t, 1, md.QueueDepth("schedule.queue"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan []byte, 1)
+	go md.ReceiveMessage(ctx, "schedule.queue", make(chan error), func(data []byte) Result {
+		received <- data
+		return Ack()
+	})
+	result := h.handleMsgExtend(<-received, nil)
+	assert.Equal(t, ActionRetry, result.Action)
+	assert.True(t, result.Delay > 59*time.Minute && result.Delay <= time.Hour)
+}
+
+func TestDripReplay(t *testing.T) {
+	md := &memdriver.Driver{}
+	dls := &internalDLStorage{}
+	h := &Handler{
+		Queue:      "drip.queue",
+		Driver:     md,
+		DLStorage:  dls,
+		HandleFunc: func(*Message) bool { return true },
+	}
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, dls.Store(h.Queue, encode(MessageWithId(strconv.Itoa(i), i, ""))))
+	}
+	mock := clock.NewMock(time.Now())
+	progress := make(chan DripProgress, 5)
+	dr := &DripReplay{Handler: h, Rate: 2, Clock: mock, OnProgress: func(p DripProgress) { progress <- p }}
+	done := make(chan DripProgress)
+	go func() {
+		p, err := dr.Run(context.Background())
+		assert.Nil(t, err)
+		done <- p
+	}()
+	assert.Equal(t, DripProgress{Total: 5, Replayed: 1}, <-progress)
+	mock.BlockUntil(1)
+	assert.Len(t, progress, 0) // 按速率等待
+	mock.Add(500 * time.Millisecond)
+	assert.Equal(t, DripProgress{Total: 5, Replayed: 2}, <-progress)
+	mock.BlockUntil(1)
+	assert.Equal(t, 3, dr.Progress().Remaining())
+	_, err := dr.Run(context.Background())
+	assert.NotNil(t, err) // 不允许重复运行
+	dr.Stop()
+	p := <-done
+	assert.Equal(t, DripProgress{Total: 5, Replayed: 2, Stopped: true, Done: true}, p)
+	assert.Equal(t, 2, md.QueueDepth(h.Queue))
+	rows, _ := dls.Fetch(h.Queue)
+	assert.Len(t, rows, 3) // 未重放的死信保留
+
+	// 再次运行时继续重放剩余死信
+	dr = &DripReplay{Handler: h, Rate: 1000}
+	p, err = dr.Run(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, DripProgress{Total: 3, Replayed: 3, Done: true}, p)
+	assert.Equal(t, 5, md.QueueDepth(h.Queue))
+	rows, _ = dls.Fetch(h.Queue)
+	assert.Len(t, rows, 0)
+}
+
+type logRecorder struct {
+	sync.Mutex
+	rows []string
+}
+
+func (lr *logRecorder) Errorf(format string, args ...interface{}) {
+	lr.Lock()
+	defer lr.Unlock()
+	lr.rows = append(lr.rows, fmt.Sprintf(format, args...))
+}
+
+// failedIdempotent 获取及释放操作权始终失败的幂等实现
+type failedIdempotent struct{ *internalIdempotent }
+
+func (fi failedIdempotent) Acquire(string, time.Duration) (bool, error) {
+	return false, errors.New("mock error")
+}
+
+func (fi failedIdempotent) Release(string) error { return errors.New("mock error") }
+
+func TestHandlerErrorLog(t *testing.T) {
+	logger := &logRecorder{}
+	h := (&Handler{
+		Queue:      "log.queue",
+		Driver:     &memdriver.Driver{},
+		Logger:     logger,
+		Idempotent: failedIdempotent{&internalIdempotent{}},
+		DLStorage:  &failedDLStorage{&internalDLStorage{}},
+		RetryDelay: func(int) time.Duration { return -1 },
+		HandleFunc: func(*Message) bool { return false },
+		EnsureFunc: func(*Message) bool { return true },
+	}).Prepare()
+	assert.False(t, h.handleMsg(encode(MessageWithId("1", 1, ""))))
+	for _, row := range []string{
+		"handler [log.queue] idempotent acquired failed, mock error",
+		"handler [log.queue] idempotent release failed, mock error",
+		"handler [log.queue] dl store failed, mock error",
+	} {
+		assert.Contains(t, logger.rows, row)
+	}
+}
+
+func slowHandle(msg *Message) bool {
+	var delay time.Duration
+	msg.Scan(&delay)
+	time.Sleep(delay)
+	return true
+}
+
+func TestSlowHandle(t *testing.T) {
+	logger := &logRecorder{}
+	h := &Handler{
+		Queue:      "slow.queue",
+		Driver:     &memdriver.Driver{},
+		Logger:     logger,
+		HandleFunc: slowHandle,
+		EnsureFunc: func(*Message) bool { return true },
+	}
+	WithSlowThreshold(20*time.Millisecond, true)(h)
+	h.Prepare()
+	assert.True(t, h.handleMsg(encode(MessageWithId("fast", time.Duration(0), ""))))
+	assert.True(t, h.handleMsg(encode(MessageWithId("slow", 40*time.Millisecond, ""))))
+	stats := h.Stats()
+	assert.EqualValues(t, 2, stats.Handled)
+	assert.EqualValues(t, 1, stats.Slow)
+	assert.True(t, stats.MaxCost >= 40*time.Millisecond)
+	assert.Len(t, logger.rows, 1)
+	assert.Contains(t, logger.rows[0], "slow handle [slow] cost")
+	assert.Contains(t, logger.rows[0], "bus.slowHandle") // 堆栈采样自处理协程
+
+	h.SlowStack = false
+	assert.True(t, h.handleMsg(encode(MessageWithId("slow2", 30*time.Millisecond, ""))))
+	assert.Len(t, logger.rows, 2)
+	assert.NotContains(t, logger.rows[1], "stack sample")
+	assert.EqualValues(t, 2, h.Stats().Slow)
+}
@@ -0,0 +1,110 @@
+package bustest
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/easy-bus/bus"
+)
+
+// Matcher 消息匹配函数
+type Matcher func(msg *bus.Message) bool
+
+// Any 匹配任意消息
+func Any() Matcher {
+	return func(*bus.Message) bool { return true }
+}
+
+// BizUID 匹配消息唯一标识
+func BizUID(id string) Matcher {
+	return func(msg *bus.Message) bool { return msg.BizUID == id }
+}
+
+// RouteKey 匹配消息路由键
+func RouteKey(routeKey string) Matcher {
+	return func(msg *bus.Message) bool { return msg.RouteKey == routeKey }
+}
+
+// Payload 匹配消息内容, 以JSON编码结果比较
+func Payload(payload interface{}) Matcher {
+	expect, err := json.Marshal(payload)
+	return func(msg *bus.Message) bool {
+		return err == nil && bytes.Equal(expect, msg.Payload)
+	}
+}
+
+// All 同时满足全部匹配条件
+func All(matchers ...Matcher) Matcher {
+	return func(msg *bus.Message) bool {
+		for _, m := range matchers {
+			if !m(msg) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Recorder 发布记录, 如测试驱动
+type Recorder interface {
+	Published(topic string) []Published
+}
+
+// AssertPublished 断言主题已发布匹配的消息
+func AssertPublished(t testing.TB, r Recorder, topic string, matcher Matcher) bool {
+	t.Helper()
+	if count(r, topic, matcher) == 0 {
+		t.Errorf("bustest: no message matched was published to topic [%s], published %d", topic, len(r.Published(topic)))
+		return false
+	}
+	return true
+}
+
+// AssertNotPublished 断言主题未发布匹配的消息
+func AssertNotPublished(t testing.TB, r Recorder, topic string, matcher Matcher) bool {
+	t.Helper()
+	if n := count(r, topic, matcher); n > 0 {
+		t.Errorf("bustest: %d message matched was published to topic [%s]", n, topic)
+		return false
+	}
+	return true
+}
+
+// AssertPublishedCount 断言主题发布匹配消息的数量
+func AssertPublishedCount(t testing.TB, r Recorder, topic string, matcher Matcher, expect int) bool {
+	t.Helper()
+	if n := count(r, topic, matcher); n != expect {
+		t.Errorf("bustest: expect %d message matched published to topic [%s], got %d", expect, topic, n)
+		return false
+	}
+	return true
+}
+
+// AssertPublished 断言主题已发布匹配的消息, 同包级AssertPublished
+func (d *Driver) AssertPublished(t testing.TB, topic string, matcher Matcher) bool {
+	t.Helper()
+	return AssertPublished(t, d, topic, matcher)
+}
+
+// AssertNotPublished 断言主题未发布匹配的消息, 同包级AssertNotPublished
+func (d *Driver) AssertNotPublished(t testing.TB, topic string, matcher Matcher) bool {
+	t.Helper()
+	return AssertNotPublished(t, d, topic, matcher)
+}
+
+// AssertPublishedCount 断言主题发布匹配消息的数量, 同包级AssertPublishedCount
+func (d *Driver) AssertPublishedCount(t testing.TB, topic string, matcher Matcher, expect int) bool {
+	t.Helper()
+	return AssertPublishedCount(t, d, topic, matcher, expect)
+}
+
+// count 统计匹配的消息数量
+func count(r Recorder, topic string, matcher Matcher) (n int) {
+	for _, p := range r.Published(topic) {
+		if p.Message != nil && matcher(p.Message) {
+			n++
+		}
+	}
+	return n
+}
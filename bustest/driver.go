@@ -0,0 +1,291 @@
+package bustest
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/easy-bus/bus"
+)
+
+// Published 已发布的消息记录
+type Published struct {
+	// Topic 发布主题, 直接发送至队列时为空
+	Topic string
+
+	// Queue 发送队列, 发布至主题时为空
+	Queue string
+
+	// RouteKey 路由键
+	RouteKey string
+
+	// Delay 延迟时长
+	Delay time.Duration
+
+	// Data 消息原始内容
+	Data []byte
+
+	// Message 解码后的消息
+	Message *bus.Message
+
+	// Result 处理结果, 仅Nacked记录有效
+	Result bus.Result
+}
+
+// Driver 测试驱动
+// 发布至主题的消息在发送时同步投递给处理器, 并记录全部发布内容
+// 直接发送至队列的消息(如处理失败的重试)进入待投递列表, 由Flush或Advance显式投递
+// 尚未开始接收的队列会暂存消息, 直至处理器启动后投递
+type Driver struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	queues    map[string]time.Duration
+	relation  map[string]map[string]map[string]bool
+	receivers map[string]func([]byte) bus.Result
+	pending   map[string][][]byte
+	scheduled []scheduled
+	elapsed   time.Duration
+	published []Published
+	nacked    []Published
+}
+
+// scheduled 待投递的队列消息
+type scheduled struct {
+	queue string
+	data  []byte
+	at    time.Duration
+}
+
+// NewDriver 实例化测试驱动
+func NewDriver() *Driver {
+	d := &Driver{
+		queues:    make(map[string]time.Duration),
+		relation:  make(map[string]map[string]map[string]bool),
+		receivers: make(map[string]func([]byte) bus.Result),
+		pending:   make(map[string][][]byte),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+func (d *Driver) CreateQueue(name string, delay time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queues[name] = delay
+	return nil
+}
+
+func (d *Driver) CreateTopic(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.relation[name]; !ok {
+		d.relation[name] = make(map[string]map[string]bool)
+	}
+	return nil
+}
+
+func (d *Driver) Subscribe(topic, queue, routeKey string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.relation[topic]; !ok {
+		d.relation[topic] = make(map[string]map[string]bool)
+	}
+	if _, ok := d.relation[topic][queue]; !ok {
+		d.relation[topic][queue] = make(map[string]bool)
+	}
+	d.relation[topic][queue][routeKey] = true
+	return nil
+}
+
+func (d *Driver) UnSubscribe(topic, queue, routeKey string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.relation[topic][queue], routeKey)
+	return nil
+}
+
+func (d *Driver) SendToQueue(queue string, content []byte, delay time.Duration) error {
+	d.record(Published{Queue: queue, Delay: delay, Data: content})
+	d.mu.Lock()
+	d.scheduled = append(d.scheduled, scheduled{queue: queue, data: content, at: d.elapsed + delay})
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *Driver) SendToTopic(topic string, content []byte, routeKey string) error {
+	d.record(Published{Topic: topic, RouteKey: routeKey, Data: content})
+	d.mu.Lock()
+	queues := make([]string, 0)
+	for queue, keys := range d.relation[topic] {
+		if keys[routeKey] {
+			queues = append(queues, queue)
+		}
+	}
+	d.mu.Unlock()
+	for _, queue := range queues {
+		d.deliver(queue, content)
+	}
+	return nil
+}
+
+func (d *Driver) ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) bus.Result) {
+	d.mu.Lock()
+	d.receivers[queue] = handler
+	pending := d.pending[queue]
+	delete(d.pending, queue)
+	d.cond.Broadcast()
+	d.mu.Unlock()
+	for _, data := range pending {
+		d.handle(queue, data, handler)
+	}
+	<-ctx.Done()
+	d.mu.Lock()
+	delete(d.receivers, queue)
+	d.mu.Unlock()
+}
+
+// Run 启动处理器并等待其开始接收消息
+func (d *Driver) Run(ctx context.Context, handlers ...*bus.Handler) {
+	for _, h := range handlers {
+		go h.Prepare().RunCtx(ctx)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, h := range handlers {
+		for d.receivers[h.Queue] == nil {
+			d.cond.Wait()
+		}
+	}
+}
+
+// Deliver 直接向队列投递消息, 返回处理器的处理结果
+// 队列尚未开始接收时消息将被暂存, 并返回false
+func (d *Driver) Deliver(queue string, msg *bus.Message) bool {
+	data, _ := json.Marshal(msg)
+	return d.deliver(queue, data)
+}
+
+// Flush 投递已到期的队列消息, 返回投递的数量
+// 投递过程中新发送的消息不在本次投递, 可再次调用Flush
+func (d *Driver) Flush() int {
+	d.mu.Lock()
+	var due, rest []scheduled
+	for _, s := range d.scheduled {
+		if s.at <= d.elapsed {
+			due = append(due, s)
+		} else {
+			rest = append(rest, s)
+		}
+	}
+	d.scheduled = rest
+	d.mu.Unlock()
+	for _, s := range due {
+		d.deliver(s.queue, s.data)
+	}
+	return len(due)
+}
+
+// Advance 将驱动时间前进delay, 并投递到期的队列消息, 返回投递的数量
+func (d *Driver) Advance(delay time.Duration) int {
+	d.mu.Lock()
+	d.elapsed += delay
+	d.mu.Unlock()
+	return d.Flush()
+}
+
+// Scheduled 获取队列中待投递的消息数量
+func (d *Driver) Scheduled(queue string) (n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range d.scheduled {
+		if s.queue == queue {
+			n++
+		}
+	}
+	return n
+}
+
+// Published 获取发布至主题的消息记录
+func (d *Driver) Published(topic string) []Published {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rows := make([]Published, 0)
+	for _, p := range d.published {
+		if p.Topic == topic {
+			rows = append(rows, p)
+		}
+	}
+	return rows
+}
+
+// Sent 获取直接发送至队列的消息记录
+func (d *Driver) Sent(queue string) []Published {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rows := make([]Published, 0)
+	for _, p := range d.published {
+		if p.Queue == queue {
+			rows = append(rows, p)
+		}
+	}
+	return rows
+}
+
+// Nacked 获取处理器拒绝的消息记录
+func (d *Driver) Nacked(queue string) []Published {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rows := make([]Published, 0)
+	for _, p := range d.nacked {
+		if p.Queue == queue {
+			rows = append(rows, p)
+		}
+	}
+	return rows
+}
+
+// Reset 清空消息记录及待投递的消息
+func (d *Driver) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.published, d.nacked, d.scheduled = nil, nil, nil
+}
+
+// record 记录发布内容
+func (d *Driver) record(p Published) {
+	p.Message = new(bus.Message)
+	if json.Unmarshal(p.Data, p.Message) != nil {
+		p.Message = nil
+	}
+	d.mu.Lock()
+	d.published = append(d.published, p)
+	d.mu.Unlock()
+}
+
+// deliver 同步投递消息, 处理器未就绪时暂存
+func (d *Driver) deliver(queue string, data []byte) bool {
+	d.mu.Lock()
+	handler, ok := d.receivers[queue]
+	if !ok {
+		d.pending[queue] = append(d.pending[queue], data)
+	}
+	d.mu.Unlock()
+	return ok && d.handle(queue, data, handler)
+}
+
+// handle 调用处理器, 拒绝的消息仅做记录不再重新投递
+func (d *Driver) handle(queue string, data []byte, handler func([]byte) bus.Result) bool {
+	result := handler(data)
+	if result.Acked() {
+		return true
+	}
+	p := Published{Queue: queue, Data: data, Message: new(bus.Message), Result: result}
+	if json.Unmarshal(data, p.Message) != nil {
+		p.Message = nil
+	}
+	d.mu.Lock()
+	d.nacked = append(d.nacked, p)
+	d.mu.Unlock()
+	return false
+}
@@ -0,0 +1,73 @@
+package bustest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/easy-bus/bus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDriver(t *testing.T) {
+	drv := NewDriver()
+	sender := (&bus.Sender{Topic: "user", Driver: drv}).Prepare()
+	var received []string
+	handler := &bus.Handler{
+		Queue:     "user.created",
+		Driver:    drv,
+		Subscribe: bus.Subscribe{Topic: "user", RouteKey: "created"},
+		HandleFunc: func(msg *bus.Message) bool {
+			var name string
+			msg.Scan(&name)
+			received = append(received, name)
+			return name != "bad"
+		},
+		EnsureFunc: func(msg *bus.Message) bool { return true },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	drv.Run(ctx, handler)
+	assert.Nil(t, sender.Send(bus.MessageWithId("1", "Jim", "created")))
+	assert.Nil(t, sender.Send(bus.MessageWithId("2", "Tom", "deleted")))
+	assert.Equal(t, []string{"Jim"}, received)
+	drv.AssertPublished(t, "user", All(BizUID("1"), Payload("Jim")))
+	drv.AssertPublishedCount(t, "user", RouteKey("deleted"), 1)
+	drv.AssertNotPublished(t, "user", Payload("Lucy"))
+	assert.True(t, drv.Deliver("user.created", bus.MessageWithId("3", "Lucy", "created")))
+	assert.Len(t, drv.Nacked("user.created"), 0)
+	cancel()
+	handler.Wait()
+}
+
+func TestDriverRetry(t *testing.T) {
+	drv := NewDriver()
+	sender := (&bus.Sender{Topic: "order", Driver: drv}).Prepare()
+	var attempts int
+	handler := &bus.Handler{
+		Queue:       "order.paid",
+		Driver:      drv,
+		Concurrency: 1,
+		Subscribe:   bus.Subscribe{Topic: "order", RouteKey: "paid"},
+		HandleFunc: func(msg *bus.Message) bool {
+			attempts++
+			return attempts > 2
+		},
+		EnsureFunc: func(msg *bus.Message) bool { return true },
+		RetryDelay: func(attempts int) time.Duration { return time.Second * time.Duration(attempts) },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	drv.Run(ctx, handler)
+	assert.Nil(t, sender.Send(bus.MessageWithId("1", "o1", "paid")))
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, 1, drv.Scheduled("order.paid"))
+	assert.Equal(t, 0, drv.Flush())
+	assert.Equal(t, 1, drv.Advance(time.Second))
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, 0, drv.Advance(time.Second))
+	assert.Equal(t, 1, drv.Advance(time.Second))
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 0, drv.Scheduled("order.paid"))
+	AssertPublished(t, drv, "order", BizUID("1"))
+	cancel()
+	handler.Wait()
+}
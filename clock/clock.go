@@ -0,0 +1,149 @@
+// Package clock 定义时钟抽象
+// 定时重试, 延迟投递及退避等待均通过时钟获取时间, 测试中可替换为模拟时钟快进时间
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 时钟
+type Clock interface {
+	// Now 当前时间
+	Now() time.Time
+
+	// NewTimer 创建在d之后触发的定时器
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer 定时器
+type Timer interface {
+	// C 触发通道
+	C() <-chan time.Time
+
+	// Stop 停止定时器, 返回定时器是否处于等待触发状态
+	Stop() bool
+
+	// Reset 重新设定在d之后触发, 返回定时器是否处于等待触发状态
+	Reset(d time.Duration) bool
+}
+
+// Real 系统时钟
+var Real Clock = realClock{}
+
+// Or 获取c, 为空时返回系统时钟
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+// realClock 系统时钟实现
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+// realTimer 系统定时器实现
+type realTimer struct{ *time.Timer }
+
+func (rt realTimer) C() <-chan time.Time { return rt.Timer.C }
+
+// Mock 模拟时钟
+// 时间仅在调用Add或Set时前进, 到期的定时器随之触发
+type Mock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers map[*mockTimer]bool
+}
+
+// NewMock 创建起始于now的模拟时钟
+func NewMock(now time.Time) *Mock {
+	m := &Mock{now: now, timers: make(map[*mockTimer]bool)}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+func (m *Mock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *Mock) NewTimer(d time.Duration) Timer {
+	t := &mockTimer{mock: m, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Add 时间前进d, 触发全部到期的定时器
+func (m *Mock) Add(d time.Duration) {
+	m.Set(m.Now().Add(d))
+}
+
+// Set 时间设置为t, 触发全部到期的定时器
+func (m *Mock) Set(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = t
+	for timer := range m.timers {
+		if !timer.at.After(t) {
+			delete(m.timers, timer)
+			select {
+			case timer.c <- t:
+			default:
+			}
+		}
+	}
+	m.cond.Broadcast()
+}
+
+// BlockUntil 阻塞直至至少有n个定时器等待触发
+// 用于确认被测协程已进入等待后再快进时间
+func (m *Mock) BlockUntil(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.timers) < n {
+		m.cond.Wait()
+	}
+}
+
+// mockTimer 模拟定时器
+type mockTimer struct {
+	mock *Mock
+	c    chan time.Time
+	at   time.Time
+}
+
+func (t *mockTimer) C() <-chan time.Time { return t.c }
+
+func (t *mockTimer) Stop() bool {
+	t.mock.mu.Lock()
+	defer t.mock.mu.Unlock()
+	active := t.mock.timers[t]
+	delete(t.mock.timers, t)
+	t.mock.cond.Broadcast()
+	return active
+}
+
+func (t *mockTimer) Reset(d time.Duration) bool {
+	m := t.mock
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	active := m.timers[t]
+	t.at = m.now.Add(d)
+	if d <= 0 {
+		delete(m.timers, t)
+		select {
+		case t.c <- m.now:
+		default:
+		}
+	} else {
+		m.timers[t] = true
+	}
+	m.cond.Broadcast()
+	return active
+}
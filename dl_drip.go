@@ -0,0 +1,147 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/easy-bus/bus/clock"
+)
+
+// DripProgress 死信重放进度
+type DripProgress struct {
+	// Total 本次重放的死信总数
+	Total int
+
+	// Replayed 已重新投递的数量
+	Replayed int
+
+	// Failed 投递失败的数量, 失败的死信保留在存储中
+	Failed int
+
+	// Stopped 是否中途停止
+	Stopped bool
+
+	// Done 是否已结束
+	Done bool
+}
+
+// Remaining 尚未重放的数量
+func (p DripProgress) Remaining() int { return p.Total - p.Replayed - p.Failed }
+
+// DripReplay 限速重放死信
+// 按固定速率将处理器的死信重新投递至处理队列, 由处理器随实时消息一同消费
+// 避免故障恢复后大批量重放立即压垮刚恢复的下游系统
+// 可随时停止, 未重放的死信保留在存储中, 再次运行时继续重放
+type DripReplay struct {
+	// Handler 死信所属的处理器
+	Handler *Handler
+
+	// Rate 每秒重放的数量, 默认为10
+	Rate int
+
+	// OnProgress 进度回调, 每条死信投递后调用
+	OnProgress func(p DripProgress)
+
+	// Clock 时钟, 默认为系统时钟
+	Clock Clock
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	progress DripProgress
+}
+
+// Run 开始重放, 直至全部重放或被停止, 返回最终进度
+func (dr *DripReplay) Run(ctx context.Context) (DripProgress, error) {
+	if dr.Handler == nil {
+		throw("the drip replay missing handler")
+	}
+	h := dr.Handler.Prepare()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dr.mu.Lock()
+	if dr.cancel != nil {
+		dr.mu.Unlock()
+		return dr.Progress(), fmt.Errorf("drip replay [%s] is running", h.Queue)
+	}
+	dr.cancel, dr.progress = cancel, DripProgress{}
+	dr.mu.Unlock()
+	defer func() {
+		dr.mu.Lock()
+		dr.cancel = nil
+		dr.mu.Unlock()
+	}()
+	rows, err := h.DLStorage.Fetch(h.Queue)
+	if err != nil {
+		dr.update(func(p *DripProgress) { p.Done = true })
+		return dr.Progress(), fmt.Errorf("drip replay [%s] fetch failed, %v", h.Queue, err)
+	}
+	ids := make([]string, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids) // 按标识顺序重放, 便于观察进度
+	dr.update(func(p *DripProgress) { p.Total = len(ids) })
+	timer := clock.Or(dr.Clock).NewTimer(0)
+	defer timer.Stop()
+	for _, id := range ids {
+		select {
+		case <-ctx.Done():
+			dr.update(func(p *DripProgress) { p.Stopped, p.Done = true, true })
+			return dr.Progress(), nil
+		case <-timer.C():
+		}
+		timer.Reset(time.Second / time.Duration(dr.rate()))
+		if err := h.Driver.SendToQueue(h.Queue, rows[id], 0); err != nil {
+			h.Logger.Errorf("drip replay [%s] requeue [%s] error, %v", h.Queue, id, err)
+			dr.report(func(p *DripProgress) { p.Failed++ })
+			continue
+		}
+		if err := h.DLStorage.Remove(id); err != nil {
+			h.Logger.Errorf("drip replay [%s] delete [%s] error, %v", h.Queue, id, err)
+		}
+		dr.report(func(p *DripProgress) { p.Replayed++ })
+	}
+	dr.update(func(p *DripProgress) { p.Done = true })
+	return dr.Progress(), nil
+}
+
+// Stop 停止重放
+func (dr *DripReplay) Stop() {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if dr.cancel != nil {
+		dr.cancel()
+	}
+}
+
+// Progress 获取当前进度
+func (dr *DripReplay) Progress() DripProgress {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return dr.progress
+}
+
+// update 更新进度
+func (dr *DripReplay) update(fn func(p *DripProgress)) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	fn(&dr.progress)
+}
+
+// report 更新进度并回调
+func (dr *DripReplay) report(fn func(p *DripProgress)) {
+	dr.update(fn)
+	if dr.OnProgress != nil {
+		dr.OnProgress(dr.Progress())
+	}
+}
+
+func (dr *DripReplay) rate() int {
+	if dr.Rate <= 0 {
+		return 10
+	}
+	return dr.Rate
+}
@@ -0,0 +1,79 @@
+package bus
+
+import (
+	"context"
+	"time"
+
+	"github.com/easy-bus/bus/clock"
+)
+
+// DLJanitor 死信清理器
+// 定时清理超出保留时长的死信, 防止死信存储无限增长
+type DLJanitor struct {
+	// Retention 死信保留时长, 必须 > 0
+	Retention time.Duration
+
+	// Interval 清理间隔, 默认为1小时
+	Interval time.Duration
+
+	// ArchiveFunc 删除前的归档回调
+	// 若返回错误则保留该死信, 待下次清理时重试
+	ArchiveFunc func(queue, id string, data []byte) error
+
+	// Clock 时钟, 默认为系统时钟
+	Clock Clock
+}
+
+// run 定时清理处理器的过期死信, 直至上下文结束
+func (j *DLJanitor) run(ctx context.Context, h *Handler) {
+	if j.Retention <= 0 {
+		h.Logger.Errorf("handler [%s] dl janitor disabled, the retention must > 0", h.Queue)
+		return
+	}
+	if _, ok := h.DLStorage.(ExpiredDLStorageInterface); !ok {
+		h.Logger.Errorf("handler [%s] dl janitor disabled, the dl storage does not support expired fetch", h.Queue)
+		return
+	}
+	interval := j.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	timer := clock.Or(j.Clock).NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+			j.clean(h)
+			timer.Reset(interval)
+		}
+	}
+}
+
+// clean 清理一轮过期死信, 返回删除的数量
+func (j *DLJanitor) clean(h *Handler) (removed int) {
+	storage, ok := h.DLStorage.(ExpiredDLStorageInterface)
+	if !ok {
+		return 0
+	}
+	rows, err := storage.Expired(h.Queue, clock.Or(j.Clock).Now().Add(-j.Retention))
+	if err != nil {
+		h.Logger.Errorf("handler [%s] dl expired fetch error, %v", h.Queue, err)
+		return 0
+	}
+	for id, data := range rows {
+		if j.ArchiveFunc != nil {
+			if err := j.ArchiveFunc(h.Queue, id, data); err != nil {
+				h.Logger.Errorf("handler [%s] dl archive [%s] error, %v", h.Queue, id, err)
+				continue
+			}
+		}
+		if err := h.DLStorage.Remove(id); err != nil {
+			h.Logger.Errorf("handler [%s] dl remove [%s] error, %v", h.Queue, id, err)
+			continue
+		}
+		removed++
+	}
+	return removed
+}
@@ -0,0 +1,122 @@
+package bus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DLEvent 死信事件
+type DLEvent struct {
+	// Queue 处理队列名称
+	Queue string `json:"queue"`
+
+	// BizUID 消息唯一标识, 消息无法解码时为空
+	BizUID string `json:"biz_uid,omitempty"`
+
+	// Retried 已重试次数
+	Retried int `json:"retried"`
+
+	// Reason 进入死信的原因
+	Reason string `json:"reason,omitempty"`
+
+	// Native 是否转入驱动的原生死信队列
+	Native bool `json:"native,omitempty"`
+
+	// At 进入死信的时间
+	At time.Time `json:"at"`
+
+	// Data 消息原始内容
+	Data []byte `json:"-"`
+}
+
+// DLNotifier 死信通知
+// 消息进入死信后异步调用, 用于及时告警
+type DLNotifier interface {
+	Notify(event *DLEvent) error
+}
+
+// DLNotifierFunc 函数形式的死信通知
+type DLNotifierFunc func(event *DLEvent) error
+
+func (fn DLNotifierFunc) Notify(event *DLEvent) error { return fn(event) }
+
+// WebhookNotifier 以HTTP POST推送JSON格式的死信事件
+type WebhookNotifier struct {
+	// URL 推送地址
+	URL string
+
+	// Secret 签名密钥, 为空则不签名, 签名方式同SignWebhook
+	Secret string
+
+	// Client HTTP客户端, 默认超时10秒
+	Client *http.Client
+}
+
+func (wn *WebhookNotifier) Notify(event *DLEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, wn.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wn.Secret != "" {
+		ts := time.Now().Unix()
+		req.Header.Set(WebhookTimestampHeader, fmt.Sprint(ts))
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(wn.Secret, ts, body))
+	}
+	return notifyPost(wn.Client, req)
+}
+
+// SlackNotifier 通过Slack的Incoming Webhook发送死信告警
+type SlackNotifier struct {
+	// WebhookURL Slack的Incoming Webhook地址
+	WebhookURL string
+
+	// Channel 覆盖默认频道, 为空则使用Webhook配置的频道
+	Channel string
+
+	// Client HTTP客户端, 默认超时10秒
+	Client *http.Client
+}
+
+func (sn *SlackNotifier) Notify(event *DLEvent) error {
+	text := fmt.Sprintf(
+		":rotating_light: message `%s` dead-lettered in queue `%s` after %d retries\n>%s",
+		event.BizUID, event.Queue, event.Retried, event.Reason,
+	)
+	payload := map[string]string{"text": text}
+	if sn.Channel != "" {
+		payload["channel"] = sn.Channel
+	}
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequest(http.MethodPost, sn.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return notifyPost(sn.Client, req)
+}
+
+// notifyPost 发送通知请求, 非2xx响应视为失败
+func notifyPost(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status [%d]", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package bus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DLRetrier 死信重试器
+// 独立于处理器的实时消费, 以自身的并发, 限速及退避策略重新处理死信
+// 避免大量死信重试时与实时消息争抢处理资源
+type DLRetrier struct {
+	// Interval 重试间隔, 默认为1分钟
+	Interval time.Duration
+
+	// Concurrency 并发处理数量, 默认为1
+	Concurrency int
+
+	// RateLimit 每秒最多处理的死信数量
+	// 若 <= 0 则不限速
+	RateLimit int
+
+	// Backoff 退避策略
+	// failures 为连续存在处理失败的轮次
+	// 返回值为下一轮重试前在Interval之外额外等待的时长
+	Backoff func(failures int) time.Duration
+
+	// Scheduler 重试调度策略
+	// 为空时按Interval及Backoff定时重试
+	Scheduler RetryScheduler
+
+	// Clock 默认定时重试及限速使用的时钟, 默认为系统时钟
+	Clock Clock
+}
+
+// run 按调度策略重试处理器的死信, 直至上下文结束
+func (r *DLRetrier) run(ctx context.Context, h *Handler) {
+	scheduler := r.Scheduler
+	if scheduler == nil {
+		scheduler = &TickerScheduler{Interval: r.Interval, Backoff: r.Backoff, Clock: r.Clock}
+	}
+	scheduler.Schedule(ctx, retryTask{ctx: ctx, retrier: r, handler: h})
+}
+
+// retryTask 绑定处理器的重试任务
+type retryTask struct {
+	ctx     context.Context
+	retrier *DLRetrier
+	handler *Handler
+}
+
+func (rt retryTask) Redrive() int { return rt.retrier.redrive(rt.ctx, rt.handler) }
+
+func (rt retryTask) Requeue(delay time.Duration) int { return rt.retrier.requeue(rt.handler, delay) }
+
+// redrive 重新处理一轮死信, 返回处理失败的数量
+func (r *DLRetrier) redrive(ctx context.Context, h *Handler) (failed int) {
+	rows, err := h.DLStorage.Fetch(h.Queue)
+	if err != nil {
+		h.Logger.Errorf("retry fetch [%s] error, %v", h.Queue, err)
+		return 1
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	limiter := &rateLimiter{rate: r.RateLimit, clock: r.Clock}
+	sem := make(chan struct{}, r.concurrency())
+	for id, data := range rows {
+		if limiter.wait(ctx) != nil || ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		goroutine(func(id string, data []byte) func() {
+			return func() {
+				defer func() { <-sem; wg.Done() }()
+				if _, outcome := h.process(data, nil, true); !redriven(outcome) {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+					return
+				}
+				if err := h.DLStorage.Remove(id); err != nil {
+					h.Logger.Errorf("retry delete [%s] error, %v", id, err)
+				}
+			}
+		}(id, data))
+	}
+	wg.Wait()
+	return failed
+}
+
+// redriven 死信重试的处理结论是否可移除死信
+// 处理成功, 已处理或已无需处理的消息移除死信, 其余保留待下轮重试
+func redriven(outcome string) bool {
+	switch outcome {
+	case OutcomeDone, OutcomeSkipped, OutcomeFiltered, OutcomeExpired:
+		return true
+	}
+	return false
+}
+
+// requeue 将死信延迟投递回处理队列, 返回投递失败的数量
+func (r *DLRetrier) requeue(h *Handler, delay time.Duration) (failed int) {
+	rows, err := h.DLStorage.Fetch(h.Queue)
+	if err != nil {
+		h.Logger.Errorf("retry fetch [%s] error, %v", h.Queue, err)
+		return 1
+	}
+	for id, data := range rows {
+		if err := h.Driver.SendToQueue(h.Queue, data, delay); err != nil {
+			h.Logger.Errorf("retry requeue [%s] error, %v", id, err)
+			failed++
+			continue
+		}
+		if err := h.DLStorage.Remove(id); err != nil {
+			h.Logger.Errorf("retry delete [%s] error, %v", id, err)
+		}
+	}
+	return failed
+}
+
+func (r *DLRetrier) concurrency() int {
+	if r.Concurrency <= 0 {
+		return 1
+	}
+	return r.Concurrency
+}
@@ -0,0 +1,266 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/easy-bus/bus/clock"
+)
+
+// FailoverDriver 故障转移驱动
+// 消息优先发送至主驱动, 主驱动异常时自动转移至备用驱动
+// 转移期间定时探测主驱动, 恢复后重新切回主驱动
+// 未指定Probe时不做主动探测, 每隔ProbeInterval以实际发送的消息试探主驱动
+// 队列, 主题及订阅关系在两个驱动上同时创建, 消费同时监听两个驱动
+// 可选能力仅在两个驱动均具备时声明, 否则降级为基础驱动接口
+type FailoverDriver struct {
+	// Primary 主驱动
+	Primary DriverInterface
+
+	// Secondary 备用驱动
+	Secondary DriverInterface
+
+	// ProbeInterval 主驱动健康探测间隔, 默认为10秒
+	ProbeInterval time.Duration
+
+	// Probe 健康探测函数, 应避免对中间件产生副作用
+	// 为空时不启动探测协程, 转移后每隔ProbeInterval将发送重新交由主驱动尝试
+	Probe func(driver DriverInterface) error
+
+	// Logger 异常日志
+	Logger LoggerInterface
+
+	// Clock 时钟, 默认为系统时钟
+	Clock Clock
+
+	mu      sync.RWMutex
+	failed  bool
+	probing bool
+	closed  bool
+	retryAt time.Time
+	quit    chan struct{}
+}
+
+func (fd *FailoverDriver) CreateQueue(name string, delay time.Duration) error {
+	return fd.both(func(drv DriverInterface) error { return drv.CreateQueue(name, delay) })
+}
+
+func (fd *FailoverDriver) CreateTopic(name string) error {
+	return fd.both(func(drv DriverInterface) error { return drv.CreateTopic(name) })
+}
+
+func (fd *FailoverDriver) Subscribe(topic, queue, routeKey string) error {
+	return fd.both(func(drv DriverInterface) error { return drv.Subscribe(topic, queue, routeKey) })
+}
+
+func (fd *FailoverDriver) UnSubscribe(topic, queue, routeKey string) error {
+	return fd.both(func(drv DriverInterface) error { return drv.UnSubscribe(topic, queue, routeKey) })
+}
+
+func (fd *FailoverDriver) SendToQueue(queue string, content []byte, delay time.Duration) error {
+	return fd.send(func(drv DriverInterface) error { return drv.SendToQueue(queue, content, delay) })
+}
+
+func (fd *FailoverDriver) SendToTopic(topic string, content []byte, routeKey string) error {
+	return fd.send(func(drv DriverInterface) error { return drv.SendToTopic(topic, content, routeKey) })
+}
+
+func (fd *FailoverDriver) ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) Result) {
+	fd.receive(func(drv DriverInterface) { drv.ReceiveMessage(ctx, queue, errChan, handler) })
+}
+
+func (fd *FailoverDriver) CreateQueueWithDL(name string, delay time.Duration, dlQueue string) error {
+	return fd.both(func(drv DriverInterface) error {
+		dd, ok := drv.(DeadLetterDriverInterface)
+		if !ok {
+			return errUnsupported(drv, "dead letter")
+		}
+		return dd.CreateQueueWithDL(name, delay, dlQueue)
+	})
+}
+
+func (fd *FailoverDriver) SendToTopicConfirmed(topic string, content []byte, routeKey string) (id string, err error) {
+	err = fd.send(func(drv DriverInterface) error {
+		cd, ok := drv.(ConfirmDriverInterface)
+		if !ok {
+			return errUnsupported(drv, "confirm")
+		}
+		id, err = cd.SendToTopicConfirmed(topic, content, routeKey)
+		return err
+	})
+	return id, err
+}
+
+func (fd *FailoverDriver) SendToTopicAt(topic string, content []byte, routeKey string, at time.Time) error {
+	return fd.send(func(drv DriverInterface) error {
+		sd, ok := drv.(ScheduleDriverInterface)
+		if !ok {
+			return errUnsupported(drv, "schedule")
+		}
+		return sd.SendToTopicAt(topic, content, routeKey, at)
+	})
+}
+
+func (fd *FailoverDriver) ReceiveMessageExtend(ctx context.Context, queue string, errChan chan error, handler func(data []byte, extend func(time.Duration) error) Result) {
+	fd.receive(func(drv DriverInterface) {
+		if ed, ok := drv.(ExtendableDriverInterface); ok {
+			ed.ReceiveMessageExtend(ctx, queue, errChan, handler)
+		} else {
+			errChan <- errUnsupported(drv, "extend")
+		}
+	})
+}
+
+func (fd *FailoverDriver) ReceiveMessageBatchAck(ctx context.Context, queue string, errChan chan error, batch BatchAck, handler func([]byte) Result) {
+	fd.receive(func(drv DriverInterface) {
+		if bd, ok := drv.(BatchAckDriverInterface); ok {
+			bd.ReceiveMessageBatchAck(ctx, queue, errChan, batch, handler)
+		} else {
+			errChan <- errUnsupported(drv, "batch ack")
+		}
+	})
+}
+
+// Capabilities 获取两个驱动共同具备的能力
+func (fd *FailoverDriver) Capabilities() Capability {
+	return commonCapabilities(fd.Primary, fd.Secondary)
+}
+
+// Healthy 主驱动是否处于正常状态
+func (fd *FailoverDriver) Healthy() bool {
+	fd.mu.RLock()
+	defer fd.mu.RUnlock()
+	return !fd.failed
+}
+
+// Close 停止健康探测, 不会关闭主备驱动
+func (fd *FailoverDriver) Close() error {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if !fd.closed {
+		fd.closed = true
+		if fd.quit != nil {
+			close(fd.quit)
+		}
+	}
+	return nil
+}
+
+// both 在两个驱动上执行, 任一成功即视为成功
+func (fd *FailoverDriver) both(fn func(drv DriverInterface) error) error {
+	perr, serr := fn(fd.Primary), fn(fd.Secondary)
+	if perr != nil && serr != nil {
+		return fmt.Errorf("failover driver: primary failed, %v; secondary failed, %v", perr, serr)
+	}
+	if perr != nil {
+		fd.logger().Errorf("failover driver: primary failed, %v", perr)
+	} else if serr != nil {
+		fd.logger().Errorf("failover driver: secondary failed, %v", serr)
+	}
+	return nil
+}
+
+// receive 同时监听两个驱动, 直至全部退出
+func (fd *FailoverDriver) receive(fn func(drv DriverInterface)) {
+	var wg sync.WaitGroup
+	for _, drv := range []DriverInterface{fd.Primary, fd.Secondary} {
+		wg.Add(1)
+		goroutine(func(drv DriverInterface) func() {
+			return func() {
+				defer wg.Done()
+				fn(drv)
+			}
+		}(drv))
+	}
+	wg.Wait()
+}
+
+// send 发送消息, 主驱动失败时转移至备用驱动
+func (fd *FailoverDriver) send(fn func(drv DriverInterface) error) error {
+	if healthy, trial := fd.state(); healthy || trial {
+		err := fn(fd.Primary)
+		if err == nil {
+			if trial {
+				fd.recover()
+			}
+			return nil
+		}
+		fd.logger().Errorf("failover driver: primary send failed, failover to secondary, %v", err)
+		fd.failover()
+	}
+	return fn(fd.Secondary)
+}
+
+// state 获取主驱动状态, trial 表示主驱动故障但已到达试探时间
+func (fd *FailoverDriver) state() (healthy, trial bool) {
+	fd.mu.RLock()
+	defer fd.mu.RUnlock()
+	if !fd.failed {
+		return true, false
+	}
+	return false, fd.Probe == nil && !clock.Or(fd.Clock).Now().Before(fd.retryAt)
+}
+
+// failover 标记主驱动故障, 指定Probe时启动健康探测
+func (fd *FailoverDriver) failover() {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.failed = true
+	fd.retryAt = clock.Or(fd.Clock).Now().Add(fd.interval())
+	if fd.Probe == nil || fd.probing || fd.closed {
+		return
+	}
+	if fd.quit == nil {
+		fd.quit = make(chan struct{})
+	}
+	fd.probing = true
+	goroutine(fd.probe)
+}
+
+// recover 标记主驱动恢复
+func (fd *FailoverDriver) recover() {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.failed = false
+}
+
+// probe 定时探测主驱动, 恢复或关闭后退出
+func (fd *FailoverDriver) probe() {
+	timer := clock.Or(fd.Clock).NewTimer(fd.interval())
+	defer timer.Stop()
+	for {
+		select {
+		case <-fd.quit:
+			fd.mu.Lock()
+			fd.probing = false
+			fd.mu.Unlock()
+			return
+		case <-timer.C():
+		}
+		if err := fd.Probe(fd.Primary); err != nil {
+			timer.Reset(fd.interval())
+			continue
+		}
+		fd.mu.Lock()
+		fd.failed, fd.probing = false, false
+		fd.mu.Unlock()
+		return
+	}
+}
+
+// interval 获取探测间隔
+func (fd *FailoverDriver) interval() time.Duration {
+	if fd.ProbeInterval <= 0 {
+		return 10 * time.Second
+	}
+	return fd.ProbeInterval
+}
+
+func (fd *FailoverDriver) logger() LoggerInterface {
+	if fd.Logger == nil {
+		return stderrLogger{}
+	}
+	return fd.Logger
+}
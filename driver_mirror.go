@@ -0,0 +1,198 @@
+package bus
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MirrorParity 镜像发送统计
+type MirrorParity struct {
+	// Kind 目标类型, topic 或 queue
+	Kind string
+
+	// Name 主题或队列名称
+	Name string
+
+	// Primary 主驱动发送成功数量
+	Primary uint64
+
+	// Mirrored 镜像驱动发送成功数量
+	Mirrored uint64
+
+	// Failed 镜像驱动发送失败数量
+	Failed uint64
+}
+
+// Consistent 主驱动与镜像驱动的发送数量是否一致
+func (mp MirrorParity) Consistent() bool { return mp.Primary == mp.Mirrored }
+
+// MirrorDriver 镜像驱动
+// 所有发送操作双写至主驱动与镜像驱动, 消费仅来自主驱动
+// 发送结果以主驱动为准, 镜像驱动的失败仅记录不影响业务
+// 用于消息中间件的无停机迁移, 并通过Parity校验双写的一致性
+// 死信及定时投递需两个驱动均具备, 其余可选能力仅取决于主驱动
+type MirrorDriver struct {
+	// Primary 主驱动
+	Primary DriverInterface
+
+	// Mirror 镜像驱动
+	Mirror DriverInterface
+
+	// Logger 异常日志
+	Logger LoggerInterface
+
+	mu     sync.Mutex
+	parity map[string]*MirrorParity
+}
+
+func (md *MirrorDriver) CreateQueue(name string, delay time.Duration) error {
+	return md.both(func(drv DriverInterface) error { return drv.CreateQueue(name, delay) })
+}
+
+func (md *MirrorDriver) CreateTopic(name string) error {
+	return md.both(func(drv DriverInterface) error { return drv.CreateTopic(name) })
+}
+
+func (md *MirrorDriver) Subscribe(topic, queue, routeKey string) error {
+	return md.both(func(drv DriverInterface) error { return drv.Subscribe(topic, queue, routeKey) })
+}
+
+func (md *MirrorDriver) UnSubscribe(topic, queue, routeKey string) error {
+	return md.both(func(drv DriverInterface) error { return drv.UnSubscribe(topic, queue, routeKey) })
+}
+
+func (md *MirrorDriver) SendToQueue(queue string, content []byte, delay time.Duration) error {
+	return md.send("queue", queue, func(drv DriverInterface) error { return drv.SendToQueue(queue, content, delay) })
+}
+
+func (md *MirrorDriver) SendToTopic(topic string, content []byte, routeKey string) error {
+	return md.send("topic", topic, func(drv DriverInterface) error { return drv.SendToTopic(topic, content, routeKey) })
+}
+
+func (md *MirrorDriver) ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) Result) {
+	md.Primary.ReceiveMessage(ctx, queue, errChan, handler)
+}
+
+func (md *MirrorDriver) CreateQueueWithDL(name string, delay time.Duration, dlQueue string) error {
+	return md.both(func(drv DriverInterface) error {
+		dd, ok := drv.(DeadLetterDriverInterface)
+		if !ok {
+			return errUnsupported(drv, "dead letter")
+		}
+		return dd.CreateQueueWithDL(name, delay, dlQueue)
+	})
+}
+
+// SendToTopicConfirmed 发送消息至主题, 以主驱动的确认为准, 镜像驱动普通发送
+func (md *MirrorDriver) SendToTopicConfirmed(topic string, content []byte, routeKey string) (string, error) {
+	cd, ok := md.Primary.(ConfirmDriverInterface)
+	if !ok {
+		return "", errUnsupported(md.Primary, "confirm")
+	}
+	id, err := cd.SendToTopicConfirmed(topic, content, routeKey)
+	if err != nil {
+		return "", err
+	}
+	md.mirror("topic", topic, md.Mirror.SendToTopic(topic, content, routeKey))
+	return id, nil
+}
+
+func (md *MirrorDriver) SendToTopicAt(topic string, content []byte, routeKey string, at time.Time) error {
+	return md.send("topic", topic, func(drv DriverInterface) error {
+		sd, ok := drv.(ScheduleDriverInterface)
+		if !ok {
+			return errUnsupported(drv, "schedule")
+		}
+		return sd.SendToTopicAt(topic, content, routeKey, at)
+	})
+}
+
+func (md *MirrorDriver) ReceiveMessageExtend(ctx context.Context, queue string, errChan chan error, handler func(data []byte, extend func(time.Duration) error) Result) {
+	ed, ok := md.Primary.(ExtendableDriverInterface)
+	if !ok {
+		errChan <- errUnsupported(md.Primary, "extend")
+		return
+	}
+	ed.ReceiveMessageExtend(ctx, queue, errChan, handler)
+}
+
+func (md *MirrorDriver) ReceiveMessageBatchAck(ctx context.Context, queue string, errChan chan error, batch BatchAck, handler func([]byte) Result) {
+	bd, ok := md.Primary.(BatchAckDriverInterface)
+	if !ok {
+		errChan <- errUnsupported(md.Primary, "batch ack")
+		return
+	}
+	bd.ReceiveMessageBatchAck(ctx, queue, errChan, batch, handler)
+}
+
+// Capabilities 获取镜像驱动具备的能力
+func (md *MirrorDriver) Capabilities() Capability {
+	both := CapabilityDeadLetter | CapabilitySchedule
+	return commonCapabilities(md.Primary, md.Mirror)&both | Capabilities(md.Primary)&^both
+}
+
+// Parity 获取各主题及队列的双写统计
+func (md *MirrorDriver) Parity() []MirrorParity {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	rows := make([]MirrorParity, 0, len(md.parity))
+	for _, p := range md.parity {
+		rows = append(rows, *p)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Kind+rows[i].Name < rows[j].Kind+rows[j].Name
+	})
+	return rows
+}
+
+// both 在两个驱动上执行, 以主驱动结果为准
+func (md *MirrorDriver) both(fn func(drv DriverInterface) error) error {
+	if err := fn(md.Primary); err != nil {
+		return err
+	}
+	if err := fn(md.Mirror); err != nil {
+		md.logger().Errorf("mirror driver: mirror failed, %v", err)
+	}
+	return nil
+}
+
+// send 双写消息
+func (md *MirrorDriver) send(kind, name string, fn func(drv DriverInterface) error) error {
+	if err := fn(md.Primary); err != nil {
+		return err
+	}
+	md.mirror(kind, name, fn(md.Mirror))
+	return nil
+}
+
+// mirror 记录镜像发送的统计
+func (md *MirrorDriver) mirror(kind, name string, err error) {
+	md.mu.Lock()
+	if md.parity == nil {
+		md.parity = make(map[string]*MirrorParity)
+	}
+	p, ok := md.parity[kind+":"+name]
+	if !ok {
+		p = &MirrorParity{Kind: kind, Name: name}
+		md.parity[kind+":"+name] = p
+	}
+	p.Primary++
+	if err != nil {
+		p.Failed++
+	} else {
+		p.Mirrored++
+	}
+	md.mu.Unlock()
+	if err != nil {
+		md.logger().Errorf("mirror driver: mirror send to %s [%s] failed, %v", kind, name, err)
+	}
+}
+
+func (md *MirrorDriver) logger() LoggerInterface {
+	if md.Logger == nil {
+		return stderrLogger{}
+	}
+	return md.Logger
+}
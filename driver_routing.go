@@ -0,0 +1,223 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"reflect"
+	"time"
+)
+
+// Route 驱动路由规则
+type Route struct {
+	// Topic 主题名称匹配模式, 支持path.Match通配语法
+	// 为空表示该规则不参与主题匹配
+	Topic string
+
+	// Queue 队列名称匹配模式, 支持path.Match通配语法
+	// 为空表示该规则不参与队列匹配
+	Queue string
+
+	// Driver 匹配成功时使用的驱动
+	Driver DriverInterface
+}
+
+// RoutingDriver 路由驱动
+// 根据主题及队列名称将操作分派给不同的驱动, 规则按顺序匹配, 首个匹配生效
+// 适用于在同一服务中逐步将部分主题迁移至新的消息中间件
+// 可选能力仅在全部规则及默认驱动均具备时声明, 否则降级为基础驱动接口
+type RoutingDriver struct {
+	// Routes 路由规则
+	Routes []Route
+
+	// Default 未匹配任何规则时使用的驱动
+	Default DriverInterface
+}
+
+func (rd *RoutingDriver) CreateQueue(name string, delay time.Duration) error {
+	drv, err := rd.queueDriver(name)
+	if err != nil {
+		return err
+	}
+	return drv.CreateQueue(name, delay)
+}
+
+func (rd *RoutingDriver) CreateTopic(name string) error {
+	drv, err := rd.topicDriver(name)
+	if err != nil {
+		return err
+	}
+	return drv.CreateTopic(name)
+}
+
+func (rd *RoutingDriver) Subscribe(topic, queue, routeKey string) error {
+	drv, err := rd.bindDriver(topic, queue)
+	if err != nil {
+		return err
+	}
+	return drv.Subscribe(topic, queue, routeKey)
+}
+
+func (rd *RoutingDriver) UnSubscribe(topic, queue, routeKey string) error {
+	drv, err := rd.bindDriver(topic, queue)
+	if err != nil {
+		return err
+	}
+	return drv.UnSubscribe(topic, queue, routeKey)
+}
+
+func (rd *RoutingDriver) SendToQueue(queue string, content []byte, delay time.Duration) error {
+	drv, err := rd.queueDriver(queue)
+	if err != nil {
+		return err
+	}
+	return drv.SendToQueue(queue, content, delay)
+}
+
+func (rd *RoutingDriver) SendToTopic(topic string, content []byte, routeKey string) error {
+	drv, err := rd.topicDriver(topic)
+	if err != nil {
+		return err
+	}
+	return drv.SendToTopic(topic, content, routeKey)
+}
+
+func (rd *RoutingDriver) ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) Result) {
+	drv, err := rd.queueDriver(queue)
+	if err != nil {
+		errChan <- err
+		return
+	}
+	drv.ReceiveMessage(ctx, queue, errChan, handler)
+}
+
+func (rd *RoutingDriver) CreateQueueWithDL(name string, delay time.Duration, dlQueue string) error {
+	drv, err := rd.queueDriver(name)
+	if err != nil {
+		return err
+	}
+	dd, ok := drv.(DeadLetterDriverInterface)
+	if !ok {
+		return errUnsupported(drv, "dead letter")
+	}
+	return dd.CreateQueueWithDL(name, delay, dlQueue)
+}
+
+func (rd *RoutingDriver) SendToTopicConfirmed(topic string, content []byte, routeKey string) (string, error) {
+	drv, err := rd.topicDriver(topic)
+	if err != nil {
+		return "", err
+	}
+	cd, ok := drv.(ConfirmDriverInterface)
+	if !ok {
+		return "", errUnsupported(drv, "confirm")
+	}
+	return cd.SendToTopicConfirmed(topic, content, routeKey)
+}
+
+func (rd *RoutingDriver) SendToTopicAt(topic string, content []byte, routeKey string, at time.Time) error {
+	drv, err := rd.topicDriver(topic)
+	if err != nil {
+		return err
+	}
+	sd, ok := drv.(ScheduleDriverInterface)
+	if !ok {
+		return errUnsupported(drv, "schedule")
+	}
+	return sd.SendToTopicAt(topic, content, routeKey, at)
+}
+
+func (rd *RoutingDriver) ReceiveMessageExtend(ctx context.Context, queue string, errChan chan error, handler func(data []byte, extend func(time.Duration) error) Result) {
+	drv, err := rd.queueDriver(queue)
+	if err != nil {
+		errChan <- err
+		return
+	}
+	ed, ok := drv.(ExtendableDriverInterface)
+	if !ok {
+		errChan <- errUnsupported(drv, "extend")
+		return
+	}
+	ed.ReceiveMessageExtend(ctx, queue, errChan, handler)
+}
+
+func (rd *RoutingDriver) ReceiveMessageBatchAck(ctx context.Context, queue string, errChan chan error, batch BatchAck, handler func([]byte) Result) {
+	drv, err := rd.queueDriver(queue)
+	if err != nil {
+		errChan <- err
+		return
+	}
+	bd, ok := drv.(BatchAckDriverInterface)
+	if !ok {
+		errChan <- errUnsupported(drv, "batch ack")
+		return
+	}
+	bd.ReceiveMessageBatchAck(ctx, queue, errChan, batch, handler)
+}
+
+// Capabilities 获取全部规则及默认驱动共同具备的能力
+func (rd *RoutingDriver) Capabilities() Capability {
+	drivers := make([]DriverInterface, 0, len(rd.Routes)+1)
+	for _, r := range rd.Routes {
+		drivers = append(drivers, r.Driver)
+	}
+	if rd.Default != nil {
+		drivers = append(drivers, rd.Default)
+	}
+	return commonCapabilities(drivers...)
+}
+
+// topicDriver 获取主题对应的驱动
+func (rd *RoutingDriver) topicDriver(topic string) (DriverInterface, error) {
+	return rd.match(topic, func(r Route) string { return r.Topic })
+}
+
+// queueDriver 获取队列对应的驱动
+func (rd *RoutingDriver) queueDriver(queue string) (DriverInterface, error) {
+	return rd.match(queue, func(r Route) string { return r.Queue })
+}
+
+// bindDriver 获取订阅关系对应的驱动, 主题与队列必须位于同一驱动
+func (rd *RoutingDriver) bindDriver(topic, queue string) (DriverInterface, error) {
+	td, err := rd.topicDriver(topic)
+	if err != nil {
+		return nil, err
+	}
+	qd, err := rd.queueDriver(queue)
+	if err != nil {
+		return nil, err
+	}
+	if !sameDriver(td, qd) {
+		return nil, fmt.Errorf("routing driver: topic [%s] and queue [%s] are routed to different drivers", topic, queue)
+	}
+	return td, nil
+}
+
+// match 按顺序匹配规则
+func (rd *RoutingDriver) match(name string, pattern func(Route) string) (DriverInterface, error) {
+	for _, r := range rd.Routes {
+		p := pattern(r)
+		if p == "" {
+			continue
+		}
+		ok, err := path.Match(p, name)
+		if err != nil {
+			return nil, fmt.Errorf("routing driver: bad pattern [%s], %v", p, err)
+		}
+		if ok {
+			return r.Driver, nil
+		}
+	}
+	if rd.Default == nil {
+		return nil, fmt.Errorf("routing driver: no driver matched [%s]", name)
+	}
+	return rd.Default, nil
+}
+
+// sameDriver 判断是否为同一驱动实例
+func sameDriver(a, b DriverInterface) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
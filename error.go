@@ -1,10 +1,41 @@
 package bus
 
 import (
+	"errors"
 	"fmt"
+	"time"
 )
 
+// ErrExtendUnsupported 驱动不支持延长消息处理期限
+var ErrExtendUnsupported = errors.New("easy-bus: extend unsupported by driver")
+
 // throw 抛出异常错误
 func throw(format string, args ...interface{}) {
 	panic(fmt.Sprintf("easy-bus: %s", fmt.Sprintf(format, args...)))
 }
+
+// RetryError 指定重试延迟的处理错误
+type RetryError struct {
+	Err   error
+	Delay time.Duration
+}
+
+func (re *RetryError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", re.Err, re.Delay)
+}
+
+func (re *RetryError) Unwrap() error { return re.Err }
+
+// RetryAfter 包装处理错误, 指定该消息的重试延迟
+func RetryAfter(err error, delay time.Duration) error {
+	return &RetryError{Err: err, Delay: delay}
+}
+
+// RetryDelayOf 获取错误中指定的重试延迟
+func RetryDelayOf(err error) (time.Duration, bool) {
+	var re *RetryError
+	if errors.As(err, &re) {
+		return re.Delay, true
+	}
+	return 0, false
+}
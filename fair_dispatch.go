@@ -0,0 +1,133 @@
+package bus
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// FairDispatch 按路由键加权公平调度
+// 同一队列接收多种路由键的消息时, 并发处理名额按权重在各路由键之间轮转分配
+// 避免某一路由键的消息洪峰(如批量导入)长时间占满名额, 饿死其他路由键的消息
+// 仅在并发处理数量受限(Concurrency > 0)时生效
+type FairDispatch struct {
+	// Weights 路由键的权重, 名额紧张时按权重比例分配
+	Weights map[string]int
+
+	// DefaultWeight 未配置权重的路由键的默认权重, 默认为1
+	DefaultWeight int
+}
+
+// weight 获取路由键的权重
+func (fd *FairDispatch) weight(routeKey string) int {
+	if fd == nil {
+		return 1
+	}
+	if w, ok := fd.Weights[routeKey]; ok && w > 0 {
+		return w
+	}
+	if fd.DefaultWeight > 0 {
+		return fd.DefaultWeight
+	}
+	return 1
+}
+
+// fairScheduler 加权公平的并发名额调度
+// 等待者按路由键排队, 名额释放时以平滑加权轮询选出下一个路由键
+// 未配置公平调度时全部等待者同属一个队列, 按先后顺序获取名额
+type fairScheduler struct {
+	mu       sync.Mutex
+	fd       *FairDispatch
+	capacity int
+	running  int
+	waiters  map[string][]chan struct{}
+	current  map[string]int
+}
+
+func newFairScheduler(fd *FairDispatch, concurrency int) *fairScheduler {
+	return &fairScheduler{
+		fd:       fd,
+		capacity: concurrency,
+		waiters:  make(map[string][]chan struct{}),
+		current:  make(map[string]int),
+	}
+}
+
+// acquire 获取处理名额, 名额不足时排队等待
+func (fs *fairScheduler) acquire(routeKey string) {
+	if fs.fd == nil {
+		routeKey = ""
+	}
+	fs.mu.Lock()
+	if fs.available() && len(fs.waiters) == 0 {
+		fs.running++
+		fs.mu.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	fs.waiters[routeKey] = append(fs.waiters[routeKey], ch)
+	fs.mu.Unlock()
+	<-ch
+}
+
+// release 释放处理名额, 存在等待者时转交
+func (fs *fairScheduler) release() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.running--
+	fs.dispatch()
+}
+
+// resize 调整名额数量, 若 <= 0 则不做限制
+// 调小时已在处理中的消息不受影响, 待处理数量回落后再分配名额
+func (fs *fairScheduler) resize(concurrency int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.capacity = concurrency
+	fs.dispatch()
+}
+
+// available 是否有空闲名额
+func (fs *fairScheduler) available() bool { return fs.capacity <= 0 || fs.running < fs.capacity }
+
+// dispatch 将空闲名额分配给等待者
+func (fs *fairScheduler) dispatch() {
+	for fs.available() && len(fs.waiters) > 0 {
+		var best string
+		var total, found = 0, false
+		for rk := range fs.waiters {
+			w := fs.fd.weight(rk)
+			fs.current[rk] += w
+			total += w
+			if !found || fs.current[rk] > fs.current[best] {
+				best, found = rk, true
+			}
+		}
+		fs.current[best] -= total
+		ch := fs.waiters[best][0]
+		if fs.waiters[best] = fs.waiters[best][1:]; len(fs.waiters[best]) == 0 {
+			delete(fs.waiters, best)
+			delete(fs.current, best)
+		}
+		fs.running++
+		close(ch)
+	}
+}
+
+// waiting 获取等待中的数量
+func (fs *fairScheduler) waiting() (n int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, chs := range fs.waiters {
+		n += len(chs)
+	}
+	return n
+}
+
+// routeKeyOf 从消息内容中获取路由键, 无法解析时为空
+func routeKeyOf(data []byte) string {
+	var msg struct {
+		RouteKey string `json:"k"`
+	}
+	_ = json.Unmarshal(data, &msg)
+	return msg.RouteKey
+}
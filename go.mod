@@ -1,8 +1,22 @@
 module github.com/easy-bus/bus
 
-go 1.12
+go 1.18
 
 require (
 	github.com/sony/sonyflake v1.1.0
 	github.com/stretchr/testify v1.8.4
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/net v0.26.0
+	google.golang.org/grpc v1.64.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.5.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
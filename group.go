@@ -0,0 +1,120 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// GroupError 处理器组汇总的错误
+type GroupError []error
+
+func (ge GroupError) Error() string {
+	msgs := make([]string, len(ge))
+	for i, err := range ge {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Group 处理器组
+// 统一准备, 启动及关闭多个发送器与处理器
+type Group struct {
+	mu       sync.Mutex
+	senders  []*Sender
+	handlers []*Handler
+	cancel   context.CancelFunc
+}
+
+// AddSender 注册发送器
+func (g *Group) AddSender(senders ...*Sender) *Group {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.senders = append(g.senders, senders...)
+	return g
+}
+
+// AddHandler 注册处理器
+func (g *Group) AddHandler(handlers ...*Handler) *Group {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.handlers = append(g.handlers, handlers...)
+	return g
+}
+
+// Start 准备并启动全部成员
+// 任一成员准备失败时均不启动, 并返回汇总的错误
+// 未指定上下文的成员将绑定到组的上下文, 以便统一关闭
+func (g *Group) Start(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cancel != nil {
+		return fmt.Errorf("group has already started")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	var errs GroupError
+	for _, s := range g.senders {
+		if s.Context == nil {
+			s.Context = ctx
+		}
+		if s.TxOptions != nil && s.TxOptions.Context == nil {
+			s.TxOptions.Context = ctx
+		}
+		if err := prepareSafely(func() { s.Prepare() }); err != nil {
+			errs = append(errs, fmt.Errorf("sender [%s] %v", s.Topic, err))
+		}
+	}
+	for _, h := range g.handlers {
+		if h.Context == nil {
+			h.Context = ctx
+		}
+		if err := prepareSafely(func() { h.Prepare() }); err != nil {
+			errs = append(errs, fmt.Errorf("handler [%s] %v", h.Queue, err))
+		}
+	}
+	if len(errs) > 0 {
+		cancel()
+		return errs
+	}
+	for _, h := range g.handlers {
+		go h.Run()
+	}
+	g.cancel = cancel
+	return nil
+}
+
+// Shutdown 关闭全部成员并等待退出
+// 超出上下文期限时返回上下文的错误
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cancel == nil {
+		return nil
+	}
+	g.cancel()
+	g.cancel = nil
+	done := make(chan struct{})
+	goroutine(func() {
+		for _, s := range g.senders {
+			s.Wait()
+		}
+		for _, h := range g.handlers {
+			h.Wait()
+		}
+		close(done)
+	})
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// prepareSafely 执行准备函数并将异常转换为错误
+func prepareSafely(prepare func()) (err error) {
+	defer handlePanic(func(i interface{}) { err = fmt.Errorf("%v", i) })
+	prepare()
+	return nil
+}
@@ -0,0 +1,159 @@
+package grpcdriver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/easy-bus/bus/ack"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// 默认配置
+const (
+	DefaultTimeout       = 10 * time.Second
+	DefaultRetryInterval = time.Second
+)
+
+// Driver gRPC远程驱动
+// 通过Server代理访问后端驱动, 适用于无法直连中间件的多语言或边缘进程
+type Driver struct {
+	// Timeout 单次请求超时
+	Timeout time.Duration
+
+	// RetryInterval 接收流中断后重新建立的间隔
+	RetryInterval time.Duration
+
+	conn  grpc.ClientConnInterface
+	close func() error
+}
+
+// New 使用已建立的连接创建驱动
+func New(conn grpc.ClientConnInterface) *Driver {
+	return &Driver{
+		Timeout:       DefaultTimeout,
+		RetryInterval: DefaultRetryInterval,
+		conn:          conn,
+		close:         func() error { return nil },
+	}
+}
+
+// Dial 连接代理服务并创建驱动
+// 未指定opts时使用不加密的连接
+func Dial(target string, opts ...grpc.DialOption) (*Driver, error) {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("grpcdriver: dial [%s] failed, %v", target, err)
+	}
+	d := New(conn)
+	d.close = conn.Close
+	return d, nil
+}
+
+// Close 关闭由Dial建立的连接
+func (d *Driver) Close() error { return d.close() }
+
+func (d *Driver) CreateQueue(name string, delay time.Duration) error {
+	return d.invoke("CreateQueue", &queueRequest{Name: name, DelayMs: millis(delay)})
+}
+
+func (d *Driver) CreateTopic(name string) error {
+	return d.invoke("CreateTopic", &topicRequest{Name: name})
+}
+
+func (d *Driver) Subscribe(topic, queue, routeKey string) error {
+	return d.invoke("Subscribe", &subscribeRequest{Topic: topic, Queue: queue, RouteKey: routeKey})
+}
+
+func (d *Driver) UnSubscribe(topic, queue, routeKey string) error {
+	return d.invoke("UnSubscribe", &subscribeRequest{Topic: topic, Queue: queue, RouteKey: routeKey})
+}
+
+func (d *Driver) SendToQueue(queue string, content []byte, delay time.Duration) error {
+	return d.invoke("Send", &sendRequest{Queue: queue, Content: content, DelayMs: millis(delay)})
+}
+
+func (d *Driver) SendToTopic(topic string, content []byte, routeKey string) error {
+	return d.invoke("Send", &sendRequest{Topic: topic, Content: content, RouteKey: routeKey})
+}
+
+// ReceiveMessage 建立接收流获取消息, 每条消息在独立的协程中处理
+// 接收流中断时上报错误, 并在RetryInterval后重新建立
+func (d *Driver) ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) ack.Result) {
+	for {
+		err := d.receive(ctx, queue, errChan, handler)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			select {
+			case errChan <- fmt.Errorf("grpcdriver: receive [%s] interrupted, %v", queue, err):
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.RetryInterval):
+		}
+	}
+}
+
+// receive 建立一次接收流, 直至流结束
+func (d *Driver) receive(ctx context.Context, queue string, errChan chan error, handler func([]byte) ack.Result) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := d.conn.NewStream(ctx, &receiveStream, method("Receive"), grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&receiveFrame{Queue: queue}); err != nil {
+		return err
+	}
+	var sendMu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		var dl delivery
+		if err := stream.RecvMsg(&dl); err != nil {
+			return err
+		}
+		if dl.Error != "" {
+			select {
+			case errChan <- errors.New(dl.Error):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := handler(dl.Content)
+			frame := &receiveFrame{ID: dl.ID, Action: result.Action.String(), DelayMs: millis(result.Delay)}
+			if result.Reason != nil {
+				frame.Reason = result.Reason.Error()
+			}
+			sendMu.Lock()
+			defer sendMu.Unlock()
+			_ = stream.SendMsg(frame) // 确认失败时由服务端重新投递
+		}()
+	}
+}
+
+// invoke 发起一元请求
+func (d *Driver) invoke(name string, req interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+	err := d.conn.Invoke(ctx, method(name), req, &empty{}, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return fmt.Errorf("grpcdriver: %s failed, %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package grpcdriver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/easy-bus/bus/ack"
+	"github.com/easy-bus/bus/memdriver"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func serve(t *testing.T) *Driver {
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	(&Server{Backend: &memdriver.Driver{}}).Register(gs)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+	d, err := Dial("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.Nil(t, err)
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestDriver(t *testing.T) {
+	d := serve(t)
+	assert.Nil(t, d.CreateTopic("topic"))
+	assert.Nil(t, d.CreateQueue("queue", 0))
+	assert.NotNil(t, d.Subscribe("topic", "missing", "key"))
+	assert.Nil(t, d.Subscribe("topic", "queue", "key"))
+	assert.Nil(t, d.SendToTopic("topic", []byte("a"), "key"))
+	assert.Nil(t, d.SendToTopic("topic", []byte("b"), "other"))
+	assert.Nil(t, d.SendToQueue("queue", []byte("c"), 0))
+	assert.NotNil(t, d.SendToQueue("missing", nil, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan string, 3)
+	var failed bool
+	go d.ReceiveMessage(ctx, "queue", make(chan error), func(data []byte) ack.Result {
+		received <- string(data)
+		if string(data) == "a" && !failed {
+			failed = true // 首次处理失败重新投递
+			return ack.NackRetry(0, nil)
+		}
+		return ack.Ack()
+	})
+	var rows []string
+	for i := 0; i < 3; i++ {
+		select {
+		case row := <-received:
+			rows = append(rows, row)
+		case <-time.After(time.Second):
+			t.Fatal("receive timeout")
+		}
+	}
+	assert.ElementsMatch(t, []string{"a", "a", "c"}, rows)
+}
+
+func TestReceiveError(t *testing.T) {
+	d := serve(t)
+	d.RetryInterval = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChan := make(chan error)
+	go d.ReceiveMessage(ctx, "missing", errChan, func([]byte) ack.Result { return ack.Ack() })
+	select {
+	case err := <-errChan:
+		assert.Contains(t, err.Error(), "missing")
+	case <-time.After(time.Second):
+		t.Fatal("error not reported")
+	}
+}
+
+func TestWireFormat(t *testing.T) {
+	data, err := jsonCodec{}.Marshal(&sendRequest{Queue: "queue", Content: []byte("a"), DelayMs: millis(1500 * time.Millisecond)})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"queue":"queue","content":"YQ==","delay_ms":1500}`, string(data))
+	var frame receiveFrame
+	assert.Nil(t, jsonCodec{}.Unmarshal([]byte(`{"id":1,"action":"retry","delay_ms":2000,"reason":"busy"}`), &frame))
+	result := frame.result()
+	assert.Equal(t, ack.ActionRetry, result.Action)
+	assert.Equal(t, 2*time.Second, result.Delay)
+	assert.EqualError(t, result.Reason, "busy")
+	frame = receiveFrame{ID: 2, Action: "dead-letter"}
+	assert.Equal(t, ack.ActionDeadLetter, frame.result().Action)
+	frame = receiveFrame{ID: 3, Action: "unknown"}
+	assert.Equal(t, ack.ActionRetry, frame.result().Action)
+	assert.NotNil(t, frame.result().Reason)
+}
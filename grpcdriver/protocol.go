@@ -0,0 +1,175 @@
+// Package grpcdriver 基于gRPC的代理驱动
+//
+// 服务名称为 easybus.Broker, 消息体以JSON编码, content-type 为 application/grpc+easybus
+// 其他语言的客户端无需.proto及生成代码, 按以下约定收发JSON即可接入:
+//
+//	一元方法, 响应均为 {}
+//	CreateQueue  {"name": string, "delay_ms": int64}
+//	CreateTopic  {"name": string}
+//	Subscribe    {"topic": string, "queue": string, "route_key": string}
+//	UnSubscribe  同Subscribe
+//	Send         {"queue": string, "topic": string, "route_key": string, "content": base64, "delay_ms": int64}
+//	             指定queue时发送至队列, 否则发送至topic
+//
+//	双向流方法 Receive
+//	客户端首帧 {"queue": string} 开始接收队列消息
+//	服务端帧   {"id": uint64, "content": base64} 待确认的消息, 或 {"error": string} 队列级错误
+//	客户端帧   {"id": uint64, "action": "ack"|"retry"|"dead-letter", "delay_ms": int64, "reason": string}
+//	           确认一条消息, 无法识别的action按retry处理
+//
+// 时长字段均为毫秒, 字节内容按JSON惯例以标准base64编码, 缺省字段取零值
+package grpcdriver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/easy-bus/bus/ack"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// codecName 编码名称, 请求以 application/grpc+easybus 传输
+const codecName = "easybus"
+
+// serviceName 代理服务名称
+const serviceName = "easybus.Broker"
+
+func init() { encoding.RegisterCodec(jsonCodec{}) }
+
+// jsonCodec JSON编码
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (jsonCodec) Name() string { return codecName }
+
+// empty 空响应
+type empty struct{}
+
+// queueRequest 创建队列请求
+type queueRequest struct {
+	Name    string `json:"name"`
+	DelayMs int64  `json:"delay_ms,omitempty"`
+}
+
+// topicRequest 创建主题请求
+type topicRequest struct {
+	Name string `json:"name"`
+}
+
+// subscribeRequest 订阅及取消订阅请求
+type subscribeRequest struct {
+	Topic    string `json:"topic"`
+	Queue    string `json:"queue"`
+	RouteKey string `json:"route_key,omitempty"`
+}
+
+// sendRequest 发送消息请求, 指定Queue时发送至队列, 否则发送至Topic
+type sendRequest struct {
+	Queue    string `json:"queue,omitempty"`
+	Topic    string `json:"topic,omitempty"`
+	RouteKey string `json:"route_key,omitempty"`
+	Content  []byte `json:"content"`
+	DelayMs  int64  `json:"delay_ms,omitempty"`
+}
+
+// receiveFrame 接收流的客户端帧
+// 首帧指定Queue开始接收, 此后每帧确认一条投递
+type receiveFrame struct {
+	Queue   string `json:"queue,omitempty"`
+	ID      uint64 `json:"id,omitempty"`
+	Action  string `json:"action,omitempty"`
+	DelayMs int64  `json:"delay_ms,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// delivery 接收流的服务端帧
+// Error 不为空时表示队列级错误, 否则为一条待确认的消息
+type delivery struct {
+	ID      uint64 `json:"id,omitempty"`
+	Content []byte `json:"content,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// millis 时长转换为毫秒
+func millis(d time.Duration) int64 { return int64(d / time.Millisecond) }
+
+// duration 毫秒转换为时长
+func duration(ms int64) time.Duration { return time.Duration(ms) * time.Millisecond }
+
+// parseAction 解析确认动作
+func parseAction(s string) (ack.Action, error) {
+	for _, a := range []ack.Action{ack.ActionAck, ack.ActionRetry, ack.ActionDeadLetter} {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return ack.ActionRetry, fmt.Errorf("grpcdriver: unknown action [%s]", s)
+}
+
+// receiveStream 接收流描述
+var receiveStream = grpc.StreamDesc{
+	StreamName:    "Receive",
+	Handler:       func(srv interface{}, stream grpc.ServerStream) error { return srv.(*Server).receive(stream) },
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// serviceDesc 代理服务描述
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unary("CreateQueue", func(s *Server, req *queueRequest) error {
+			return s.Backend.CreateQueue(req.Name, duration(req.DelayMs))
+		}),
+		unary("CreateTopic", func(s *Server, req *topicRequest) error {
+			return s.Backend.CreateTopic(req.Name)
+		}),
+		unary("Subscribe", func(s *Server, req *subscribeRequest) error {
+			return s.Backend.Subscribe(req.Topic, req.Queue, req.RouteKey)
+		}),
+		unary("UnSubscribe", func(s *Server, req *subscribeRequest) error {
+			return s.Backend.UnSubscribe(req.Topic, req.Queue, req.RouteKey)
+		}),
+		unary("Send", func(s *Server, req *sendRequest) error {
+			if req.Queue != "" {
+				return s.Backend.SendToQueue(req.Queue, req.Content, duration(req.DelayMs))
+			}
+			return s.Backend.SendToTopic(req.Topic, req.Content, req.RouteKey)
+		}),
+	},
+	Streams: []grpc.StreamDesc{receiveStream},
+}
+
+// unary 构造一元方法
+func unary[T any](name string, call func(s *Server, req *T) error) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := new(T)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(_ context.Context, req interface{}) (interface{}, error) {
+				if err := call(srv.(*Server), req.(*T)); err != nil {
+					return nil, err
+				}
+				return &empty{}, nil
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: method(name)}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+// method 方法的完整名称
+func method(name string) string { return "/" + serviceName + "/" + name }
@@ -0,0 +1,112 @@
+package grpcdriver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/easy-bus/bus"
+	"github.com/easy-bus/bus/ack"
+	"google.golang.org/grpc"
+)
+
+var errStreamClosed = errors.New("grpcdriver: stream closed")
+
+// Server gRPC代理服务
+// 将远程客户端的请求转发至后端驱动, 使无法直连中间件的进程经由gRPC接入
+type Server struct {
+	// Backend 后端驱动
+	Backend bus.DriverInterface
+
+	seq uint64
+}
+
+// Register 注册至gRPC服务
+func (s *Server) Register(gs *grpc.Server) { gs.RegisterService(&serviceDesc, s) }
+
+// receive 处理接收流
+// 消息投递给客户端后等待其确认, 连接中断时未确认的消息将重新投递
+func (s *Server) receive(stream grpc.ServerStream) error {
+	var first receiveFrame
+	if err := stream.RecvMsg(&first); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	var sendMu, pendingMu sync.Mutex
+	var closed bool
+	pending := make(map[uint64]chan ack.Result)
+	send := func(d *delivery) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		if closed {
+			return errStreamClosed
+		}
+		return stream.SendMsg(d)
+	}
+	defer func() {
+		// 流结束后不可再发送, 后端仍在处理的消息将重新投递
+		sendMu.Lock()
+		closed = true
+		sendMu.Unlock()
+	}()
+	go func() {
+		defer cancel()
+		for {
+			var frame receiveFrame
+			if err := stream.RecvMsg(&frame); err != nil {
+				return
+			}
+			pendingMu.Lock()
+			ch, ok := pending[frame.ID]
+			delete(pending, frame.ID)
+			pendingMu.Unlock()
+			if ok {
+				ch <- frame.result()
+			}
+		}
+	}()
+	errChan, errDone := make(chan error), make(chan struct{})
+	go func() {
+		defer close(errDone)
+		for err := range errChan {
+			_ = send(&delivery{Error: err.Error()})
+		}
+	}()
+	s.Backend.ReceiveMessage(ctx, first.Queue, errChan, func(data []byte) bus.Result {
+		id := atomic.AddUint64(&s.seq, 1)
+		ch := make(chan ack.Result, 1)
+		pendingMu.Lock()
+		pending[id] = ch
+		pendingMu.Unlock()
+		if err := send(&delivery{ID: id, Content: data}); err != nil {
+			cancel()
+		}
+		select {
+		case result := <-ch:
+			return result
+		case <-ctx.Done():
+			pendingMu.Lock()
+			delete(pending, id)
+			pendingMu.Unlock()
+			return ack.NackRetry(0, ctx.Err())
+		}
+	})
+	close(errChan)
+	<-errDone
+	return nil
+}
+
+// result 转换为处理结果, 无法识别的动作按重新投递处理
+func (f *receiveFrame) result() ack.Result {
+	var reason error
+	if f.Reason != "" {
+		reason = errors.New(f.Reason)
+	}
+	action, err := parseAction(f.Action)
+	if err != nil && reason == nil {
+		reason = err
+	}
+	return ack.Result{Action: action, Delay: duration(f.DelayMs), Reason: reason}
+}
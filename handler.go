@@ -2,9 +2,12 @@ package bus
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/easy-bus/bus/clock"
 )
 
 // Subscribe 处理器订阅
@@ -40,15 +43,61 @@ type Handler struct {
 	// 无法处理的消息最终流转到这里
 	DLStorage DLStorageInterface
 
+	// DLNotifier 死信通知
+	// 消息进入死信后异步通知, 为空则不通知
+	DLNotifier DLNotifier
+
+	// DLQueue 原生死信队列名称
+	// 驱动支持原生死信队列时, 死信交由驱动转入该队列而不再写入DLStorage
+	// 驱动不支持时仍使用DLStorage
+	DLQueue string
+
+	// BlobStorage 大消息内容存储
+	// 携带引用的消息在处理前从该存储取回内容
+	BlobStorage BlobStorageInterface
+
+	// RetainStorage 保留消息存储
+	// 订阅主题时立即获取该路由键的保留消息, 需与发送器使用相同的存储
+	RetainStorage RetainStorageInterface
+
+	// Version 当前处理的消息版本
+	Version int
+
+	// Upcasters 消息版本升级注册表
+	// key为源版本号, 旧版本消息逐级升级至Version后再处理
+	Upcasters map[int]Upcaster
+
+	// Validator 消息校验
+	// 校验不通过的消息直接进入死信存储
+	Validator SchemaValidatorInterface
+
+	// Archive 消息归档
+	// 每条消息的处理结果都将被存档
+	Archive ArchiveInterface
+
 	// Idempotent 幂等判断实现
 	// 防止消息被重复处理保证数据一致性
 	// 若幂等性判断自身异常则可能导致判断失效
 	// 因此再严格一致的场景下配置EnsureFn进行二次确认
 	Idempotent IdempotentInterface
 
+	// IdempotentTTL 幂等操作权的有效时长
+	// 处理进程崩溃时, 操作权超时后消息可被重新处理, 若 <= 0 则不过期
+	IdempotentTTL time.Duration
+
+	// Propagators 上下文传播
+	// 从消息头恢复发送方的上下文值, 通过msg.Context()获取
+	Propagators []Propagator
+
+	// FilterFunc 消息预过滤
+	// 在解码及幂等判断之前执行, 返回false的消息将被直接确认并丢弃
+	// 适用于高吞吐队列低成本地跳过无关消息
+	FilterFunc func(raw []byte) (keep bool)
+
 	// HandleFunc 消息处理回调函数
 	// 若返回值为true则表示处理成功, 将删除该消息
 	// 若返回值为false则表示处理失败, 消息将延迟重试
+	// 可通过msg.RetryAfter指定该消息的重试延迟
 	HandleFunc func(msg *Message) (done bool)
 
 	// EnsureFunc 幂等性的二次确认
@@ -62,14 +111,71 @@ type Handler struct {
 	// 返回值为重试间隔, 若 < 0 则代表不进行重试
 	RetryDelay func(attempts int) time.Duration
 
+	// Heartbeat 处理心跳间隔
+	// 若 > 0 且驱动支持, 处理期间每隔该时长将消息处理期限延长两个间隔
+	Heartbeat time.Duration
+
+	// BatchAck 批量确认策略
+	// 若驱动支持则处理结果按策略批量确认, 此时Heartbeat不生效
+	// 驱动不支持时仍逐条确认
+	BatchAck *BatchAck
+
+	// Concurrency 消息并发处理数量
+	// 若 <= 0 则不做限制, 并发度由驱动决定
+	Concurrency int
+
+	// RateLimit 每秒最多处理的消息数量
+	// 若 <= 0 则不做限制
+	RateLimit int
+
+	// FairDispatch 按路由键加权公平调度
+	// 并发处理名额按路由键的权重轮转分配, 需配合Concurrency使用
+	FairDispatch *FairDispatch
+
+	// SlowThreshold 慢处理阈值
+	// 若 > 0 则HandleFunc耗时超出该阈值的消息将被记录日志并计入统计
+	SlowThreshold time.Duration
+
+	// SlowStack 慢处理时是否采样堆栈
+	// 处理耗时达到阈值时获取处理协程的堆栈, 随日志一同输出
+	SlowStack bool
+
+	// ErrorHandler 驱动错误处理
+	// 决定队列级错误发生后恢复, 忽略或停止处理器, 默认尝试恢复
+	ErrorHandler ErrorHandlerInterface
+
+	// Reconnect 驱动重连策略
+	// 错误处理决定恢复时按该策略重新初始化驱动, 默认不限次数地指数退避重连
+	Reconnect *Reconnect
+
+	// DLRetrier 死信重试器
+	// 默认每分钟重试一次, 串行处理且不限速
+	DLRetrier *DLRetrier
+
+	// DLJanitor 死信清理器
+	// 定时清理超出保留时长的死信, 为空则不清理
+	DLJanitor *DLJanitor
+
+	// Clock 时钟, 默认为系统时钟
+	// 用于消息过期及延迟投递的判断, 心跳及处理速率限制的等待
+	Clock Clock
+
 	// ready 是否就绪
 	ready bool
 
+	// 运行时可调整的处理策略
+	policy   sync.RWMutex
+	slots    *fairScheduler
+	throttle *rateLimiter
+
 	// 退出信号
 	quit chan struct{}
 
 	// 是否运行
 	running int32
+
+	// 处理统计
+	stats handlerStats
 }
 
 // Prepare 准备就绪
@@ -84,12 +190,24 @@ func (h *Handler) Prepare() *Handler {
 		if h.HandleFunc == nil {
 			throw("the handler [%s] missing handle function", h.Queue)
 		}
+		if h.FairDispatch != nil && h.Concurrency <= 0 {
+			throw("the handler [%s] fair dispatch requires concurrency", h.Queue)
+		}
 		if h.Logger == nil {
 			h.Logger = stderrLogger{}
 		}
 		if h.DLStorage == nil {
 			h.DLStorage = nullDLStorage{}
 		}
+		if _, ok := h.DLStorage.(ExpiredDLStorageInterface); h.DLJanitor != nil && !ok {
+			throw("the handler [%s] dl janitor requires the dl storage to support expired fetch", h.Queue)
+		}
+		if h.Validator == nil {
+			h.Validator = nullSchemaValidator{}
+		}
+		if h.Archive == nil {
+			h.Archive = nullArchive{}
+		}
 		if h.Idempotent == nil {
 			h.Idempotent = nullIdempotent{}
 		}
@@ -99,7 +217,20 @@ func (h *Handler) Prepare() *Handler {
 		if h.RetryDelay == nil {
 			h.RetryDelay = func(int) time.Duration { return -1 }
 		}
+		if h.ErrorHandler == nil {
+			h.ErrorHandler = ErrorHandlerFunc(func(string, error) ErrorDecision { return ErrorRecover })
+		}
+		if h.Reconnect == nil {
+			h.Reconnect = &Reconnect{}
+		}
+		if h.DLRetrier == nil {
+			h.DLRetrier = &DLRetrier{}
+		}
+		if h.Context == nil {
+			h.Context = context.Background()
+		}
 		h.initDriver()
+		h.fetchRetained()
 		h.ready = true
 		h.quit = make(chan struct{})
 	})
@@ -114,26 +245,60 @@ func (h *Handler) Run() {
 	if !atomic.CompareAndSwapInt32(&h.running, 0, 1) {
 		return // 已在运行中
 	}
+	ctx, cancel := context.WithCancel(h.Context)
 	errChan := make(chan error)
 	goroutine(func() {
 		for err := range errChan {
-			h.initDriver() // 队列级错误尝试恢复
-			h.Logger.Errorf("handler [%s] error, %v", h.Queue, err)
-		}
-	})
-	ticker := time.NewTicker(time.Minute)
-	goroutine(func() {
-		for range ticker.C {
-			h.handleRetry()
+			h.handleError(ctx, err, cancel)
 		}
 	})
-	h.Driver.ReceiveMessage(h.Context, h.Queue, errChan, h.handleMsg)
+	var wg sync.WaitGroup
+	h.background(&wg, func() { h.DLRetrier.run(ctx, h) })
+	if h.DLJanitor != nil {
+		h.background(&wg, func() { h.DLJanitor.run(ctx, h) })
+	}
+	handle := h.limit(ctx, h.handleMsgExtend)
+	caps := Capabilities(h.Driver)
+	if bd, ok := h.Driver.(BatchAckDriverInterface); ok && h.BatchAck != nil && caps.Has(CapabilityBatchAck) {
+		bd.ReceiveMessageBatchAck(ctx, h.Queue, errChan, *h.BatchAck, func(data []byte) Result { return handle(data, nil) })
+	} else if ed, ok := h.Driver.(ExtendableDriverInterface); ok && caps.Has(CapabilityExtend) {
+		ed.ReceiveMessageExtend(ctx, h.Queue, errChan, func(data []byte, extend func(time.Duration) error) Result {
+			return handle(data, extend)
+		})
+	} else {
+		h.Driver.ReceiveMessage(ctx, h.Queue, errChan, func(data []byte) Result { return handle(data, nil) })
+	}
 	close(errChan) // 关闭错误通道, 退出错误处理协程
-	ticker.Stop()  // 关闭重试定时器, 退出重试处理协程
+	cancel()       // 取消上下文, 退出死信重试及清理协程
+	wg.Wait()
 	atomic.StoreInt32(&h.running, 0)
 	h.quit <- struct{}{}
 }
 
+// handleError 处理驱动的队列级错误
+func (h *Handler) handleError(ctx context.Context, err error, cancel context.CancelFunc) {
+	h.Logger.Errorf("handler [%s] error, %v", h.Queue, err)
+	switch h.ErrorHandler.OnDriverError(h.Queue, err) {
+	case ErrorRecover:
+		// 队列级错误按重连策略尝试恢复, 仍无法恢复时停止处理器
+		if err := h.Reconnect.run(ctx, h.Queue, h.setupDriver); err != nil && ctx.Err() == nil {
+			h.Logger.Errorf("handler [%s] reconnect failed, %v", h.Queue, err)
+			cancel()
+		}
+	case ErrorStop:
+		cancel() // 停止处理器
+	}
+}
+
+// background 启动后台协程
+func (h *Handler) background(wg *sync.WaitGroup, fn func()) {
+	wg.Add(1)
+	goroutine(func() {
+		defer wg.Done()
+		fn()
+	})
+}
+
 // RunCtx 启动处理器
 func (h *Handler) RunCtx(ctx context.Context) {
 	h.Context = ctx
@@ -148,72 +313,333 @@ func (h *Handler) Wait() { <-h.quit }
 // 屏蔽复杂度, 确保消息高效无误的流转
 // 若返回值为true则表示处理成功, 将删除该消息
 // 若返回值为false则表示处理失败, 消息将延迟重试
-func (h *Handler) handleMsg(data []byte) (done bool) {
+func (h *Handler) handleMsg(data []byte) (done bool) { return h.handleMsgExtend(data, nil).Acked() }
+
+// handleMsgExtend 处理消息, extend 为驱动提供的处理期限延长
+// 返回值为交由驱动确认的处理结果
+func (h *Handler) handleMsgExtend(data []byte, extend Extender) Result {
+	result, _ := h.process(data, extend, false)
+	return result
+}
+
+// process 处理消息, 返回交由驱动确认的处理结果及处理结论
+// redrive 为死信重试, 处理失败时保留原死信, 不再延迟重试或重新转入死信
+func (h *Handler) process(data []byte, extend Extender, redrive bool) (result Result, outcome string) {
+	var start = time.Now()
+	var cause = ""
+	var msg, decoded = Message{}, false
+	outcome = OutcomeFailed
+	deadLetter := func(reason error) Result {
+		if redrive {
+			return Ack() // 保留原死信
+		}
+		return h.deadLetter(&msg, data, decoded, reason)
+	}
+	defer h.archive(data, start, &outcome, &cause)
 	defer handlePanic(func(i interface{}) {
-		done = h.DLStorage.Store(h.Queue, data) == nil
+		result = deadLetter(fmt.Errorf("panic: %v", i))
+		outcome, cause = OutcomeDead, fmt.Sprint(i)
 		str := "handler [%s] panic: %v, data: %s, call stack: \n%s"
 		h.Logger.Errorf(str, h.Queue, i, string(data), stackTrace(0))
 	})
-	var msg Message
+	filterFunc, retryDelay := h.policies()
+	if filterFunc != nil && !filterFunc(data) {
+		outcome = OutcomeFiltered
+		return Ack(), outcome
+	}
 	decode(data, &msg)
+	decoded = true
+	now := clock.Or(h.Clock).Now().UnixNano() / int64(time.Millisecond)
+	if msg.ExpireAt > 0 && now >= msg.ExpireAt {
+		outcome = OutcomeExpired
+		return Ack(), outcome // 过期丢弃
+	}
+	if msg.DeliverAt > now {
+		// 未到投递时间, 由驱动延迟重新投递
+		outcome = OutcomeDeferred
+		return NackRetry(time.Duration(msg.DeliverAt-now)*time.Millisecond, nil), outcome
+	}
+	msg.hop(h.Queue, start) // 记录流转轨迹
+	msg.extender = extend
+	if len(h.Propagators) > 0 {
+		msg.ctx = extract(h.Context, &msg, h.Propagators)
+	}
+	if extend != nil && h.Heartbeat > 0 {
+		defer h.heartbeat(extend)()
+	}
+	if err := h.claim(&msg); err != nil {
+		cause = err.Error()
+		h.Logger.Errorf("handler [%s] claim failed, %v", h.Queue, err)
+		return NackRetry(nackDelay(retryDelay, msg.Retried), err), outcome // 存储异常由驱动延迟重新投递
+	}
+	if err := h.upcast(&msg); err != nil {
+		outcome, cause = OutcomeDead, err.Error()
+		h.Logger.Errorf("handler [%s] upcast failed, %v, data: %s", h.Queue, err, string(data))
+		return deadLetter(err), outcome
+	}
+	if err := h.Validator.Validate(msg.Payload); err != nil {
+		outcome, cause = OutcomeDead, err.Error()
+		h.Logger.Errorf("handler [%s] validate failed, %v, data: %s", h.Queue, err, string(data))
+		return deadLetter(err), outcome
+	}
 	key := h.Queue + "." + msg.BizUID
-	allow, err := h.Idempotent.Acquire(key)
+	allow, err := h.Idempotent.Acquire(key, h.IdempotentTTL)
 	if err != nil {
 		allow = false // 置为false进行二次确认
-		h.Logger.Errorf("handler [%s] idempotent acquired failed, %v", err)
+		h.Logger.Errorf("handler [%s] idempotent acquired failed, %v", h.Queue, err)
 	}
 	if !allow && !h.EnsureFunc(&msg) {
-		return true // 二次确认
-	} else if h.HandleFunc(&msg) {
-		return true // 处理成功
+		outcome = OutcomeSkipped
+		return Ack(), outcome // 二次确认
+	} else if h.handle(&msg) {
+		outcome = OutcomeDone
+		// 处理成功, 标记已完成
+		if err := h.Idempotent.Done(key); err != nil {
+			h.Logger.Errorf("handler [%s] idempotent done failed, %v", h.Queue, err)
+		}
+		return Ack(), outcome
 	}
 	// 处理失败, 释放控制权
 	if err := h.Idempotent.Release(key); err != nil {
-		h.Logger.Errorf("handler [%s] idempotent release failed, %v", err)
+		h.Logger.Errorf("handler [%s] idempotent release failed, %v", h.Queue, err)
+	}
+	if redrive {
+		return NackRetry(0, nil), outcome // 保留原死信
 	}
 	// 处理失败累加次数
 	msg.Retried += 1
-	// 计算多少秒后进行重试
-	if delay := h.RetryDelay(msg.Retried); delay < 0 {
-		if err := h.DLStorage.Store(h.Queue, data); err != nil {
-			h.Logger.Errorf("handler [%s] dl store failed, v", h.Queue, err)
-			return false // 死信储存失败
-		}
-	} else {
-		// 重新发布, 进入延迟重试
-		if err := h.Driver.SendToQueue(h.Queue, encode(msg), delay); err != nil {
-			h.Logger.Errorf("handler [%s] send to queue with delay [%d] failed, %v", h.Queue, delay, err)
-			return false // 重试发送失败
+	// 计算多少秒后进行重试, 优先使用消息指定的延迟
+	delay := retryDelay(msg.Retried)
+	if msg.retryDelay != nil {
+		delay = *msg.retryDelay
+	}
+	if delay < 0 {
+		reason := fmt.Errorf("retry attempts [%d] exhausted", msg.Retried)
+		if result = deadLetter(reason); result.Action == ActionRetry {
+			cause = result.Reason.Error()
+			return result, outcome // 死信储存失败
 		}
+		outcome = OutcomeDead
+		return result, outcome
+	}
+	// 重新发布, 进入延迟重试
+	if err := h.Driver.SendToQueue(h.Queue, h.trail(&msg, data, decoded), delay); err != nil {
+		cause = err.Error()
+		h.Logger.Errorf("handler [%s] send to queue with delay [%d] failed, %v", h.Queue, delay, err)
+		return NackRetry(0, err), outcome // 重试发送失败
 	}
-	return true
+	outcome = OutcomeRetry
+	return Ack(), outcome
 }
 
-// handleRetry 重试处理失败消息
-func (h *Handler) handleRetry() {
-	rows, err := h.DLStorage.Fetch(h.Queue)
-	if err != nil {
-		h.Logger.Errorf("retry fetch [%s] error, %v", h.Queue, err)
+// nackBackoff 无重试策略可用时, 交由驱动重新投递的等待时长
+const nackBackoff = time.Second
+
+// nackDelay 交由驱动重新投递的等待时长, 避免立即重投形成热循环
+// 优先使用重试策略的下一次延迟, 策略不再重试或立即重试时使用nackBackoff
+func nackDelay(retryDelay func(int) time.Duration, retried int) time.Duration {
+	if delay := retryDelay(retried + 1); delay > 0 {
+		return delay
+	}
+	return nackBackoff
+}
+
+// deadLetter 将消息转入死信
+// 使用原生死信队列时交由驱动处理, 否则存入死信存储
+// 存储失败时交由驱动立即重新投递
+func (h *Handler) deadLetter(msg *Message, data []byte, decoded bool, reason error) Result {
+	if h.nativeDL() {
+		h.notifyDL(msg, data, decoded, reason, true)
+		return NackDeadLetter(reason)
+	}
+	if err := h.DLStorage.Store(h.Queue, h.trail(msg, data, decoded)); err != nil {
+		h.Logger.Errorf("handler [%s] dl store failed, %v", h.Queue, err)
+		return NackRetry(0, err)
+	}
+	h.notifyDL(msg, data, decoded, reason, false)
+	return Ack()
+}
+
+// notifyDL 异步发送死信通知
+func (h *Handler) notifyDL(msg *Message, data []byte, decoded bool, reason error, native bool) {
+	if h.DLNotifier == nil {
 		return
 	}
-	for id, data := range rows {
-		if h.handleMsg(data) {
-			err = h.DLStorage.Remove(id)
-			if err != nil {
-				h.Logger.Errorf("retry delete [%s] error, %v", id, err)
+	event := &DLEvent{Queue: h.Queue, Native: native, At: time.Now(), Data: data}
+	if decoded {
+		event.BizUID, event.Retried = msg.BizUID, msg.Retried
+	}
+	if reason != nil {
+		event.Reason = reason.Error()
+	}
+	goroutine(func() {
+		if err := h.DLNotifier.Notify(event); err != nil {
+			h.Logger.Errorf("handler [%s] dl notify failed, %v", h.Queue, err)
+		}
+	})
+}
+
+// claim 取回存储在BlobStorage中的消息内容
+func (h *Handler) claim(msg *Message) error {
+	if msg.ClaimRef == "" {
+		return nil
+	}
+	if h.BlobStorage == nil {
+		return fmt.Errorf("missing blob storage for ref [%s]", msg.ClaimRef)
+	}
+	data, err := h.BlobStorage.Get(msg.ClaimRef)
+	if err != nil {
+		return fmt.Errorf("blob get [%s] failed, %v", msg.ClaimRef, err)
+	}
+	msg.Payload = data
+	return nil
+}
+
+// trail 获取携带流转轨迹的消息内容
+// 消息未能解码时返回原始内容
+func (h *Handler) trail(msg *Message, data []byte, decoded bool) []byte {
+	if !decoded {
+		return data
+	}
+	if n := len(msg.Hops); n > 0 && msg.Hops[n-1].Cost == 0 {
+		msg.Hops[n-1].Cost = time.Since(msg.Hops[n-1].At)
+	}
+	if msg.ClaimRef != "" {
+		out := *msg
+		out.Payload = nil // 内容仍在BlobStorage中, 仅保留引用
+		return encode(&out)
+	}
+	return encode(msg)
+}
+
+// heartbeat 定时延长消息处理期限, 返回停止函数
+func (h *Handler) heartbeat(extend Extender) (stop func()) {
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	goroutine(func() {
+		defer close(done)
+		timer := clock.Or(h.Clock).NewTimer(h.Heartbeat)
+		defer timer.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-timer.C():
+				if err := extend(2 * h.Heartbeat); err != nil {
+					h.Logger.Errorf("handler [%s] heartbeat failed, %v", h.Queue, err)
+				}
+				timer.Reset(h.Heartbeat)
 			}
 		}
+	})
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+// limit 限制消息处理速率及并发数量
+// 处理器退出时中断限速等待, 消息交由驱动重新投递
+func (h *Handler) limit(ctx context.Context, fn func([]byte, Extender) Result) func([]byte, Extender) Result {
+	h.policy.Lock()
+	slots := newFairScheduler(h.FairDispatch, h.Concurrency)
+	throttle := &rateLimiter{rate: h.RateLimit, clock: h.Clock}
+	h.slots, h.throttle = slots, throttle
+	h.policy.Unlock()
+	fair := h.FairDispatch != nil
+	return func(data []byte, extend Extender) Result {
+		if err := throttle.wait(ctx); err != nil {
+			return NackRetry(nackBackoff, err)
+		}
+		var routeKey string
+		if fair {
+			routeKey = routeKeyOf(data)
+		}
+		slots.acquire(routeKey)
+		defer slots.release()
+		return fn(data, extend)
+	}
+}
+
+// archive 归档消息及处理结果
+func (h *Handler) archive(data []byte, start time.Time, outcome, cause *string) {
+	record := &ArchiveRecord{
+		Source:     SourceHandler,
+		Name:       h.Queue,
+		Data:       data,
+		Outcome:    *outcome,
+		Error:      *cause,
+		StartedAt:  start,
+		FinishedAt: time.Now(),
+	}
+	if err := h.Archive.Store(record); err != nil {
+		h.Logger.Errorf("handler [%s] archive failed, %v", h.Queue, err)
 	}
 }
 
+// upcast 将消息内容升级至当前版本
+func (h *Handler) upcast(msg *Message) error {
+	if msg.Version > h.Version {
+		return fmt.Errorf("unsupported version [%d] > [%d]", msg.Version, h.Version)
+	}
+	for msg.Version < h.Version {
+		fn, ok := h.Upcasters[msg.Version]
+		if !ok {
+			return fmt.Errorf("missing upcaster for version [%d]", msg.Version)
+		}
+		payload, err := fn(msg.Payload)
+		if err != nil {
+			return fmt.Errorf("version [%d] upcast error, %v", msg.Version, err)
+		}
+		msg.Payload, msg.Version = payload, msg.Version+1
+	}
+	return nil
+}
+
+// nativeDL 是否使用原生死信队列
+func (h *Handler) nativeDL() bool {
+	return h.DLQueue != "" && Capabilities(h.Driver).Has(CapabilityDeadLetter)
+}
+
 // initDriver 驱动初始化
 func (h *Handler) initDriver() {
-	if err := h.Driver.CreateQueue(h.Queue, h.Delay); err != nil {
-		throw("then handler [%s] create queue failed, %v", h.Queue, err)
+	if err := h.setupDriver(); err != nil {
+		throw("%v", err)
+	}
+}
+
+// fetchRetained 将订阅路由键的保留消息投递至队列
+func (h *Handler) fetchRetained() {
+	if h.RetainStorage == nil || h.Subscribe.Topic == "" {
+		return
+	}
+	data, err := h.RetainStorage.Fetch(h.Subscribe.Topic, h.Subscribe.RouteKey)
+	if err != nil {
+		h.Logger.Errorf("handler [%s] fetch retained failed, %v", h.Queue, err)
+	} else if data != nil {
+		if err := h.Driver.SendToQueue(h.Queue, data, 0); err != nil {
+			h.Logger.Errorf("handler [%s] deliver retained failed, %v", h.Queue, err)
+		}
+	}
+}
+
+// setupDriver 创建队列并订阅主题
+func (h *Handler) setupDriver() error {
+	if h.nativeDL() {
+		if err := h.Driver.CreateQueue(h.DLQueue, 0); err != nil {
+			return fmt.Errorf("then handler [%s] create dl queue [%s] failed, %v", h.Queue, h.DLQueue, err)
+		}
+		err := h.Driver.(DeadLetterDriverInterface).CreateQueueWithDL(h.Queue, h.Delay, h.DLQueue)
+		if err != nil {
+			return fmt.Errorf("then handler [%s] create queue failed, %v", h.Queue, err)
+		}
+	} else if err := h.Driver.CreateQueue(h.Queue, h.Delay); err != nil {
+		return fmt.Errorf("then handler [%s] create queue failed, %v", h.Queue, err)
 	}
 	if h.Subscribe.Topic != "" {
 		if err := h.Driver.Subscribe(h.Subscribe.Topic, h.Queue, h.Subscribe.RouteKey); err != nil {
-			throw("then handler [%s] subscribe topic [%s] failed, %v", h.Queue, h.Subscribe.Topic, err)
+			return fmt.Errorf("then handler [%s] subscribe topic [%s] failed, %v", h.Queue, h.Subscribe.Topic, err)
 		}
 	}
+	return nil
 }
@@ -1,9 +1,157 @@
 package bus
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type HandlerOpt func(h *Handler)
 
 func HandlerDelay(delay time.Duration) HandlerOpt {
 	return func(h *Handler) { h.Delay = delay }
 }
+
+// WithContext 设置处理器上下文
+func WithContext(ctx context.Context) HandlerOpt {
+	return func(h *Handler) { h.Context = ctx }
+}
+
+// WithSubscribe 设置订阅主题及路由键
+func WithSubscribe(topic, routeKey string) HandlerOpt {
+	return func(h *Handler) { h.Subscribe = Subscribe{Topic: topic, RouteKey: routeKey} }
+}
+
+// WithDriver 设置驱动实例
+func WithDriver(driver DriverInterface) HandlerOpt {
+	return func(h *Handler) { h.Driver = driver }
+}
+
+// WithLogger 设置异常日志
+func WithLogger(logger LoggerInterface) HandlerOpt {
+	return func(h *Handler) { h.Logger = logger }
+}
+
+// WithDLStorage 设置死信存储
+func WithDLStorage(storage DLStorageInterface) HandlerOpt {
+	return func(h *Handler) { h.DLStorage = storage }
+}
+
+// WithIdempotent 设置幂等判断实现
+func WithIdempotent(idempotent IdempotentInterface) HandlerOpt {
+	return func(h *Handler) { h.Idempotent = idempotent }
+}
+
+// WithValidator 设置消息校验
+func WithValidator(validator SchemaValidatorInterface) HandlerOpt {
+	return func(h *Handler) { h.Validator = validator }
+}
+
+// WithArchive 设置消息归档
+func WithArchive(archive ArchiveInterface) HandlerOpt {
+	return func(h *Handler) { h.Archive = archive }
+}
+
+// WithVersion 设置当前处理的消息版本
+func WithVersion(version int) HandlerOpt {
+	return func(h *Handler) { h.Version = version }
+}
+
+// WithUpcaster 注册消息版本升级函数
+func WithUpcaster(from int, fn Upcaster) HandlerOpt {
+	return func(h *Handler) {
+		if h.Upcasters == nil {
+			h.Upcasters = make(map[int]Upcaster)
+		}
+		h.Upcasters[from] = fn
+	}
+}
+
+// WithEnsureFunc 设置幂等性的二次确认
+func WithEnsureFunc(fn func(msg *Message) (allow bool)) HandlerOpt {
+	return func(h *Handler) { h.EnsureFunc = fn }
+}
+
+// WithRetryDelay 设置重试延迟机制
+func WithRetryDelay(fn func(attempts int) time.Duration) HandlerOpt {
+	return func(h *Handler) { h.RetryDelay = fn }
+}
+
+// WithConcurrency 设置消息并发处理数量
+func WithConcurrency(n int) HandlerOpt {
+	return func(h *Handler) { h.Concurrency = n }
+}
+
+// WithBatchAck 设置批量确认策略
+func WithBatchAck(size int, interval time.Duration) HandlerOpt {
+	return func(h *Handler) { h.BatchAck = &BatchAck{Size: size, Interval: interval} }
+}
+
+// WithRateLimit 设置每秒最多处理的消息数量
+func WithRateLimit(n int) HandlerOpt {
+	return func(h *Handler) { h.RateLimit = n }
+}
+
+// WithFairDispatch 设置按路由键加权公平调度
+func WithFairDispatch(weights map[string]int) HandlerOpt {
+	return func(h *Handler) { h.FairDispatch = &FairDispatch{Weights: weights} }
+}
+
+// WithErrorHandler 设置驱动错误处理
+func WithErrorHandler(handler ErrorHandlerInterface) HandlerOpt {
+	return func(h *Handler) { h.ErrorHandler = handler }
+}
+
+// WithFilterFunc 设置消息预过滤
+func WithFilterFunc(fn func(raw []byte) bool) HandlerOpt {
+	return func(h *Handler) { h.FilterFunc = fn }
+}
+
+// WithSlowThreshold 设置慢处理阈值, stack 是否采样堆栈
+func WithSlowThreshold(threshold time.Duration, stack bool) HandlerOpt {
+	return func(h *Handler) { h.SlowThreshold, h.SlowStack = threshold, stack }
+}
+
+// WithHeartbeat 设置处理心跳间隔
+func WithHeartbeat(interval time.Duration) HandlerOpt {
+	return func(h *Handler) { h.Heartbeat = interval }
+}
+
+// WithDLQueue 设置原生死信队列
+func WithDLQueue(queue string) HandlerOpt {
+	return func(h *Handler) { h.DLQueue = queue }
+}
+
+// WithIdempotentTTL 设置幂等操作权的有效时长
+func WithIdempotentTTL(ttl time.Duration) HandlerOpt {
+	return func(h *Handler) { h.IdempotentTTL = ttl }
+}
+
+// WithReconnect 设置驱动重连策略
+func WithReconnect(r *Reconnect) HandlerOpt {
+	return func(h *Handler) { h.Reconnect = r }
+}
+
+// WithPropagators 设置上下文传播
+func WithPropagators(propagators ...Propagator) HandlerOpt {
+	return func(h *Handler) { h.Propagators = propagators }
+}
+
+// WithBlobStorage 设置大消息内容存储
+func WithBlobStorage(storage BlobStorageInterface) HandlerOpt {
+	return func(h *Handler) { h.BlobStorage = storage }
+}
+
+// WithDLNotifier 设置死信通知
+func WithDLNotifier(notifier DLNotifier) HandlerOpt {
+	return func(h *Handler) { h.DLNotifier = notifier }
+}
+
+// WithRetainStorage 设置保留消息存储
+func WithRetainStorage(storage RetainStorageInterface) HandlerOpt {
+	return func(h *Handler) { h.RetainStorage = storage }
+}
+
+// WithClock 设置时钟
+func WithClock(c Clock) HandlerOpt {
+	return func(h *Handler) { h.Clock = c }
+}
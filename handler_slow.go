@@ -0,0 +1,98 @@
+package bus
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// HandlerStats 处理器统计
+type HandlerStats struct {
+	// Handled HandleFunc的调用次数
+	Handled uint64
+
+	// Slow 耗时超出SlowThreshold的次数
+	Slow uint64
+
+	// MaxCost HandleFunc的最大耗时
+	MaxCost time.Duration
+}
+
+// handlerStats 处理统计计数
+type handlerStats struct {
+	handled uint64
+	slow    uint64
+	maxCost int64
+}
+
+// Stats 获取处理统计
+func (h *Handler) Stats() HandlerStats {
+	return HandlerStats{
+		Handled: atomic.LoadUint64(&h.stats.handled),
+		Slow:    atomic.LoadUint64(&h.stats.slow),
+		MaxCost: time.Duration(atomic.LoadInt64(&h.stats.maxCost)),
+	}
+}
+
+// handle 调用HandleFunc, 记录耗时超出SlowThreshold的慢处理
+func (h *Handler) handle(msg *Message) bool {
+	var sampled chan []byte
+	var timer *time.Timer
+	if h.SlowThreshold > 0 && h.SlowStack {
+		gid := goroutineID()
+		sampled = make(chan []byte, 1)
+		timer = time.AfterFunc(h.SlowThreshold, func() { sampled <- goroutineStack(gid) })
+		defer timer.Stop()
+	}
+	start := time.Now()
+	done := h.HandleFunc(msg)
+	cost := time.Since(start)
+	atomic.AddUint64(&h.stats.handled, 1)
+	for {
+		peak := atomic.LoadInt64(&h.stats.maxCost)
+		if int64(cost) <= peak || atomic.CompareAndSwapInt64(&h.stats.maxCost, peak, int64(cost)) {
+			break
+		}
+	}
+	if h.SlowThreshold <= 0 || cost < h.SlowThreshold {
+		return done
+	}
+	atomic.AddUint64(&h.stats.slow, 1)
+	if timer != nil && !timer.Stop() {
+		h.Logger.Errorf("handler [%s] slow handle [%s] cost %v, stack sample: \n%s", h.Queue, msg.BizUID, cost, <-sampled)
+	} else {
+		h.Logger.Errorf("handler [%s] slow handle [%s] cost %v", h.Queue, msg.BizUID, cost)
+	}
+	return done
+}
+
+// goroutineID 获取当前协程的标识
+func goroutineID() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		if _, err := strconv.ParseUint(string(buf[:i]), 10, 64); err == nil {
+			return string(buf[:i])
+		}
+	}
+	return ""
+}
+
+// goroutineStack 获取指定协程的堆栈
+func goroutineStack(id string) []byte {
+	if id == "" {
+		return nil
+	}
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	prefix := []byte("goroutine " + id + " [")
+	for _, block := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(block, prefix) {
+			return block
+		}
+	}
+	return nil
+}
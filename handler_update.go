@@ -0,0 +1,89 @@
+package bus
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/easy-bus/bus/clock"
+)
+
+// Update 运行时调整处理策略, 无需重启处理器
+// 仅RetryDelay, FilterFunc, Concurrency及RateLimit的调整生效, 其余配置将被忽略
+// 全部选项一次性生效, 处理中的消息继续使用调整前的策略
+func (h *Handler) Update(opts ...HandlerOpt) {
+	h.policy.Lock()
+	defer h.policy.Unlock()
+	tmp := &Handler{
+		RetryDelay:  h.RetryDelay,
+		FilterFunc:  h.FilterFunc,
+		Concurrency: h.Concurrency,
+		RateLimit:   h.RateLimit,
+	}
+	for _, opt := range opts {
+		opt(tmp)
+	}
+	if tmp.RetryDelay == nil {
+		tmp.RetryDelay = func(int) time.Duration { return -1 }
+	}
+	h.RetryDelay, h.FilterFunc = tmp.RetryDelay, tmp.FilterFunc
+	h.Concurrency, h.RateLimit = tmp.Concurrency, tmp.RateLimit
+	if h.slots != nil {
+		h.slots.resize(h.Concurrency)
+	}
+	if h.throttle != nil {
+		h.throttle.setRate(h.RateLimit)
+	}
+}
+
+// policies 获取当前的过滤及重试策略
+func (h *Handler) policies() (func([]byte) bool, func(int) time.Duration) {
+	h.policy.RLock()
+	defer h.policy.RUnlock()
+	return h.FilterFunc, h.RetryDelay
+}
+
+// rateLimiter 按固定间隔放行的速率限制
+type rateLimiter struct {
+	mu    sync.Mutex
+	rate  int
+	next  time.Time
+	clock Clock
+}
+
+// wait 等待至可处理的时间, 上下文取消时提前返回错误
+func (rl *rateLimiter) wait(ctx context.Context) error {
+	rl.mu.Lock()
+	if rl.rate <= 0 {
+		rl.mu.Unlock()
+		return nil
+	}
+	c := clock.Or(rl.clock)
+	now := c.Now()
+	if rl.next.Before(now) {
+		rl.next = now
+	}
+	at := rl.next
+	rl.next = at.Add(time.Second / time.Duration(rl.rate))
+	rl.mu.Unlock()
+	if !at.After(now) {
+		return nil
+	}
+	timer := c.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// setRate 调整速率, 若 <= 0 则不做限制
+func (rl *rateLimiter) setRate(rate int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.rate = rate; rate <= 0 {
+		rl.next = time.Time{}
+	}
+}
@@ -0,0 +1,123 @@
+package bus
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotentShards 默认分片数量
+const DefaultIdempotentShards = 64
+
+// sweepEvery 每个分片每执行该次数的获取操作清理一次过期记录
+const sweepEvery = 1024
+
+// ShardedIdempotent 分片的进程内幂等实现
+// 按key的哈希分散至多个分片, 避免高并发时竞争同一把锁
+// 过期的操作权及超出保留时长的完成记录将被自动清理
+type ShardedIdempotent struct {
+	once sync.Once
+
+	// Shards 分片数量, 默认为DefaultIdempotentShards
+	Shards int
+
+	// Retention 完成记录的保留时长, 若 <= 0 则永久保留
+	Retention time.Duration
+
+	shards []*idempotentShard
+}
+
+// idempotentShard 幂等分片
+type idempotentShard struct {
+	sync.Mutex
+	ops     int
+	dataMap map[string]idempotentState
+}
+
+func (si *ShardedIdempotent) Acquire(key string, ttl time.Duration) (bool, error) {
+	shard := si.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	now := time.Now()
+	if shard.ops++; shard.ops >= sweepEvery {
+		shard.ops = 0
+		shard.sweep(now)
+	}
+	if state, ok := shard.dataMap[key]; ok && !state.expired(now) {
+		return false, nil
+	}
+	var state idempotentState
+	if ttl > 0 {
+		state.expire = now.Add(ttl)
+	}
+	shard.dataMap[key] = state
+	return true, nil
+}
+
+func (si *ShardedIdempotent) Release(key string) error {
+	shard := si.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	if !shard.dataMap[key].done {
+		delete(shard.dataMap, key)
+	}
+	return nil
+}
+
+func (si *ShardedIdempotent) Done(key string) error {
+	shard := si.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	state := idempotentState{done: true}
+	if si.Retention > 0 {
+		state.expire = time.Now().Add(si.Retention)
+	}
+	shard.dataMap[key] = state
+	return nil
+}
+
+// Len 获取记录数量, 包含尚未清理的过期记录
+func (si *ShardedIdempotent) Len() (n int) {
+	si.init()
+	for _, shard := range si.shards {
+		shard.Lock()
+		n += len(shard.dataMap)
+		shard.Unlock()
+	}
+	return n
+}
+
+// shard 获取key所在的分片
+func (si *ShardedIdempotent) shard(key string) *idempotentShard {
+	si.init()
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return si.shards[h.Sum32()%uint32(len(si.shards))]
+}
+
+func (si *ShardedIdempotent) init() {
+	si.once.Do(func() {
+		n := si.Shards
+		if n <= 0 {
+			n = DefaultIdempotentShards
+		}
+		si.shards = make([]*idempotentShard, n)
+		for i := range si.shards {
+			si.shards[i] = &idempotentShard{dataMap: make(map[string]idempotentState)}
+		}
+	})
+}
+
+// sweep 清理分片中的过期记录
+func (is *idempotentShard) sweep(now time.Time) {
+	for key, state := range is.dataMap {
+		if state.expired(now) {
+			delete(is.dataMap, key)
+		}
+	}
+}
+
+// expired 记录是否已过期, 零值表示永不过期
+func (st idempotentState) expired(now time.Time) bool {
+	return !st.expire.IsZero() && !now.Before(st.expire)
+}
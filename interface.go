@@ -3,6 +3,9 @@ package bus
 import (
 	"context"
 	"time"
+
+	"github.com/easy-bus/bus/ack"
+	"github.com/easy-bus/bus/clock"
 )
 
 type LoggerInterface interface {
@@ -12,12 +15,56 @@ type LoggerInterface interface {
 // IdempotentInterface 幂等性接口
 type IdempotentInterface interface {
 	// Acquire 获取key的操作权
+	// ttl 操作权的有效时长, 超时未完成则自动释放, 若 <= 0 则不过期
 	// 若返回值为true表示获取成功, 允许操作
-	// 若返回值为false表示获取失败, 不允许操作
-	Acquire(key string) (bool, error)
+	// 若返回值为false表示获取失败或已完成, 不允许操作
+	Acquire(key string, ttl time.Duration) (bool, error)
 
-	// Release 释放key的操作权
+	// Release 释放key的操作权, 已完成的key不受影响
 	Release(key string) error
+
+	// Done 标记key已完成, 此后永久不允许操作
+	Done(key string) error
+}
+
+// SchemaValidatorInterface 消息校验接口
+type SchemaValidatorInterface interface {
+	// Validate 校验消息内容是否符合约定
+	// 返回错误表示消息不合法
+	Validate(payload []byte) error
+}
+
+// ArchiveInterface 消息归档接口
+type ArchiveInterface interface {
+	// Store 存储消息及其处理结果
+	// 用于合规审计以及故障后的消息回放
+	Store(record *ArchiveRecord) error
+}
+
+// ErrorDecision 驱动错误的处理决策
+type ErrorDecision int
+
+const (
+	// ErrorRecover 重新初始化队列及订阅后继续运行
+	ErrorRecover ErrorDecision = iota
+	// ErrorIgnore 忽略错误继续运行
+	ErrorIgnore
+	// ErrorStop 停止处理器
+	ErrorStop
+)
+
+// ErrorHandlerInterface 驱动错误处理接口
+type ErrorHandlerInterface interface {
+	// OnDriverError 处理驱动上报的队列级错误
+	// 可用于告警或快速失败, 返回值决定处理器的后续行为
+	OnDriverError(queue string, err error) ErrorDecision
+}
+
+// ErrorHandlerFunc 函数形式的驱动错误处理
+type ErrorHandlerFunc func(queue string, err error) ErrorDecision
+
+func (fn ErrorHandlerFunc) OnDriverError(queue string, err error) ErrorDecision {
+	return fn(queue, err)
 }
 
 // DLStorageInterface 死信存储接口
@@ -30,6 +77,13 @@ type DLStorageInterface interface {
 	Remove(id string) error
 }
 
+// ExpiredDLStorageInterface 支持过期清理的死信存储
+// DLJanitor 要求死信存储实现该接口
+type ExpiredDLStorageInterface interface {
+	// Expired 取出存储时间早于before的消息内容
+	Expired(queue string, before time.Time) (map[string][]byte, error)
+}
+
 // TXStorageInterface 预发存储接口
 type TXStorageInterface interface {
 	// Store 将消息预存
@@ -43,6 +97,49 @@ type TXStorageInterface interface {
 	Remove(id string) error
 }
 
+// StaleTXStorageInterface 支持滞留扫描的事务存储
+// 事务存储实现该接口时发送器才会定时扫描并补偿滞留的事务消息
+type StaleTXStorageInterface interface {
+	// Stale 获取存储时间早于before的消息标识
+	Stale(before time.Time) (ids []string, err error)
+
+	// Touch 将消息的存储时间刷新为当前时间
+	// 补偿日志发送后调用, 避免在下一个滞留周期之前重复补偿
+	Touch(id string) error
+}
+
+// BlobStorageInterface 大消息内容存储接口
+// 超出阈值的消息内容存入外部存储(如S3, Redis), 消息仅携带引用
+// 同一内容可能被多个队列读取, 存储需自行按保留时长清理
+type BlobStorageInterface interface {
+	// Put 存储内容, 返回引用标识
+	Put(data []byte) (ref string, err error)
+
+	// Get 根据引用标识取出内容
+	Get(ref string) (data []byte, err error)
+}
+
+// RetainStorageInterface 保留消息存储接口
+// 按主题及路由键保存最新一条消息, 供新订阅立即获取当前状态
+type RetainStorageInterface interface {
+	// Store 保存主题及路由键的最新消息, 覆盖已有消息
+	Store(topic, routeKey string, data []byte) error
+
+	// Fetch 获取主题及路由键的最新消息, 不存在时返回nil
+	Fetch(topic, routeKey string) ([]byte, error)
+}
+
+// DedupStorageInterface 发送去重存储接口
+type DedupStorageInterface interface {
+	// Mark 标记key已发送, window 去重窗口时长, 超出窗口后标记失效
+	// 若返回值为true表示标记成功, 允许发送
+	// 若返回值为false表示窗口内已标记, 即重复发送
+	Mark(key string, window time.Duration) (bool, error)
+
+	// Unmark 移除标记, 发送失败时调用以允许重新发送
+	Unmark(key string) error
+}
+
 // DriverInterface 驱动接口
 type DriverInterface interface {
 	// CreateQueue 创建队列
@@ -80,6 +177,129 @@ type DriverInterface interface {
 	// ctx 上下文, 用于中断监听
 	// queue 接受消息的队列名称
 	// errChan 异常错误传输通道
-	// handler 消息回调处理函数
-	ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) bool)
+	// handler 消息回调处理函数, 驱动根据返回的处理结果确认消息
+	ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) Result)
 }
+
+// Result 消息处理结果
+// 驱动据此将处理结果映射为中间件原生的确认机制
+type Result = ack.Result
+
+// 确认动作
+const (
+	ActionAck        = ack.ActionAck
+	ActionRetry      = ack.ActionRetry
+	ActionDeadLetter = ack.ActionDeadLetter
+)
+
+// Ack 确认消息, 从队列中移除
+func Ack() Result { return ack.Ack() }
+
+// NackRetry 拒绝消息, 由驱动延迟后重新投递
+func NackRetry(delay time.Duration, reason error) Result { return ack.NackRetry(delay, reason) }
+
+// NackDeadLetter 拒绝消息, 由驱动转入死信队列
+func NackDeadLetter(reason error) Result { return ack.NackDeadLetter(reason) }
+
+// DeadLetterDriverInterface 支持原生死信队列的驱动
+// 如RabbitMQ的DLX, SQS的redrive策略
+type DeadLetterDriverInterface interface {
+	// CreateQueueWithDL 创建队列并绑定死信队列
+	// 处理结果为NackDeadLetter的消息将由驱动转入dlQueue
+	CreateQueueWithDL(name string, delay time.Duration, dlQueue string) error
+}
+
+// ConfirmDriverInterface 支持发布确认的驱动
+// 如AMQP的publisher confirms, Kafka的offset
+type ConfirmDriverInterface interface {
+	// SendToTopicConfirmed 发送消息至主题, 参数同SendToTopic
+	// 中间件确认后返回其分配的消息标识
+	SendToTopicConfirmed(topic string, content []byte, routeKey string) (id string, err error)
+}
+
+// BatchAck 批量确认策略
+type BatchAck = ack.Batch
+
+// BatchAckDriverInterface 支持批量确认的驱动
+// 处理结果按策略累计后一并确认, 减少逐条确认的开销
+// 进程崩溃时尚未确认的消息将被重新投递, 处理逻辑需保证幂等
+type BatchAckDriverInterface interface {
+	// ReceiveMessageBatchAck 监听队列获取消息, 参数同ReceiveMessage
+	// batch 批量确认策略, 退出前确认全部已处理的消息
+	ReceiveMessageBatchAck(ctx context.Context, queue string, errChan chan error, batch BatchAck, handler func([]byte) Result)
+}
+
+// ScheduleDriverInterface 支持定时投递的驱动
+// 如RabbitMQ的延迟消息插件, 基于可见时间存储的驱动
+type ScheduleDriverInterface interface {
+	// SendToTopicAt 发送消息至主题, 参数同SendToTopic
+	// 消息在at之后方可被消费者获取
+	SendToTopicAt(topic string, content []byte, routeKey string, at time.Time) error
+}
+
+// Capability 驱动能力标识
+type Capability uint
+
+const (
+	// CapabilityDeadLetter 原生死信队列
+	CapabilityDeadLetter Capability = 1 << iota
+	// CapabilityExtend 延长消息处理期限
+	CapabilityExtend
+	// CapabilityConfirm 发布确认
+	CapabilityConfirm
+	// CapabilityBatchAck 批量确认
+	CapabilityBatchAck
+	// CapabilitySchedule 定时投递
+	CapabilitySchedule
+)
+
+// Has 是否具备指定能力
+func (c Capability) Has(flag Capability) bool { return c&flag == flag }
+
+// CapabilityDriverInterface 自行声明能力的驱动
+// 用于包装其他驱动的组合驱动(如路由, 故障转移及镜像驱动)
+// 组合驱动实现了全部可选接口, 但仅在底层驱动支持时声明相应能力
+type CapabilityDriverInterface interface {
+	// Capabilities 获取驱动具备的能力
+	Capabilities() Capability
+}
+
+// Capabilities 获取驱动具备的能力
+// 驱动实现CapabilityDriverInterface时以其声明为准, 否则按实现的可选接口判断
+func Capabilities(driver DriverInterface) (caps Capability) {
+	if cd, ok := driver.(CapabilityDriverInterface); ok {
+		return cd.Capabilities()
+	}
+	if _, ok := driver.(DeadLetterDriverInterface); ok {
+		caps |= CapabilityDeadLetter
+	}
+	if _, ok := driver.(ExtendableDriverInterface); ok {
+		caps |= CapabilityExtend
+	}
+	if _, ok := driver.(ConfirmDriverInterface); ok {
+		caps |= CapabilityConfirm
+	}
+	if _, ok := driver.(BatchAckDriverInterface); ok {
+		caps |= CapabilityBatchAck
+	}
+	if _, ok := driver.(ScheduleDriverInterface); ok {
+		caps |= CapabilitySchedule
+	}
+	return caps
+}
+
+// Extender 延长当前消息的处理期限
+// 期限内消息不会被驱动重新投递
+type Extender func(d time.Duration) error
+
+// ExtendableDriverInterface 支持延长消息处理期限的驱动
+// 适用于具有可见性超时的驱动, 避免长耗时消息在处理期间被重新投递
+type ExtendableDriverInterface interface {
+	// ReceiveMessageExtend 监听队列获取消息, 参数同ReceiveMessage
+	// handler 的extend参数用于延长该消息的处理期限
+	ReceiveMessageExtend(ctx context.Context, queue string, errChan chan error, handler func(data []byte, extend func(time.Duration) error) Result)
+}
+
+// Clock 时钟
+// 定时重试, 延迟投递及重连退避通过时钟等待, 测试中可使用clock.Mock快进时间
+type Clock = clock.Clock
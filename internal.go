@@ -1,7 +1,6 @@
 package bus
 
 import (
-	"context"
 	"fmt"
 	"log"
 	"strconv"
@@ -19,34 +18,72 @@ func (stderrLogger) Errorf(format string, args ...interface{}) {
 // nullIdempotent 空的幂等实现
 type nullIdempotent struct{}
 
-func (ni nullIdempotent) Acquire(key string) (bool, error) { return false, nil }
+func (ni nullIdempotent) Acquire(key string, ttl time.Duration) (bool, error) { return false, nil }
 
 func (ni nullIdempotent) Release(key string) error { return nil }
 
+func (ni nullIdempotent) Done(key string) error { return nil }
+
 // internalIdempotent 内部幂等实现
 type internalIdempotent struct {
 	sync.Mutex
-	dataMap map[string]bool
+	dataMap map[string]idempotentState
+}
+
+// idempotentState 幂等状态
+type idempotentState struct {
+	done   bool
+	expire time.Time
 }
 
-func (ii *internalIdempotent) Acquire(key string) (bool, error) {
+func (ii *internalIdempotent) Acquire(key string, ttl time.Duration) (bool, error) {
 	ii.Lock()
 	defer ii.Unlock()
 	if ii.dataMap == nil {
-		ii.dataMap = make(map[string]bool)
+		ii.dataMap = make(map[string]idempotentState)
 	}
-	if _, ok := ii.dataMap[key]; ok {
-		return false, nil
+	if state, ok := ii.dataMap[key]; ok {
+		if state.done || state.expire.IsZero() || time.Now().Before(state.expire) {
+			return false, nil
+		}
+	}
+	var state idempotentState
+	if ttl > 0 {
+		state.expire = time.Now().Add(ttl)
 	}
-	ii.dataMap[key] = true
+	ii.dataMap[key] = state
 	return true, nil
 }
 
 func (ii *internalIdempotent) Release(key string) error {
-	delete(ii.dataMap, key)
+	ii.Lock()
+	defer ii.Unlock()
+	if !ii.dataMap[key].done {
+		delete(ii.dataMap, key)
+	}
+	return nil
+}
+
+func (ii *internalIdempotent) Done(key string) error {
+	ii.Lock()
+	defer ii.Unlock()
+	if ii.dataMap == nil {
+		ii.dataMap = make(map[string]idempotentState)
+	}
+	ii.dataMap[key] = idempotentState{done: true}
 	return nil
 }
 
+// nullSchemaValidator 空的消息校验
+type nullSchemaValidator struct{}
+
+func (nv nullSchemaValidator) Validate(payload []byte) error { return nil }
+
+// nullArchive 空的消息归档
+type nullArchive struct{}
+
+func (na nullArchive) Store(record *ArchiveRecord) error { return nil }
+
 // nullDLStorage 空的死信存储
 type nullDLStorage struct{}
 
@@ -58,142 +95,192 @@ func (nd nullDLStorage) Fetch(queue string) (map[string][]byte, error) {
 
 func (nd nullDLStorage) Remove(id string) error { return nil }
 
+func (nd nullDLStorage) Expired(queue string, before time.Time) (map[string][]byte, error) {
+	return nil, nil
+}
+
 // internalDLStorage 内部死信存储
 type internalDLStorage struct {
+	sync.Mutex
 	index   map[string]string
+	times   map[string]time.Time
 	dataMap map[string]map[string][]byte
 }
 
 func (id *internalDLStorage) Store(queue string, data []byte) error {
+	id.Lock()
+	defer id.Unlock()
 	if id.dataMap == nil {
 		id.index = make(map[string]string)
+		id.times = make(map[string]time.Time)
 		id.dataMap = make(map[string]map[string][]byte)
 	}
 	if _, ok := id.dataMap[queue]; !ok {
 		id.dataMap[queue] = make(map[string][]byte)
 	}
-	pid := strconv.Itoa(len(id.dataMap[queue]))
+	pid := strconv.Itoa(len(id.index))
 	id.index[pid], id.dataMap[queue][pid] = queue, data
+	id.times[pid] = time.Now()
 	return nil
 }
 
 func (id *internalDLStorage) Fetch(queue string) (map[string][]byte, error) {
-	return id.dataMap[queue], nil
+	id.Lock()
+	defer id.Unlock()
+	rows := make(map[string][]byte, len(id.dataMap[queue]))
+	for pid, data := range id.dataMap[queue] {
+		rows[pid] = data
+	}
+	return rows, nil
 }
 
 func (id *internalDLStorage) Remove(pid string) error {
+	id.Lock()
+	defer id.Unlock()
 	queue := id.index[pid]
 	delete(id.dataMap[queue], pid)
 	return nil
 }
 
+func (id *internalDLStorage) Expired(queue string, before time.Time) (map[string][]byte, error) {
+	id.Lock()
+	defer id.Unlock()
+	rows := make(map[string][]byte)
+	for pid, data := range id.dataMap[queue] {
+		if id.times[pid].Before(before) {
+			rows[pid] = data
+		}
+	}
+	return rows, nil
+}
+
 // internalTXStorage 内部事务存储
 type internalTXStorage struct {
+	sync.Mutex
+	times   map[string]time.Time
 	dataMap map[string][]byte
 }
 
 func (it *internalTXStorage) Store(data []byte) (string, error) {
+	it.Lock()
+	defer it.Unlock()
 	if it.dataMap == nil {
 		it.dataMap = make(map[string][]byte)
+		it.times = make(map[string]time.Time)
 	}
 	id := generateSeqId()
 	it.dataMap[id] = data
+	it.times[id] = time.Now()
 	return id, nil
 }
 
 func (it *internalTXStorage) Fetch(id string) ([]byte, error) {
+	it.Lock()
+	defer it.Unlock()
 	return it.dataMap[id], nil
 }
 
 func (it *internalTXStorage) Remove(id string) error {
+	it.Lock()
+	defer it.Unlock()
 	delete(it.dataMap, id)
+	delete(it.times, id)
 	return nil
 }
 
-// internalDriver 内部驱动实现
-type internalDriver struct {
-	queues   map[string]*internalQueue
-	relation map[string]map[string]map[string]*internalQueue
+func (it *internalTXStorage) Stale(before time.Time) ([]string, error) {
+	it.Lock()
+	defer it.Unlock()
+	ids := make([]string, 0)
+	for id, t := range it.times {
+		if t.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
 }
 
-// internalQueue 内部队列结构
-type internalQueue struct {
-	name    string
-	delay   time.Duration
-	msgChan chan internalData
+func (it *internalTXStorage) Touch(id string) error {
+	it.Lock()
+	defer it.Unlock()
+	if _, ok := it.times[id]; ok {
+		it.times[id] = time.Now()
+	}
+	return nil
 }
 
-// internalData 内部消息结构
-type internalData struct {
-	data  []byte
-	delay time.Duration
+// internalBlobStorage 内部大消息内容存储
+type internalBlobStorage struct {
+	sync.Mutex
+	dataMap map[string][]byte
 }
 
-func (id *internalDriver) CreateQueue(name string, delay time.Duration) error {
-	if id.queues == nil {
-		id.queues = make(map[string]*internalQueue)
-	}
-	id.queues[name] = &internalQueue{
-		name:    name,
-		delay:   delay,
-		msgChan: make(chan internalData, 9),
+func (ib *internalBlobStorage) Put(data []byte) (string, error) {
+	ib.Lock()
+	defer ib.Unlock()
+	if ib.dataMap == nil {
+		ib.dataMap = make(map[string][]byte)
 	}
-	return nil
+	ref := strconv.Itoa(len(ib.dataMap))
+	ib.dataMap[ref] = data
+	return ref, nil
 }
 
-func (id *internalDriver) CreateTopic(name string) error {
-	if id.relation == nil {
-		id.relation = make(map[string]map[string]map[string]*internalQueue)
-	}
-	if _, ok := id.relation[name]; !ok {
-		id.relation[name] = make(map[string]map[string]*internalQueue)
+func (ib *internalBlobStorage) Get(ref string) ([]byte, error) {
+	ib.Lock()
+	defer ib.Unlock()
+	data, ok := ib.dataMap[ref]
+	if !ok {
+		return nil, fmt.Errorf("blob [%s] not found", ref)
 	}
-	return nil
+	return data, nil
+}
+
+// internalRetainStorage 内部保留消息存储
+type internalRetainStorage struct {
+	sync.Mutex
+	dataMap map[string][]byte
 }
 
-func (id *internalDriver) Subscribe(topic, queue, routeKey string) error {
-	if _, ok := id.relation[topic][queue]; !ok {
-		id.relation[topic][queue] = make(map[string]*internalQueue)
+func (ir *internalRetainStorage) Store(topic, routeKey string, data []byte) error {
+	ir.Lock()
+	defer ir.Unlock()
+	if ir.dataMap == nil {
+		ir.dataMap = make(map[string][]byte)
 	}
-	id.relation[topic][queue][routeKey] = id.queues[queue]
+	ir.dataMap[topic+"\x00"+routeKey] = data
 	return nil
 }
 
-func (id *internalDriver) UnSubscribe(topic, queue, routeKey string) error {
-	delete(id.relation[topic][queue], routeKey)
-	return nil
+func (ir *internalRetainStorage) Fetch(topic, routeKey string) ([]byte, error) {
+	ir.Lock()
+	defer ir.Unlock()
+	return ir.dataMap[topic+"\x00"+routeKey], nil
 }
 
-func (id *internalDriver) SendToQueue(queue string, content []byte, delay time.Duration) error {
-	id.queues[queue].msgChan <- internalData{delay: delay, data: content}
-	return nil
+// internalDedupStorage 内部发送去重存储
+type internalDedupStorage struct {
+	sync.Mutex
+	dataMap map[string]time.Time
 }
 
-func (id *internalDriver) SendToTopic(topic string, content []byte, routeKey string) error {
-	for _, queues := range id.relation[topic] {
-		for rk, queue := range queues {
-			if rk == routeKey {
-				queue.msgChan <- internalData{delay: queue.delay, data: content}
-			}
-		}
+func (id *internalDedupStorage) Mark(key string, window time.Duration) (bool, error) {
+	id.Lock()
+	defer id.Unlock()
+	if id.dataMap == nil {
+		id.dataMap = make(map[string]time.Time)
 	}
-	return nil
+	now := time.Now()
+	if expire, ok := id.dataMap[key]; ok && now.Before(expire) {
+		return false, nil
+	}
+	id.dataMap[key] = now.Add(window)
+	return true, nil
 }
 
-func (id *internalDriver) ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) bool) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case msg := <-id.queues[queue].msgChan:
-			goroutine(func() {
-				if msg.delay > 0 {
-					<-time.NewTimer(msg.delay).C
-				}
-				if handler(msg.data) == false {
-					_ = id.SendToQueue(queue, msg.data, msg.delay)
-				}
-			})
-		}
-	}
+func (id *internalDedupStorage) Unmark(key string) error {
+	id.Lock()
+	defer id.Unlock()
+	delete(id.dataMap, key)
+	return nil
 }
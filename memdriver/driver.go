@@ -0,0 +1,329 @@
+package memdriver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/easy-bus/bus/ack"
+	"github.com/easy-bus/bus/clock"
+)
+
+// DefaultCapacity 默认队列缓冲容量
+const DefaultCapacity = 9
+
+// ErrQueueFull 队列已满
+var ErrQueueFull = errors.New("memdriver: queue is full")
+
+// Overflow 队列已满时的发送策略
+type Overflow int
+
+const (
+	// OverflowBlock 阻塞直至消息被消费
+	OverflowBlock Overflow = iota
+	// OverflowDropOldest 丢弃最早的消息
+	OverflowDropOldest
+	// OverflowError 返回ErrQueueFull
+	OverflowError
+)
+
+// QueueOptions 队列配置
+type QueueOptions struct {
+	// Capacity 队列缓冲容量, 若 <= 0 则使用驱动的Capacity
+	Capacity int
+
+	// Overflow 队列已满时的发送策略
+	Overflow Overflow
+}
+
+// Driver 内存驱动
+// 消息仅存在于进程内存中, 适用于开发环境, 示例以及测试
+type Driver struct {
+	// Capacity 队列缓冲容量, 若 <= 0 则使用DefaultCapacity
+	Capacity int
+
+	// Overflow 队列已满时的发送策略, 默认阻塞直至消息被消费
+	Overflow Overflow
+
+	// QueueOptions 按队列名称指定的配置, 优先级高于Capacity及Overflow
+	// 需在创建队列前设置
+	QueueOptions map[string]QueueOptions
+
+	// Sync 同步模式
+	// 开启后消息在接收协程中逐条处理, 延迟消息将阻塞后续消息
+	// 关闭时每条消息在独立的协程中处理
+	Sync bool
+
+	// Clock 延迟投递使用的时钟, 默认为系统时钟
+	Clock clock.Clock
+
+	mu       sync.RWMutex
+	seq      uint64
+	queues   map[string]*memQueue
+	relation map[string]map[string]map[string]*memQueue
+}
+
+// memQueue 队列结构
+type memQueue struct {
+	name     string
+	delay    time.Duration
+	dlQueue  string
+	overflow Overflow
+	dropped  uint64
+	msgChan  chan memMessage
+}
+
+// memMessage 消息结构
+type memMessage struct {
+	data  []byte
+	delay time.Duration
+}
+
+func (d *Driver) CreateQueue(name string, delay time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.queues == nil {
+		d.queues = make(map[string]*memQueue)
+	}
+	if q, ok := d.queues[name]; ok {
+		q.delay = delay // 重复创建仅更新延迟, 保留已有消息
+		return nil
+	}
+	capacity, overflow := d.Capacity, d.Overflow
+	if opts, ok := d.QueueOptions[name]; ok {
+		overflow = opts.Overflow
+		if opts.Capacity > 0 {
+			capacity = opts.Capacity
+		}
+	}
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	d.queues[name] = &memQueue{
+		name:     name,
+		delay:    delay,
+		overflow: overflow,
+		msgChan:  make(chan memMessage, capacity),
+	}
+	return nil
+}
+
+// CreateQueueWithDL 创建队列并绑定死信队列
+// 处理结果为NackDeadLetter的消息将转入dlQueue
+func (d *Driver) CreateQueueWithDL(name string, delay time.Duration, dlQueue string) error {
+	if err := d.CreateQueue(name, delay); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queues[name].dlQueue = dlQueue
+	return nil
+}
+
+func (d *Driver) CreateTopic(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.relation == nil {
+		d.relation = make(map[string]map[string]map[string]*memQueue)
+	}
+	if _, ok := d.relation[name]; !ok {
+		d.relation[name] = make(map[string]map[string]*memQueue)
+	}
+	return nil
+}
+
+func (d *Driver) Subscribe(topic, queue, routeKey string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	q, ok := d.queues[queue]
+	if !ok {
+		return fmt.Errorf("memdriver: queue [%s] not exists", queue)
+	}
+	if _, ok := d.relation[topic]; !ok {
+		return fmt.Errorf("memdriver: topic [%s] not exists", topic)
+	}
+	if _, ok := d.relation[topic][queue]; !ok {
+		d.relation[topic][queue] = make(map[string]*memQueue)
+	}
+	d.relation[topic][queue][routeKey] = q
+	return nil
+}
+
+func (d *Driver) UnSubscribe(topic, queue, routeKey string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.relation[topic][queue], routeKey)
+	return nil
+}
+
+func (d *Driver) SendToQueue(queue string, content []byte, delay time.Duration) error {
+	q, err := d.queue(queue)
+	if err != nil {
+		return err
+	}
+	return q.enqueue(memMessage{delay: delay, data: content})
+}
+
+func (d *Driver) SendToTopic(topic string, content []byte, routeKey string) error {
+	_, err := d.SendToTopicConfirmed(topic, content, routeKey)
+	return err
+}
+
+// SendToTopicConfirmed 发送消息至主题, 返回驱动内自增的消息序号
+func (d *Driver) SendToTopicConfirmed(topic string, content []byte, routeKey string) (string, error) {
+	return d.sendToTopic(topic, content, routeKey, 0)
+}
+
+// SendToTopicAt 发送消息至主题, 消息在at之后方可被获取
+// 队列延迟晚于at时以队列延迟为准
+func (d *Driver) SendToTopicAt(topic string, content []byte, routeKey string, at time.Time) error {
+	_, err := d.sendToTopic(topic, content, routeKey, at.Sub(clock.Or(d.Clock).Now()))
+	return err
+}
+
+// sendToTopic 发送消息至主题, 消息至少延迟delay后方可被获取
+func (d *Driver) sendToTopic(topic string, content []byte, routeKey string, delay time.Duration) (string, error) {
+	id := strconv.FormatUint(atomic.AddUint64(&d.seq, 1), 10)
+	// 队列延迟可能被重复创建更新, 需在持有锁时读取
+	type target struct {
+		q    *memQueue
+		wait time.Duration
+	}
+	d.mu.RLock()
+	targets := make([]target, 0)
+	for _, queues := range d.relation[topic] {
+		for rk, q := range queues {
+			if rk == routeKey {
+				wait := q.delay
+				if delay > wait {
+					wait = delay
+				}
+				targets = append(targets, target{q: q, wait: wait})
+			}
+		}
+	}
+	d.mu.RUnlock()
+	var err error
+	for _, t := range targets {
+		if e := t.q.enqueue(memMessage{delay: t.wait, data: content}); e != nil && err == nil {
+			err = fmt.Errorf("memdriver: send to queue [%s] failed, %w", t.q.name, e)
+		}
+	}
+	return id, err
+}
+
+func (d *Driver) ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) ack.Result) {
+	q, err := d.queue(queue)
+	if err != nil {
+		errChan <- err
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-q.msgChan:
+			if d.Sync {
+				d.handle(q, msg, handler)
+			} else {
+				go d.handle(q, msg, handler)
+			}
+		}
+	}
+}
+
+// Dropped 获取队列因已满而丢弃的消息数量
+func (d *Driver) Dropped(queue string) uint64 {
+	q, err := d.queue(queue)
+	if err != nil {
+		return 0
+	}
+	return atomic.LoadUint64(&q.dropped)
+}
+
+// QueueDepth 获取队列中待消费的消息数量
+func (d *Driver) QueueDepth(queue string) int {
+	q, err := d.queue(queue)
+	if err != nil {
+		return 0
+	}
+	return len(q.msgChan)
+}
+
+// handle 处理消息, 拒绝重试的消息按指定延迟重新入队
+// 拒绝转入死信的消息进入绑定的死信队列, 未绑定时丢弃
+func (d *Driver) handle(q *memQueue, msg memMessage, handler func([]byte) ack.Result) {
+	if msg.delay > 0 {
+		<-clock.Or(d.Clock).NewTimer(msg.delay).C()
+	}
+	switch result := handler(msg.data); result.Action {
+	case ack.ActionRetry:
+		msg.delay = result.Delay
+		push(q, msg)
+	case ack.ActionDeadLetter:
+		d.mu.RLock()
+		dlq, ok := d.queues[q.dlQueue]
+		d.mu.RUnlock()
+		if ok {
+			push(dlq, memMessage{data: msg.data})
+		}
+	}
+}
+
+// enqueue 消息入队, 队列已满时按发送策略处理
+func (q *memQueue) enqueue(msg memMessage) error {
+	switch q.overflow {
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.msgChan <- msg:
+				return nil
+			default:
+			}
+			select {
+			case <-q.msgChan:
+				atomic.AddUint64(&q.dropped, 1)
+			default:
+			}
+		}
+	case OverflowError:
+		select {
+		case q.msgChan <- msg:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	default:
+		q.msgChan <- msg
+		return nil
+	}
+}
+
+// push 消息重新入队, 队列已满时避免阻塞接收协程
+// 丢弃最早消息的队列按策略处理, 其余策略均等待入队以免丢失消息
+func push(q *memQueue, msg memMessage) {
+	if q.overflow == OverflowDropOldest {
+		_ = q.enqueue(msg)
+		return
+	}
+	select {
+	case q.msgChan <- msg:
+	default:
+		go func() { q.msgChan <- msg }()
+	}
+}
+
+// queue 获取队列
+func (d *Driver) queue(name string) (*memQueue, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	q, ok := d.queues[name]
+	if !ok {
+		return nil, fmt.Errorf("memdriver: queue [%s] not exists", name)
+	}
+	return q, nil
+}
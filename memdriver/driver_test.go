@@ -0,0 +1,153 @@
+package memdriver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/easy-bus/bus/ack"
+	"github.com/easy-bus/bus/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDriver(t *testing.T) {
+	d := &Driver{Capacity: 3, Sync: true}
+	assert.Nil(t, d.CreateTopic("topic"))
+	assert.Nil(t, d.CreateQueue("queue", 0))
+	assert.NotNil(t, d.Subscribe("topic", "missing", ""))
+	assert.Nil(t, d.Subscribe("topic", "queue", "key"))
+	assert.Nil(t, d.SendToTopic("topic", []byte("a"), "key"))
+	assert.Nil(t, d.SendToTopic("topic", []byte("b"), "other"))
+	assert.Nil(t, d.SendToQueue("queue", []byte("c"), 0))
+	assert.Equal(t, 2, d.QueueDepth("queue"))
+	assert.NotNil(t, d.SendToQueue("missing", nil, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var received []string
+	d.ReceiveMessage(ctx, "queue", make(chan error), func(data []byte) ack.Result {
+		received = append(received, string(data))
+		if len(received) == 3 {
+			cancel()
+		}
+		return ack.Of(len(received) != 1) // 首次处理失败重新入队
+	})
+	assert.Equal(t, []string{"a", "c", "a"}, received)
+	assert.Equal(t, 0, d.QueueDepth("queue"))
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	d := &Driver{Sync: true}
+	assert.Nil(t, d.CreateQueue("queue.dl", 0))
+	assert.Nil(t, d.CreateQueueWithDL("queue", 0, "queue.dl"))
+	assert.Nil(t, d.CreateQueue("other", 0))
+	assert.Nil(t, d.SendToQueue("queue", []byte("a"), 0))
+	assert.Nil(t, d.SendToQueue("other", []byte("b"), 0))
+	for _, queue := range []string{"queue", "other"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		d.ReceiveMessage(ctx, queue, make(chan error), func(data []byte) ack.Result {
+			cancel()
+			return ack.NackDeadLetter(nil)
+		})
+	}
+	assert.Equal(t, 1, d.QueueDepth("queue.dl"))
+	assert.Equal(t, 0, d.QueueDepth("other"))
+}
+
+func TestDelayClock(t *testing.T) {
+	mock := clock.NewMock(time.Now())
+	d := &Driver{Clock: mock}
+	assert.Nil(t, d.CreateQueue("queue", 0))
+	assert.Nil(t, d.SendToQueue("queue", []byte("a"), time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan string, 1)
+	go d.ReceiveMessage(ctx, "queue", make(chan error), func(data []byte) ack.Result {
+		received <- string(data)
+		return ack.Ack()
+	})
+	mock.BlockUntil(1)
+	mock.Add(time.Hour - time.Second)
+	assert.Len(t, received, 0)
+	mock.Add(time.Second)
+	assert.Equal(t, "a", <-received)
+}
+
+func TestOverflow(t *testing.T) {
+	d := &Driver{Capacity: 2, QueueOptions: map[string]QueueOptions{
+		"drop":  {Capacity: 3, Overflow: OverflowDropOldest},
+		"error": {Overflow: OverflowError},
+	}}
+	assert.Nil(t, d.CreateTopic("topic"))
+	for _, name := range []string{"drop", "error"} {
+		assert.Nil(t, d.CreateQueue(name, 0))
+		assert.Nil(t, d.Subscribe("topic", name, ""))
+	}
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, d.SendToTopic("topic", []byte{byte('a' + i)}, ""))
+	}
+	err := d.SendToTopic("topic", []byte("c"), "")
+	assert.ErrorIs(t, err, ErrQueueFull)
+	assert.Equal(t, ErrQueueFull, d.SendToQueue("error", nil, 0))
+	assert.Equal(t, 3, d.QueueDepth("drop"))
+	assert.Nil(t, d.SendToQueue("drop", []byte("d"), 0))
+	assert.Nil(t, d.SendToQueue("drop", []byte("e"), 0))
+	assert.Equal(t, uint64(2), d.Dropped("drop"))
+	assert.Equal(t, 2, d.QueueDepth("error"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var received []string
+	d.Sync = true
+	d.ReceiveMessage(ctx, "drop", make(chan error), func(data []byte) ack.Result {
+		if received = append(received, string(data)); len(received) == 3 {
+			cancel()
+		}
+		return ack.Ack()
+	})
+	assert.Equal(t, []string{"c", "d", "e"}, received)
+}
+
+func TestSendToTopicAt(t *testing.T) {
+	mock := clock.NewMock(time.Now())
+	d := &Driver{Clock: mock}
+	assert.Nil(t, d.CreateTopic("topic"))
+	assert.Nil(t, d.CreateQueue("fast", 0))
+	assert.Nil(t, d.CreateQueue("slow", 2*time.Hour))
+	assert.Nil(t, d.Subscribe("topic", "fast", ""))
+	assert.Nil(t, d.Subscribe("topic", "slow", ""))
+	assert.Nil(t, d.SendToTopicAt("topic", []byte("a"), "", mock.Now().Add(time.Hour)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan string, 2)
+	for _, queue := range []string{"fast", "slow"} {
+		queue := queue
+		go d.ReceiveMessage(ctx, queue, make(chan error), func([]byte) ack.Result {
+			received <- queue
+			return ack.Ack()
+		})
+	}
+	mock.BlockUntil(2)
+	mock.Add(time.Hour)
+	assert.Equal(t, "fast", <-received)
+	assert.Len(t, received, 0) // 队列延迟晚于投递时间时以队列延迟为准
+	mock.Add(time.Hour)
+	assert.Equal(t, "slow", <-received)
+}
+
+func TestRecreateQueueConcurrently(t *testing.T) {
+	d := &Driver{Capacity: 100}
+	assert.Nil(t, d.CreateTopic("topic"))
+	assert.Nil(t, d.CreateQueue("queue", 0))
+	assert.Nil(t, d.Subscribe("topic", "queue", ""))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			assert.Nil(t, d.CreateQueue("queue", time.Duration(i)))
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, d.SendToTopic("topic", []byte("a"), ""))
+	}
+	<-done
+	assert.Equal(t, 50, d.QueueDepth("queue"))
+}
@@ -1,7 +1,11 @@
 package bus
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"time"
 )
 
 // Message 消息结构体
@@ -18,11 +22,156 @@ type Message struct {
 
 	// RouteKey 路由键
 	RouteKey string `json:"k,omitempty"`
+
+	// Version 消息内容版本
+	// 处理器通过Upcasters将旧版本内容升级为当前版本
+	Version int `json:"v,omitempty"`
+
+	// Headers 消息头
+	Headers map[string]string `json:"h,omitempty"`
+
+	// Priority 消息优先级, 供支持优先级的驱动参考
+	Priority int `json:"o,omitempty"`
+
+	// ExpireAt 过期时间, unix毫秒
+	// 过期的消息将被处理器直接丢弃
+	ExpireAt int64 `json:"e,omitempty"`
+
+	// DeliverAt 投递时间, unix毫秒
+	// 提前到达的消息将被处理器延迟至该时间再处理
+	DeliverAt int64 `json:"d,omitempty"`
+
+	// ClaimRef 消息内容在外部存储中的引用
+	// 不为空时Payload不随消息传输, 由处理器从BlobStorage取回
+	ClaimRef string `json:"c,omitempty"`
+
+	// Hops 消息流转轨迹
+	// 每次被处理器接收时追加记录, 随重试及死信一同保存
+	Hops []Hop `json:"j,omitempty"`
+
+	// retryDelay 本次处理失败后的重试延迟
+	retryDelay *time.Duration
+
+	// extender 处理期限延长
+	extender Extender
+
+	// ctx 处理上下文
+	ctx context.Context
 }
 
+// maxHops 保留的流转轨迹数量上限
+const maxHops = 32
+
+// Hop 消息流转记录
+type Hop struct {
+	// Queue 处理队列名称
+	Queue string `json:"q"`
+
+	// Attempt 处理次数, 从1开始
+	Attempt int `json:"a"`
+
+	// At 开始处理时间
+	At time.Time `json:"t"`
+
+	// Cost 处理耗时, 仅在重试或进入死信时记录
+	Cost time.Duration `json:"c,omitempty"`
+}
+
+// hop 追加流转记录, 超出上限时丢弃最早的记录
+func (m *Message) hop(queue string, at time.Time) {
+	m.Hops = append(m.Hops, Hop{Queue: queue, Attempt: m.Retried + 1, At: at})
+	if len(m.Hops) > maxHops {
+		m.Hops = m.Hops[len(m.Hops)-maxHops:]
+	}
+}
+
+// Upcaster 消息版本升级函数
+// 将指定版本的消息内容转换为下一个版本
+type Upcaster func(payload []byte) ([]byte, error)
+
 // Scan 将消息内容赋值给目标参数
 func (m *Message) Scan(dest interface{}) { decode(m.Payload, dest) }
 
+// RetryAfter 指定本次处理失败后的重试延迟
+// 优先级高于处理器的RetryDelay, 若 < 0 则代表不进行重试
+func (m *Message) RetryAfter(delay time.Duration) { m.retryDelay = &delay }
+
+// Header 获取消息头
+func (m *Message) Header(key string) string { return m.Headers[key] }
+
+// Context 获取消息的处理上下文
+// 包含处理器通过Propagators从消息头恢复的值
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// Extend 延长消息的处理期限, 防止长耗时处理期间被重新投递
+// 驱动不支持时返回ErrExtendUnsupported
+func (m *Message) Extend(d time.Duration) error {
+	if m.extender == nil {
+		return ErrExtendUnsupported
+	}
+	return m.extender(d)
+}
+
+// MessageOpt 消息配置项
+type MessageOpt func(m *Message)
+
+// MessageID 指定消息唯一标识
+func MessageID(id string) MessageOpt {
+	return func(m *Message) { m.BizUID = id }
+}
+
+// MessageRouteKey 指定路由键
+func MessageRouteKey(routeKey string) MessageOpt {
+	return func(m *Message) { m.RouteKey = routeKey }
+}
+
+// MessageHeader 设置消息头
+func MessageHeader(key, value string) MessageOpt {
+	return func(m *Message) {
+		if m.Headers == nil {
+			m.Headers = make(map[string]string)
+		}
+		m.Headers[key] = value
+	}
+}
+
+// MessageTTL 指定消息有效期
+func MessageTTL(ttl time.Duration) MessageOpt {
+	return func(m *Message) { m.ExpireAt = time.Now().Add(ttl).UnixNano() / int64(time.Millisecond) }
+}
+
+// MessagePriority 指定消息优先级
+func MessagePriority(priority int) MessageOpt {
+	return func(m *Message) { m.Priority = priority }
+}
+
+// MessageDelay 指定消息延迟处理时长
+func MessageDelay(delay time.Duration) MessageOpt {
+	return func(m *Message) { m.DeliverAt = time.Now().Add(delay).UnixNano() / int64(time.Millisecond) }
+}
+
+// MessageDeliverAt 指定消息投递时间
+func MessageDeliverAt(at time.Time) MessageOpt {
+	return func(m *Message) { m.DeliverAt = at.UnixNano() / int64(time.Millisecond) }
+}
+
+// NewMessage 实例化消息, 未指定标识时自动生成
+func NewMessage(payload interface{}, opts ...MessageOpt) *Message {
+	m := &Message{Payload: encode(payload)}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.BizUID == "" {
+		m.BizUID = generateSeqId()
+	}
+	return m
+}
+
 // MessageAutoId 实例化消息
 func MessageAutoId(payload interface{}, routeKey string) *Message {
 	return MessageWithId(generateSeqId(), payload, routeKey)
@@ -37,6 +186,22 @@ func MessageWithId(id string, payload interface{}, routeKey string) *Message {
 	}
 }
 
+// MessageContentId 实例化消息
+// 标识由路由键及消息内容的哈希生成, 内容相同的消息标识相同
+// 重复发布的消息因此可被幂等判断合并, 无需调用方生成标识
+func MessageContentId(payload interface{}, routeKey string) *Message {
+	data := encode(payload)
+	hash := sha256.New()
+	hash.Write([]byte(routeKey))
+	hash.Write([]byte{0})
+	hash.Write(data)
+	return &Message{
+		BizUID:   hex.EncodeToString(hash.Sum(nil)),
+		Payload:  data,
+		RouteKey: routeKey,
+	}
+}
+
 // encode 数据编码
 func encode(data interface{}) []byte {
 	bts, err := json.Marshal(data)
@@ -4,12 +4,13 @@ import (
 	"context"
 	"time"
 
+	"github.com/easy-bus/bus/memdriver"
 	"github.com/stretchr/testify/mock"
 )
 
 type mockDriver struct {
 	mock.Mock
-	itd *internalDriver
+	itd *memdriver.Driver
 }
 
 func (m *mockDriver) CreateQueue(name string, delay time.Duration) error {
@@ -60,6 +61,6 @@ func (m *mockDriver) SendToTopic(topic string, content []byte, routeKey string)
 	return err
 }
 
-func (m *mockDriver) ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) bool) {
+func (m *mockDriver) ReceiveMessage(ctx context.Context, queue string, errChan chan error, handler func([]byte) Result) {
 	m.itd.ReceiveMessage(ctx, queue, errChan, handler)
 }
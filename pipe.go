@@ -0,0 +1,79 @@
+package bus
+
+import "sync"
+
+// Pipe 消息管道
+// 消费源队列的消息, 经转换后发布至目标主题
+// 转换或发布失败的消息按源处理器的RetryDelay重试, 最终进入死信存储
+type Pipe struct {
+	sync.Once
+
+	// Source 源队列处理器
+	// 其HandleFunc由管道接管, 无需设置
+	Source *Handler
+
+	// Target 目标主题发送器
+	Target *Sender
+
+	// Transform 消息转换函数
+	// 返回nil消息表示丢弃, 返回错误则进入重试
+	// 可通过RetryAfter包装错误指定重试延迟
+	Transform func(msg *Message) (*Message, error)
+}
+
+// Prepare 准备就绪
+func (p *Pipe) Prepare() *Pipe {
+	p.Do(func() {
+		if p.Source == nil {
+			throw("the pipe missing source handler")
+		}
+		if p.Target == nil {
+			throw("the pipe [%s] missing target sender", p.Source.Queue)
+		}
+		if p.Transform == nil {
+			throw("the pipe [%s] missing transform function", p.Source.Queue)
+		}
+		if p.Source.EnsureFunc == nil {
+			p.Source.EnsureFunc = func(*Message) bool { return true }
+		}
+		p.Source.HandleFunc = p.handle
+		p.Target.Prepare()
+		p.Source.Prepare()
+	})
+	return p
+}
+
+// Run 启动管道
+func (p *Pipe) Run() { p.Source.Run() }
+
+// Wait 等待退出
+func (p *Pipe) Wait() {
+	p.Source.Wait()
+	p.Target.Wait()
+}
+
+// handle 转换并转发消息
+func (p *Pipe) handle(msg *Message) bool {
+	out, err := p.Transform(msg)
+	if err != nil {
+		if delay, ok := RetryDelayOf(err); ok {
+			msg.RetryAfter(delay)
+		}
+		p.Source.Logger.Errorf("pipe [%s] transform failed, %v", p.Source.Queue, err)
+		return false
+	}
+	if out == nil {
+		return true // 丢弃消息
+	}
+	if out == msg {
+		// 复用源消息时清理源队列的重试状态
+		forward := *msg
+		forward.Retried, forward.retryDelay = 0, nil
+		out = &forward
+	}
+	if err := p.Target.SendCtx(msg.Context(), out); err != nil {
+		p.Source.Logger.Errorf("pipe [%s] forward to [%s] failed, %v", p.Source.Queue, p.Target.Topic, err)
+		return false
+	}
+	return true
+}
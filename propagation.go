@@ -0,0 +1,66 @@
+package bus
+
+import "context"
+
+// Propagator 上下文传播
+// 发送时将上下文中的值写入消息头, 处理时再从消息头恢复至上下文
+// 可用于跨服务传递链路追踪, 租户, 语言等信息
+type Propagator interface {
+	// Inject 将上下文中的值写入消息头
+	Inject(ctx context.Context, headers map[string]string)
+
+	// Extract 从消息头恢复上下文
+	Extract(ctx context.Context, headers map[string]string) context.Context
+}
+
+// ValuePropagator 传播上下文中的字符串值
+// 值通过context.WithValue以Key存入, 经由名为Header的消息头传递
+type ValuePropagator struct {
+	// Key 上下文中的键
+	Key interface{}
+
+	// Header 消息头名称
+	Header string
+}
+
+// PropagateValue 创建上下文字符串值的传播
+func PropagateValue(key interface{}, header string) *ValuePropagator {
+	return &ValuePropagator{Key: key, Header: header}
+}
+
+func (vp *ValuePropagator) Inject(ctx context.Context, headers map[string]string) {
+	if v, ok := ctx.Value(vp.Key).(string); ok && v != "" {
+		headers[vp.Header] = v
+	}
+}
+
+func (vp *ValuePropagator) Extract(ctx context.Context, headers map[string]string) context.Context {
+	if v, ok := headers[vp.Header]; ok {
+		return context.WithValue(ctx, vp.Key, v)
+	}
+	return ctx
+}
+
+// inject 依次将上下文写入消息头
+func inject(ctx context.Context, msg *Message, propagators []Propagator) {
+	if len(propagators) == 0 {
+		return
+	}
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string)
+	}
+	for _, p := range propagators {
+		p.Inject(ctx, msg.Headers)
+	}
+	if len(msg.Headers) == 0 {
+		msg.Headers = nil
+	}
+}
+
+// extract 依次从消息头恢复上下文
+func extract(ctx context.Context, msg *Message, propagators []Propagator) context.Context {
+	for _, p := range propagators {
+		ctx = p.Extract(ctx, msg.Headers)
+	}
+	return ctx
+}
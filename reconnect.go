@@ -0,0 +1,87 @@
+package bus
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/easy-bus/bus/clock"
+)
+
+// Reconnect 驱动重连策略
+// 队列级错误发生后按指数退避重新初始化驱动, 直至成功或达到最大尝试次数
+type Reconnect struct {
+	// InitialInterval 首次重连前的等待时长, 默认为100毫秒
+	InitialInterval time.Duration
+
+	// MaxInterval 等待时长上限, 默认为30秒
+	MaxInterval time.Duration
+
+	// Multiplier 每次失败后等待时长的倍数, 默认为2
+	Multiplier float64
+
+	// Jitter 等待时长的随机抖动比例, 取值[0, 1]
+	// 例如0.2表示在等待时长的 ±20% 范围内随机, 避免多个处理器同时重连
+	Jitter float64
+
+	// MaxAttempts 最大尝试次数, 若 <= 0 则不限制
+	// 超出后处理器将停止运行
+	MaxAttempts int
+
+	// OnReconnect 每次重连尝试后的回调
+	// err为空表示重连成功
+	OnReconnect func(queue string, attempt int, err error)
+
+	// Clock 时钟, 默认为系统时钟
+	Clock Clock
+}
+
+// Backoff 计算第attempt次重连前的等待时长
+func (r *Reconnect) Backoff(attempt int) time.Duration {
+	interval := r.InitialInterval
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	maxInterval := r.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = 30 * time.Second
+	}
+	multiplier := r.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+	wait := float64(interval)
+	for i := 1; i < attempt && wait < float64(maxInterval); i++ {
+		wait *= multiplier
+	}
+	if wait > float64(maxInterval) {
+		wait = float64(maxInterval)
+	}
+	if r.Jitter > 0 {
+		wait += wait * r.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(wait)
+}
+
+// run 按退避策略执行重连, 返回最后一次失败的错误
+// 上下文结束时返回上下文的错误
+func (r *Reconnect) run(ctx context.Context, queue string, connect func() error) error {
+	var err error
+	for attempt := 1; r.MaxAttempts <= 0 || attempt <= r.MaxAttempts; attempt++ {
+		timer := clock.Or(r.Clock).NewTimer(r.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+		err = connect()
+		if r.OnReconnect != nil {
+			r.OnReconnect(queue, attempt, err)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
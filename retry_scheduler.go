@@ -0,0 +1,224 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/easy-bus/bus/clock"
+)
+
+// RetryTask 一轮死信重试任务
+type RetryTask interface {
+	// Redrive 由处理器直接重新处理全部死信, 返回处理失败的数量
+	Redrive() (failed int)
+
+	// Requeue 将全部死信延迟重新投递至处理队列, 返回投递失败的数量
+	// 死信将由处理器随实时消息一同消费
+	Requeue(delay time.Duration) (failed int)
+}
+
+// RetryScheduler 死信重试调度
+// 决定失败消息何时以及以何种方式重新进入处理流程
+type RetryScheduler interface {
+	// Schedule 调度死信重试, 直至上下文结束
+	Schedule(ctx context.Context, task RetryTask)
+}
+
+// TickerScheduler 固定间隔的重试调度
+type TickerScheduler struct {
+	// Interval 重试间隔, 默认为1分钟
+	Interval time.Duration
+
+	// Backoff 退避策略
+	// failures 为连续存在处理失败的轮次
+	// 返回值为下一轮重试前在Interval之外额外等待的时长
+	Backoff func(failures int) time.Duration
+
+	// Clock 时钟, 默认为系统时钟
+	Clock Clock
+}
+
+func (ts *TickerScheduler) Schedule(ctx context.Context, task RetryTask) {
+	interval := ts.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	var failures int
+	timer := clock.Or(ts.Clock).NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+		}
+		var wait time.Duration
+		if task.Redrive() > 0 {
+			failures++
+			if ts.Backoff != nil {
+				wait = ts.Backoff(failures)
+			}
+		} else {
+			failures = 0
+		}
+		timer.Reset(interval + wait)
+	}
+}
+
+// DelayScheduler 基于驱动延迟队列的重试调度
+// 每轮将死信按延迟投递回处理队列, 由驱动决定重新处理的时机
+type DelayScheduler struct {
+	// Interval 投递间隔, 默认为1分钟
+	Interval time.Duration
+
+	// Delay 死信投递后的延迟时长
+	Delay time.Duration
+
+	// Clock 时钟, 默认为系统时钟
+	Clock Clock
+}
+
+func (ds *DelayScheduler) Schedule(ctx context.Context, task RetryTask) {
+	interval := ds.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	timer := clock.Or(ds.Clock).NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+			task.Requeue(ds.Delay)
+			timer.Reset(interval)
+		}
+	}
+}
+
+// CronScheduler 基于cron表达式的重试调度
+// 支持标准的五段式: 分 时 日 月 周, 以及 * , - / 语法
+type CronScheduler struct {
+	// Clock 时钟, 默认为系统时钟
+	Clock Clock
+
+	spec   string
+	fields [5]cronField
+}
+
+// cronField cron字段的取值集合
+type cronField struct {
+	any    bool
+	values map[int]bool
+}
+
+var cronBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+// ParseCron 解析cron表达式
+func ParseCron(spec string) (*CronScheduler, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("cron [%s] expect 5 fields, got %d", spec, len(parts))
+	}
+	cs := &CronScheduler{spec: spec}
+	for i, part := range parts {
+		field, err := parseCronField(part, cronBounds[i][0], cronBounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron [%s] field [%s] invalid, %v", spec, part, err)
+		}
+		cs.fields[i] = field
+	}
+	return cs, nil
+}
+
+func parseCronField(part string, min, max int) (cronField, error) {
+	field := cronField{any: part == "*", values: map[int]bool{}}
+	for _, item := range strings.Split(part, ",") {
+		step, lo, hi := 1, min, max
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return field, fmt.Errorf("bad step [%s]", item[i+1:])
+			}
+			step, item = n, item[:i]
+		}
+		if item != "*" {
+			var err error
+			if i := strings.Index(item, "-"); i >= 0 {
+				if lo, err = strconv.Atoi(item[:i]); err == nil {
+					hi, err = strconv.Atoi(item[i+1:])
+				}
+			} else if lo, err = strconv.Atoi(item); err == nil && step == 1 {
+				hi = lo
+			}
+			if err != nil {
+				return field, fmt.Errorf("bad value [%s]", item)
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return field, fmt.Errorf("value out of range [%d-%d]", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			field.values[v] = true
+		}
+	}
+	return field, nil
+}
+
+// Next 获取指定时间之后的下一个触发时间
+// 五年内无触发时间则返回零值
+func (cs *CronScheduler) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !cs.fields[3].values[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !cs.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !cs.fields[1].values[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !cs.fields[0].values[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchDay 日与周均有限定时满足其一即可
+func (cs *CronScheduler) matchDay(t time.Time) bool {
+	dom, dow := cs.fields[2], cs.fields[4]
+	if !dom.any && !dow.any {
+		return dom.values[t.Day()] || dow.values[int(t.Weekday())]
+	}
+	return dom.values[t.Day()] && dow.values[int(t.Weekday())]
+}
+
+func (cs *CronScheduler) Schedule(ctx context.Context, task RetryTask) {
+	clk := clock.Or(cs.Clock)
+	for {
+		now := clk.Now()
+		next := cs.Next(now)
+		if next.IsZero() {
+			return
+		}
+		timer := clk.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+		task.Redrive()
+	}
+}
@@ -0,0 +1,227 @@
+package bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// JSONSchema 基于JSON Schema的消息校验实现
+// 支持常用关键字: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern
+type JSONSchema struct {
+	root     map[string]interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// ParseJSONSchema 解析JSON Schema定义
+func ParseJSONSchema(schema []byte) (*JSONSchema, error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return nil, fmt.Errorf("json schema parse failed, %v", err)
+	}
+	js := &JSONSchema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := js.checkSchema("$", root); err != nil {
+		return nil, err
+	}
+	return js, nil
+}
+
+// Validate 校验消息内容是否符合定义
+func (js *JSONSchema) Validate(payload []byte) error {
+	var data interface{}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return fmt.Errorf("json schema validate failed, %v", err)
+	}
+	return js.validateSchema("$", js.root, data)
+}
+
+// checkSchema 预先编译定义中的正则表达式, 校验时复用
+func (js *JSONSchema) checkSchema(path string, schema map[string]interface{}) error {
+	if p, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("json schema %s pattern invalid, %v", path, err)
+		}
+		js.patterns[p] = re
+	}
+	if props, ok := schema["properties"].(map[string]interface{}); ok {
+		for name, prop := range props {
+			if sub, ok := prop.(map[string]interface{}); ok {
+				if err := js.checkSchema(path+"."+name, sub); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		return js.checkSchema(path+"[]", items)
+	}
+	return nil
+}
+
+// validateSchema 递归校验数据
+func (js *JSONSchema) validateSchema(path string, schema map[string]interface{}, data interface{}) error {
+	if t, ok := schema["type"]; ok && !matchType(t, data) {
+		return fmt.Errorf("%s expect type %v, got %s", path, t, typeOf(data))
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		var found bool
+		for _, v := range enum {
+			if jsonEqual(v, data) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s not in enum %v", path, enum)
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, data) {
+		return fmt.Errorf("%s expect const %v", path, c)
+	}
+	switch v := data.(type) {
+	case map[string]interface{}:
+		return js.validateObject(path, schema, v)
+	case []interface{}:
+		return js.validateArray(path, schema, v)
+	case string:
+		return js.validateString(path, schema, v)
+	case float64:
+		return validateNumber(path, schema, v)
+	}
+	return nil
+}
+
+func (js *JSONSchema) validateObject(path string, schema map[string]interface{}, obj map[string]interface{}) error {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if _, ok := obj[fmt.Sprint(name)]; !ok {
+				return fmt.Errorf("%s missing required property [%v]", path, name)
+			}
+		}
+	}
+	props, _ := schema["properties"].(map[string]interface{})
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names) // 保证错误信息稳定
+	for _, name := range names {
+		prop, ok := props[name]
+		if !ok {
+			if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+				return fmt.Errorf("%s unexpected property [%s]", path, name)
+			}
+			continue
+		}
+		if sub, ok := prop.(map[string]interface{}); ok {
+			if err := js.validateSchema(path+"."+name, sub, obj[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (js *JSONSchema) validateArray(path string, schema map[string]interface{}, arr []interface{}) error {
+	if n, ok := schema["minItems"].(float64); ok && float64(len(arr)) < n {
+		return fmt.Errorf("%s expect at least %v items", path, n)
+	}
+	if n, ok := schema["maxItems"].(float64); ok && float64(len(arr)) > n {
+		return fmt.Errorf("%s expect at most %v items", path, n)
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i := range arr {
+			if err := js.validateSchema(fmt.Sprintf("%s[%d]", path, i), items, arr[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (js *JSONSchema) validateString(path string, schema map[string]interface{}, str string) error {
+	length := float64(len([]rune(str)))
+	if n, ok := schema["minLength"].(float64); ok && length < n {
+		return fmt.Errorf("%s expect min length %v", path, n)
+	}
+	if n, ok := schema["maxLength"].(float64); ok && length > n {
+		return fmt.Errorf("%s expect max length %v", path, n)
+	}
+	if p, ok := schema["pattern"].(string); ok && !js.patterns[p].MatchString(str) {
+		return fmt.Errorf("%s not match pattern %s", path, p)
+	}
+	return nil
+}
+
+func validateNumber(path string, schema map[string]interface{}, num float64) error {
+	if n, ok := schema["minimum"].(float64); ok && num < n {
+		return fmt.Errorf("%s expect minimum %v", path, n)
+	}
+	if n, ok := schema["maximum"].(float64); ok && num > n {
+		return fmt.Errorf("%s expect maximum %v", path, n)
+	}
+	if n, ok := schema["exclusiveMinimum"].(float64); ok && num <= n {
+		return fmt.Errorf("%s expect exclusive minimum %v", path, n)
+	}
+	if n, ok := schema["exclusiveMaximum"].(float64); ok && num >= n {
+		return fmt.Errorf("%s expect exclusive maximum %v", path, n)
+	}
+	return nil
+}
+
+// matchType 判断数据是否符合type定义, 支持字符串或数组形式
+func matchType(t interface{}, data interface{}) bool {
+	switch v := t.(type) {
+	case string:
+		return matchTypeName(v, data)
+	case []interface{}:
+		for _, name := range v {
+			if matchTypeName(fmt.Sprint(name), data) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchTypeName(name string, data interface{}) bool {
+	actual := typeOf(data)
+	if name == "number" && actual == "integer" {
+		return true
+	}
+	return name == actual
+}
+
+// typeOf 获取数据的JSON类型名称
+func typeOf(data interface{}) string {
+	switch v := data.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return strings.ToLower(fmt.Sprintf("%T", data))
+}
+
+// jsonEqual 比较两个JSON值是否相等
+func jsonEqual(a, b interface{}) bool {
+	return string(encode(a)) == string(encode(b))
+}
@@ -2,6 +2,7 @@ package bus
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -29,6 +30,15 @@ type TxOptions struct {
 	// TxStorage 事务消息存储
 	TxStorage TXStorageInterface
 
+	// StaleAfter 事务消息滞留时长, 默认为Timeout的两倍
+	// 进程在预存与发送日志之间崩溃将导致日志丢失
+	// 存储时间超出该时长的消息将重新发送日志进行补偿, 应大于Timeout
+	StaleAfter time.Duration
+
+	// ScanInterval 滞留消息扫描间隔, 默认同StaleAfter
+	// 发送器启动时将立即扫描一次
+	ScanInterval time.Duration
+
 	// recordQueue 日志队列
 	recordQueue string
 }
@@ -51,13 +61,40 @@ func (to *TxOptions) prepare(topic string) {
 			return time.Duration(attempts) * 10 * time.Second
 		}
 	}
+	if to.StaleAfter <= 0 {
+		to.StaleAfter = 2 * to.Timeout
+	}
+	if to.ScanInterval <= 0 {
+		to.ScanInterval = to.StaleAfter
+	}
 	to.recordQueue = fmt.Sprintf("%s.tx-record", topic)
 }
 
+// Receipt 发布回执
+type Receipt struct {
+	// Topic 发布主题
+	Topic string
+
+	// BizUID 消息唯一标识
+	BizUID string
+
+	// MessageID 中间件分配的消息标识, 驱动不支持发布确认时为空
+	MessageID string
+
+	// Confirmed 是否已被中间件确认
+	Confirmed bool
+
+	// SentAt 发布时间, 未发布时为零值
+	SentAt time.Time
+}
+
 // Sender 发送器
 type Sender struct {
 	sync.Once
 
+	// Context 上下文, 用于停止异步发送
+	Context context.Context
+
 	// Topic 发送主题
 	Topic string
 
@@ -67,13 +104,67 @@ type Sender struct {
 	// Logger 异常日志
 	Logger LoggerInterface
 
+	// Version 发送的消息版本
+	// 未指定版本的消息将使用该版本
+	Version int
+
+	// Validator 消息校验
+	// 不合法的消息将被拒绝发布
+	Validator SchemaValidatorInterface
+
+	// Archive 消息归档
+	// 每条消息的发送结果都将被存档
+	Archive ArchiveInterface
+
+	// BlobStorage 大消息内容存储
+	// 内容超出ClaimThreshold的消息将内容存入该存储, 消息仅携带引用
+	BlobStorage BlobStorageInterface
+
+	// ClaimThreshold 消息内容存入BlobStorage的字节阈值, 默认为256KB
+	ClaimThreshold int
+
+	// RetainStorage 保留消息存储
+	// 设置后每个路由键最新发布成功的消息将被保留, 新订阅的处理器启动时立即获取
+	RetainStorage RetainStorageInterface
+
+	// Dedup 发送去重存储
+	// 设置后同一BizUID的消息在DedupWindow内仅发送一次, 重复发送直接返回成功
+	// 用于防止接口重试等原因对同一业务操作重复发送, 事务消息重复时本地事务不会执行
+	Dedup DedupStorageInterface
+
+	// DedupWindow 去重窗口时长, 默认为10分钟
+	DedupWindow time.Duration
+
 	// TxOptions 事务配置
 	TxOptions *TxOptions
 
+	// AsyncBuffer 异步发送队列容量
+	// 队列已满时SendAsync将阻塞, 默认为1024
+	AsyncBuffer int
+
+	// AsyncWorkers 异步发送协程数量, 默认为1
+	AsyncWorkers int
+
+	// DriverPool 驱动连接池
+	// 异步发送协程依次绑定池中的驱动实例, 为空时均使用Driver
+	DriverPool []DriverInterface
+
+	// Pipeline 流水线模式
+	// 开启后非事务的Send经由异步发送协程及驱动池发布, 并等待发送结果
+	Pipeline bool
+
+	// Propagators 上下文传播
+	// SendCtx 发送时将上下文中的值写入消息头
+	Propagators []Propagator
+
 	// ready 是否就绪
 	ready bool
 
 	txHandler *Handler
+
+	txScanner sync.WaitGroup
+
+	async asyncQueue
 }
 
 // Prepare 创建主题和日志队列
@@ -85,8 +176,31 @@ func (s *Sender) Prepare() *Sender {
 		if s.Logger == nil {
 			s.Logger = stderrLogger{}
 		}
-		if err := s.Driver.CreateTopic(s.Topic); err != nil {
-			throw("sender [%s] create topic error, %v", s.Topic, err)
+		if s.Validator == nil {
+			s.Validator = nullSchemaValidator{}
+		}
+		if s.Archive == nil {
+			s.Archive = nullArchive{}
+		}
+		if s.Context == nil {
+			s.Context = context.Background()
+		}
+		if s.ClaimThreshold <= 0 {
+			s.ClaimThreshold = 256 << 10
+		}
+		if s.DedupWindow <= 0 {
+			s.DedupWindow = 10 * time.Minute
+		}
+		if s.AsyncBuffer <= 0 {
+			s.AsyncBuffer = 1024
+		}
+		if s.AsyncWorkers <= 0 {
+			s.AsyncWorkers = 1
+		}
+		for _, driver := range append([]DriverInterface{s.Driver}, s.DriverPool...) {
+			if err := driver.CreateTopic(s.Topic); err != nil {
+				throw("sender [%s] create topic error, %v", s.Topic, err)
+			}
 		}
 		if s.TxOptions != nil {
 			s.TxOptions.prepare(s.Topic)
@@ -111,7 +225,7 @@ func (s *Sender) Prepare() *Sender {
 					decode(data, &msg)
 					if s.TxOptions.EnsureFunc(&msg) {
 						// 事务处理成功, 消息未发送
-						err = s.Driver.SendToTopic(s.Topic, data, msg.RouteKey)
+						err = s.publish(s.Driver, data, &msg, nil)
 						if err == nil {
 							s.txRemove(id)
 							return true
@@ -129,6 +243,10 @@ func (s *Sender) Prepare() *Sender {
 			}
 			s.txHandler.Prepare()
 			go s.txHandler.Run()
+			if _, ok := s.TxOptions.TxStorage.(StaleTXStorageInterface); ok {
+				s.txScanner.Add(1)
+				go s.txScan()
+			}
 		}
 		s.ready = true
 	})
@@ -138,60 +256,346 @@ func (s *Sender) Prepare() *Sender {
 // Send 发送消息
 // msg 发送的消息结构体
 // localTx 本地事务执行函数
-func (s *Sender) Send(msg *Message, localTx ...func() error) (err error) {
+func (s *Sender) Send(msg *Message, localTx ...func() error) error {
 	if s.ready == false {
 		throw("sender [%s] has not prepared", s.Topic)
 	}
+	if s.Pipeline && (len(localTx) == 0 || localTx[0] == nil) {
+		// 流水线模式, 经由异步发送协程发布并等待结果
+		done := make(chan error, 1)
+		s.SendAsync(msg, func(err error) { done <- err })
+		return <-done
+	}
+	return s.send(s.Driver, msg, nil, localTx...)
+}
+
+// SendCtx 发送消息, 并通过Propagators将ctx中的值写入消息头
+// 处理器配置相同的传播后可通过msg.Context()获取
+func (s *Sender) SendCtx(ctx context.Context, msg *Message, localTx ...func() error) error {
+	inject(ctx, msg, s.Propagators)
+	return s.Send(msg, localTx...)
+}
+
+// SendAt 定时发送消息, 消息在at之后方可被处理
+// 驱动支持定时投递时由驱动调度, 否则由处理器延迟至该时间再处理
+func (s *Sender) SendAt(msg *Message, at time.Time, localTx ...func() error) error {
+	MessageDeliverAt(at)(msg)
+	return s.Send(msg, localTx...)
+}
+
+// SendConfirmed 发送消息并返回发布回执
+// 驱动支持发布确认时回执包含中间件分配的消息标识
+// 事务消息发布失败转入补偿时, 回执为未确认状态
+func (s *Sender) SendConfirmed(msg *Message, localTx ...func() error) (*Receipt, error) {
+	if s.ready == false {
+		throw("sender [%s] has not prepared", s.Topic)
+	}
+	receipt := &Receipt{Topic: s.Topic, BizUID: msg.BizUID}
+	if err := s.send(s.Driver, msg, receipt, localTx...); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// send 使用指定驱动发送消息, receipt 不为空时记录发布回执
+func (s *Sender) send(driver DriverInterface, msg *Message, receipt *Receipt, localTx ...func() error) (err error) {
+	var outcome, start = OutcomeFailed, time.Now()
+	var marked bool
+	defer func() { s.archive(msg, start, outcome, err) }()
+	defer func() {
+		if marked && err != nil {
+			s.undedup(msg) // 发送失败(包括panic), 允许重新发送
+		}
+	}()
 	defer handlePanic(func(i interface{}) {
 		err = fmt.Errorf("sender [%s] panic: %v, call stack: \n%s", s.Topic, i, stackTrace(0))
 	})
+	if msg.Version == 0 {
+		msg.Version = s.Version
+	}
+	if err := s.Validator.Validate(msg.Payload); err != nil {
+		outcome = OutcomeRejected
+		return fmt.Errorf("sender [%s] validate failed, %v", s.Topic, err)
+	}
+	var duplicate bool
+	if marked, duplicate = s.dedup(msg); duplicate {
+		outcome = OutcomeDuplicate
+		return nil
+	}
+	data, err := s.marshal(msg)
+	if err != nil {
+		return err
+	}
 	if len(localTx) == 0 || localTx[0] == nil {
 		// 未使用事务, 直接发布至主题
-		if err := s.Driver.SendToTopic(s.Topic, encode(msg), msg.RouteKey); err != nil {
+		if err := s.publish(driver, data, msg, receipt); err != nil {
 			return fmt.Errorf("sender [%s] with route key [%s] failed, %v", s.Topic, msg.RouteKey, err)
 		}
+		outcome = OutcomeSent
 	} else if s.TxOptions == nil {
 		return fmt.Errorf("sender [%s] missing tx options", s.Topic)
 	} else {
-		data := encode(msg)
-		// 消息预发存储
-		id, err := s.TxOptions.TxStorage.Store(data)
+		id, err := s.prepareTx(driver, data)
 		if err != nil {
-			return fmt.Errorf("sender [%s] tx store failed, %v", s.Topic, err)
-		}
-		// 将操作日志发送至队列
-		err = s.Driver.SendToQueue(
-			s.TxOptions.recordQueue,
-			encode(MessageWithId(id, id, "")),
-			s.TxOptions.Timeout,
-		)
-		if err != nil {
-			return fmt.Errorf(
-				"sender [%s] send to queue [%s] with delay [%d] failed, %v",
-				s.Topic, s.TxOptions.recordQueue, s.TxOptions.Timeout, err,
-			)
+			return err
 		}
 		// 执行本地事务
 		if err := localTx[0](); err != nil {
 			s.txRemove(id) // 事务失败即可清理
+			outcome = OutcomeRollback
 			return err
 		}
-		// 此时无需关心消息是否发送成功, 可依靠日志补偿处理
-		if err := s.Driver.SendToTopic(s.Topic, data, msg.RouteKey); err != nil {
-			s.Logger.Errorf("sender [%s] with route key [%s] failed, %v", s.Topic, msg.RouteKey, err)
-		} else {
-			s.txRemove(id) // 发送成功即可清理
+		outcome = s.commitTx(driver, id, data, msg, receipt)
+	}
+	return nil
+}
+
+// PrepareSend 事务消息预发, 适用于自行管理事务生命周期的场景
+// 本地事务提交后调用Commit发布, 失败时调用Rollback撤销
+// 若均未调用, 消息将在TxOptions.Timeout后由EnsureFunc确认处理
+func (s *Sender) PrepareSend(msg *Message) (txID string, err error) {
+	if s.ready == false {
+		throw("sender [%s] has not prepared", s.Topic)
+	}
+	if s.TxOptions == nil {
+		return "", fmt.Errorf("sender [%s] missing tx options", s.Topic)
+	}
+	if msg.Version == 0 {
+		msg.Version = s.Version
+	}
+	if err := s.Validator.Validate(msg.Payload); err != nil {
+		s.archive(msg, time.Now(), OutcomeRejected, err)
+		return "", fmt.Errorf("sender [%s] validate failed, %v", s.Topic, err)
+	}
+	data, err := s.marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return s.prepareTx(s.Driver, data)
+}
+
+// dedup 发送去重, 返回是否已标记及是否重复
+// 去重存储异常时不做去重, 仍然发送
+func (s *Sender) dedup(msg *Message) (marked, duplicate bool) {
+	if s.Dedup == nil || msg.BizUID == "" {
+		return false, false
+	}
+	ok, err := s.Dedup.Mark(s.Topic+"."+msg.BizUID, s.DedupWindow)
+	if err != nil {
+		s.Logger.Errorf("sender [%s] dedup mark failed, %v", s.Topic, err)
+		return false, false
+	}
+	return ok, !ok
+}
+
+// undedup 移除去重标记
+func (s *Sender) undedup(msg *Message) {
+	if err := s.Dedup.Unmark(s.Topic + "." + msg.BizUID); err != nil {
+		s.Logger.Errorf("sender [%s] dedup unmark failed, %v", s.Topic, err)
+	}
+}
+
+// marshal 编码消息, 内容超出阈值时存入BlobStorage仅携带引用
+func (s *Sender) marshal(msg *Message) ([]byte, error) {
+	out := *msg
+	out.ClaimRef = "" // 转发已取回内容的消息时重新判断
+	if s.BlobStorage != nil && len(out.Payload) > s.ClaimThreshold {
+		ref, err := s.BlobStorage.Put(out.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("sender [%s] blob put failed, %v", s.Topic, err)
 		}
+		out.Payload, out.ClaimRef = nil, ref
+	}
+	return encode(&out), nil
+}
+
+// Commit 本地事务已提交, 发布预发的消息
+// 发布失败时依靠日志补偿处理, 不返回错误
+func (s *Sender) Commit(txID string) error {
+	data, msg, err := s.txFetch(txID)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	s.archive(msg, start, s.commitTx(s.Driver, txID, data, msg, nil), nil)
+	return nil
+}
+
+// Rollback 本地事务已回滚, 撤销预发的消息
+func (s *Sender) Rollback(txID string) error {
+	_, msg, err := s.txFetch(txID)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := s.TxOptions.TxStorage.Remove(txID); err != nil {
+		return fmt.Errorf("sender [%s] tx remove failed, %v", s.Topic, err)
 	}
+	s.archive(msg, start, OutcomeRollback, nil)
 	return nil
 }
 
+// prepareTx 消息预发存储, 并将操作日志发送至队列
+func (s *Sender) prepareTx(driver DriverInterface, data []byte) (string, error) {
+	id, err := s.TxOptions.TxStorage.Store(data)
+	if err != nil {
+		return "", fmt.Errorf("sender [%s] tx store failed, %v", s.Topic, err)
+	}
+	err = driver.SendToQueue(
+		s.TxOptions.recordQueue,
+		encode(MessageWithId(id, id, "")),
+		s.TxOptions.Timeout,
+	)
+	if err != nil {
+		return "", fmt.Errorf(
+			"sender [%s] send to queue [%s] with delay [%d] failed, %v",
+			s.Topic, s.TxOptions.recordQueue, s.TxOptions.Timeout, err,
+		)
+	}
+	return id, nil
+}
+
+// commitTx 发布预发的消息, 返回发送结果
+// 此时无需关心消息是否发送成功, 可依靠日志补偿处理
+func (s *Sender) commitTx(driver DriverInterface, id string, data []byte, msg *Message, receipt *Receipt) string {
+	if err := s.publish(driver, data, msg, receipt); err != nil {
+		s.Logger.Errorf("sender [%s] with route key [%s] failed, %v", s.Topic, msg.RouteKey, err)
+		return OutcomePending
+	}
+	s.txRemove(id) // 发送成功即可清理
+	return OutcomeSent
+}
+
+// txFetch 获取预发的消息
+func (s *Sender) txFetch(txID string) ([]byte, *Message, error) {
+	if s.TxOptions == nil {
+		return nil, nil, fmt.Errorf("sender [%s] missing tx options", s.Topic)
+	}
+	data, err := s.TxOptions.TxStorage.Fetch(txID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sender [%s] tx fetch failed, %v", s.Topic, err)
+	} else if data == nil {
+		return nil, nil, fmt.Errorf("sender [%s] tx [%s] not found", s.Topic, txID)
+	}
+	msg := new(Message)
+	decode(data, msg)
+	return data, msg, nil
+}
+
+// publish 发布至主题, 驱动支持时获取发布确认
+// 指定投递时间的消息在驱动支持时由驱动定时投递
+func (s *Sender) publish(driver DriverInterface, data []byte, msg *Message, receipt *Receipt) (err error) {
+	routeKey := msg.RouteKey
+	defer func() {
+		if err == nil {
+			s.retain(data, routeKey)
+		}
+	}()
+	caps := Capabilities(driver)
+	if sd, ok := driver.(ScheduleDriverInterface); ok && caps.Has(CapabilitySchedule) && msg.DeliverAt > time.Now().UnixNano()/int64(time.Millisecond) {
+		at := time.Unix(0, msg.DeliverAt*int64(time.Millisecond))
+		if err := sd.SendToTopicAt(s.Topic, data, routeKey, at); err != nil {
+			return err
+		}
+		if receipt != nil {
+			receipt.SentAt = time.Now()
+		}
+		return nil
+	}
+	if receipt == nil {
+		return driver.SendToTopic(s.Topic, data, routeKey)
+	}
+	if cd, ok := driver.(ConfirmDriverInterface); ok && caps.Has(CapabilityConfirm) {
+		id, err := cd.SendToTopicConfirmed(s.Topic, data, routeKey)
+		if err != nil {
+			return err
+		}
+		receipt.MessageID, receipt.Confirmed = id, true
+	} else if err := driver.SendToTopic(s.Topic, data, routeKey); err != nil {
+		return err
+	}
+	receipt.SentAt = time.Now()
+	return nil
+}
+
+// retain 保留路由键的最新消息, 失败仅记录不影响发送结果
+func (s *Sender) retain(data []byte, routeKey string) {
+	if s.RetainStorage == nil {
+		return
+	}
+	if err := s.RetainStorage.Store(s.Topic, routeKey, data); err != nil {
+		s.Logger.Errorf("sender [%s] retain with route key [%s] failed, %v", s.Topic, routeKey, err)
+	}
+}
+
 // Wait 等待退出
 func (s *Sender) Wait() {
+	s.async.wait()
 	if s.txHandler == nil {
 		return
 	}
 	s.txHandler.Wait()
+	s.txScanner.Wait()
+}
+
+// txScan 定时扫描滞留的事务消息, 重新发送日志进行补偿
+func (s *Sender) txScan() {
+	defer s.txScanner.Done()
+	ticker := time.NewTicker(s.TxOptions.ScanInterval)
+	defer ticker.Stop()
+	for {
+		s.txRecover()
+		select {
+		case <-s.TxOptions.Context.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// txRecover 重新发送滞留事务消息的日志, 返回补偿的数量
+// 补偿后刷新存储时间, 日志未被消费时每个滞留周期至多补偿一次
+func (s *Sender) txRecover() (recovered int) {
+	storage, ok := s.TxOptions.TxStorage.(StaleTXStorageInterface)
+	if !ok {
+		return 0
+	}
+	ids, err := storage.Stale(time.Now().Add(-s.TxOptions.StaleAfter))
+	if err != nil {
+		s.Logger.Errorf("sender [%s] tx scan failed, %v", s.Topic, err)
+		return 0
+	}
+	for _, id := range ids {
+		err := s.Driver.SendToQueue(s.TxOptions.recordQueue, encode(MessageWithId(id, id, "")), 0)
+		if err != nil {
+			s.Logger.Errorf("sender [%s] tx recover [%s] failed, %v", s.Topic, id, err)
+			continue
+		}
+		if err := storage.Touch(id); err != nil {
+			s.Logger.Errorf("sender [%s] tx touch [%s] failed, %v", s.Topic, id, err)
+		}
+		recovered++
+	}
+	return recovered
+}
+
+// archive 归档消息及发送结果
+func (s *Sender) archive(msg *Message, start time.Time, outcome string, err error) {
+	data, _ := json.Marshal(msg)
+	record := &ArchiveRecord{
+		Source:     SourceSender,
+		Name:       s.Topic,
+		Data:       data,
+		Outcome:    outcome,
+		StartedAt:  start,
+		FinishedAt: time.Now(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if err := s.Archive.Store(record); err != nil {
+		s.Logger.Errorf("sender [%s] archive failed, %v", s.Topic, err)
+	}
 }
 
 // txRemove 内部封装,便于使用
@@ -0,0 +1,92 @@
+package bus
+
+import (
+	"fmt"
+	"sync"
+)
+
+// asyncItem 异步发送项
+type asyncItem struct {
+	msg      *Message
+	callback func(err error)
+}
+
+// asyncQueue 异步发送队列
+type asyncQueue struct {
+	sync.Once
+	sync.RWMutex
+	sync.WaitGroup
+	items  chan asyncItem
+	closed bool
+}
+
+// wait 等待异步发送队列处理完毕
+func (aq *asyncQueue) wait() {
+	aq.RLock()
+	started := aq.items != nil
+	aq.RUnlock()
+	if started {
+		aq.Wait()
+	}
+}
+
+// SendAsync 异步发送消息
+// 消息进入内部发送队列后立即返回, 发送结果通过callback回调
+// Context结束后队列中剩余的消息仍会发送完毕, 之后的调用将直接回调错误
+func (s *Sender) SendAsync(msg *Message, callback func(err error)) {
+	if s.ready == false {
+		throw("sender [%s] has not prepared", s.Topic)
+	}
+	s.async.Do(s.startAsync)
+	s.async.RLock()
+	defer s.async.RUnlock()
+	if s.async.closed {
+		s.callback(callback, fmt.Errorf("sender [%s] async queue closed", s.Topic))
+		return
+	}
+	s.async.items <- asyncItem{msg: msg, callback: callback}
+}
+
+// startAsync 启动异步发送协程
+// 每个协程绑定驱动池中的一个驱动实例, 实现发布的并行流水线
+func (s *Sender) startAsync() {
+	s.async.Lock()
+	s.async.items = make(chan asyncItem, s.AsyncBuffer)
+	s.async.Unlock()
+	for i := 0; i < s.AsyncWorkers; i++ {
+		driver := s.Driver
+		if len(s.DriverPool) > 0 {
+			driver = s.DriverPool[i%len(s.DriverPool)]
+		}
+		s.async.Add(1)
+		goroutine(func() {
+			defer s.async.Done()
+			for item := range s.async.items {
+				s.callback(item.callback, s.send(driver, item.msg, nil))
+			}
+		})
+	}
+	s.async.Add(1)
+	goroutine(func() {
+		defer s.async.Done()
+		<-s.Context.Done()
+		s.async.Lock()
+		s.async.closed = true
+		close(s.async.items) // 关闭队列, 发送协程处理完剩余消息后退出
+		s.async.Unlock()
+	})
+}
+
+// callback 执行回调, 屏蔽回调函数的panic
+func (s *Sender) callback(fn func(err error), err error) {
+	if fn == nil {
+		if err != nil {
+			s.Logger.Errorf("sender [%s] async send failed, %v", s.Topic, err)
+		}
+		return
+	}
+	defer handlePanic(func(i interface{}) {
+		s.Logger.Errorf("sender [%s] async callback panic: %v, call stack: \n%s", s.Topic, i, stackTrace(0))
+	})
+	fn(err)
+}
@@ -2,6 +2,10 @@ package simple
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/easy-bus/bus"
 )
@@ -31,15 +35,83 @@ func StartUp(
 }
 
 func ShutDown() {
-	cancelGroup.exec()  // 发送cancel取消
-	senderGroup.wait()  // 等待sender结束
-	handlerGroup.wait() // 等待handler结束
+	sds, hds, ccs := snapshot(false)
+	ccs.exec() // 发送cancel取消
+	sds.wait() // 等待sender结束
+	hds.wait() // 等待handler结束
+}
+
+// ShutDownCtx 在上下文期限内关闭
+// 超时返回未能退出的发送器及处理器, 并强制释放全部登记资源
+// 注意: 超时的发送器及处理器仍在运行, 其等待协程于它们退出后结束
+func ShutDownCtx(ctx context.Context) error {
+	sds, hds, ccs := snapshot(true)
+	ccs.exec() // 发送cancel取消
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	pending := make(map[interface{}]string)
+	wait := func(key interface{}, name string, fn func()) {
+		pending[key] = name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+			mu.Lock()
+			delete(pending, key)
+			mu.Unlock()
+		}()
+	}
+	mu.Lock()
+	for _, s := range sds {
+		wait(s, "sender "+s.Topic, s.Wait)
+	}
+	for _, h := range hds {
+		wait(h, "handler "+h.Queue, h.Wait)
+	}
+	mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(pending))
+	for _, name := range pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("shutdown %v, [%s] not stopped", ctx.Err(), strings.Join(names, ", "))
+}
+
+// groupMu 保护发送器, 处理器及取消函数的登记
+var groupMu sync.Mutex
+
+// snapshot 获取登记的发送器, 处理器及取消函数
+// 若release为true则同时释放登记
+func snapshot(release bool) (senders, handlers, cancels) {
+	groupMu.Lock()
+	defer groupMu.Unlock()
+	sds, hds, ccs := senderGroup, handlerGroup, cancelGroup
+	if release {
+		senderGroup = make(senders, 0)
+		handlerGroup = make(handlers, 0)
+		cancelGroup = make(cancels, 0)
+	}
+	return sds, hds, ccs
 }
 
 // senders 发送器集合
 type senders []*bus.Sender
 
 func (sds *senders) add(sender *bus.Sender) *bus.Sender {
+	groupMu.Lock()
+	defer groupMu.Unlock()
 	*sds = append(*sds, sender)
 	return sender
 }
@@ -54,6 +126,8 @@ func (sds senders) wait() {
 type handlers []*bus.Handler
 
 func (hds *handlers) add(handler *bus.Handler) *bus.Handler {
+	groupMu.Lock()
+	defer groupMu.Unlock()
 	*hds = append(*hds, handler)
 	return handler
 }
@@ -67,14 +141,16 @@ func (hds handlers) wait() {
 // cancels 退出Bus相关协程的函数集合
 type cancels []context.CancelFunc
 
-func (ccs *cancels) exec() {
-	for _, cancel := range *ccs {
+func (ccs cancels) exec() {
+	for _, cancel := range ccs {
 		cancel()
 	}
 }
 
 func (ccs *cancels) newCtx() context.Context {
 	ctx, cancel := context.WithCancel(context.TODO())
+	groupMu.Lock()
+	defer groupMu.Unlock()
 	*ccs = append(*ccs, cancel)
 	return ctx
 }
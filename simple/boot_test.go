@@ -0,0 +1,40 @@
+package simple
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/easy-bus/bus"
+	"github.com/easy-bus/bus/memdriver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutDownCtx(t *testing.T) {
+	drv := &memdriver.Driver{Sync: true} // 同步模式下处理器等待消息处理结束
+	assert.Nil(t, drv.CreateTopic("t"))
+	StartUp(drv, nil, nil, nil, nil)
+	block, entered := make(chan struct{}), make(chan struct{})
+	defer close(block)
+	Handler("q1", "t", "r", func(ctx context.Context, msg *bus.Message) error {
+		close(entered)
+		<-block // 永不返回, 直至测试结束
+		return nil
+	}, nil)
+	Handler("q2", "t", "r", func(ctx context.Context, msg *bus.Message) error {
+		return nil
+	}, nil)
+	assert.Nil(t, driver.SendToQueue("q1", []byte(`{}`), 0))
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := ShutDownCtx(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+		assert.Contains(t, err.Error(), "[handler q1]") // q2 已正常退出
+	}
+	assert.Len(t, handlerGroup, 0)
+	assert.Len(t, cancelGroup, 0)
+	assert.Nil(t, ShutDownCtx(context.Background()))
+}
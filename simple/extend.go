@@ -1,5 +1,13 @@
 package simple
 
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
 type Extend map[string]interface{}
 
 func (ex Extend) Int(key string) int {
@@ -7,11 +15,10 @@ func (ex Extend) Int(key string) int {
 }
 
 func (ex Extend) Ints(key string) []int {
-	iv := make([]int, 0)
-	ex.sliceRange(key, func(i interface{}) {
-		iv = append(iv, int(i.(float64)))
-	})
-	return iv
+	return must(trySlice(ex, key, func(v interface{}) (int, error) {
+		f, err := asNumber(v)
+		return int(f), err
+	}))
 }
 
 func (ex Extend) Int32(key string) int32 {
@@ -19,11 +26,10 @@ func (ex Extend) Int32(key string) int32 {
 }
 
 func (ex Extend) Int32s(key string) []int32 {
-	iv := make([]int32, 0)
-	ex.sliceRange(key, func(i interface{}) {
-		iv = append(iv, int32(i.(float64)))
-	})
-	return iv
+	return must(trySlice(ex, key, func(v interface{}) (int32, error) {
+		f, err := asNumber(v)
+		return int32(f), err
+	}))
 }
 
 func (ex Extend) Int64(key string) int64 {
@@ -31,11 +37,10 @@ func (ex Extend) Int64(key string) int64 {
 }
 
 func (ex Extend) Int64s(key string) []int64 {
-	iv := make([]int64, 0)
-	ex.sliceRange(key, func(i interface{}) {
-		iv = append(iv, int64(i.(float64)))
-	})
-	return iv
+	return must(trySlice(ex, key, func(v interface{}) (int64, error) {
+		f, err := asNumber(v)
+		return int64(f), err
+	}))
 }
 
 func (ex Extend) Float64(key string) float64 {
@@ -46,11 +51,7 @@ func (ex Extend) Float64(key string) float64 {
 }
 
 func (ex Extend) Float64s(key string) []float64 {
-	fv := make([]float64, 0)
-	ex.sliceRange(key, func(i interface{}) {
-		fv = append(fv, i.(float64))
-	})
-	return fv
+	return must(trySlice(ex, key, asNumber))
 }
 
 func (ex Extend) Extend(key string) Extend {
@@ -61,11 +62,7 @@ func (ex Extend) Extend(key string) Extend {
 }
 
 func (ex Extend) Extends(key string) []Extend {
-	ev := make([]Extend, 0)
-	ex.sliceRange(key, func(i interface{}) {
-		ev = append(ev, i.(map[string]interface{}))
-	})
-	return ev
+	return must(trySlice(ex, key, asExtend))
 }
 
 func (ex Extend) String(key string) string {
@@ -76,18 +73,238 @@ func (ex Extend) String(key string) string {
 }
 
 func (ex Extend) Strings(key string) []string {
-	sv := make([]string, 0)
-	ex.sliceRange(key, func(i interface{}) {
-		sv = append(sv, i.(string))
+	return must(trySlice(ex, key, asString))
+}
+
+// Bind 将扩展数据解码至目标结构体, 字段映射遵循json标签
+func (ex Extend) Bind(dest interface{}) error {
+	data, err := json.Marshal(ex)
+	if err != nil {
+		return fmt.Errorf("extend bind failed, %v", err)
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("extend bind failed, %v", err)
+	}
+	return nil
+}
+
+func (ex Extend) Bool(key string) bool {
+	v, err := ex.TryBool(key)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Time 支持RFC3339格式的字符串
+func (ex Extend) Time(key string) time.Time {
+	v, err := ex.TryTime(key)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Duration 支持纳秒数值及"1m30s"格式的字符串
+func (ex Extend) Duration(key string) time.Duration {
+	v, err := ex.TryDuration(key)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// TryX 系列在类型不符时返回错误而非panic, 键不存在时返回零值
+
+// 整数系列拒绝非整数及超出类型范围的数值
+
+func (ex Extend) TryInt(key string) (int, error) {
+	v, err := ex.tryInteger(key, strconv.IntSize)
+	return int(v), err
+}
+
+func (ex Extend) TryInts(key string) ([]int, error) {
+	return trySlice(ex, key, func(v interface{}) (int, error) {
+		i, err := asInteger(v, strconv.IntSize)
+		return int(i), err
+	})
+}
+
+func (ex Extend) TryInt32(key string) (int32, error) {
+	v, err := ex.tryInteger(key, 32)
+	return int32(v), err
+}
+
+func (ex Extend) TryInt32s(key string) ([]int32, error) {
+	return trySlice(ex, key, func(v interface{}) (int32, error) {
+		i, err := asInteger(v, 32)
+		return int32(i), err
 	})
-	return sv
 }
 
-func (ex Extend) sliceRange(key string, fn func(interface{})) {
+func (ex Extend) TryInt64(key string) (int64, error) {
+	return ex.tryInteger(key, 64)
+}
+
+func (ex Extend) TryInt64s(key string) ([]int64, error) {
+	return trySlice(ex, key, func(v interface{}) (int64, error) {
+		return asInteger(v, 64)
+	})
+}
+
+func (ex Extend) tryInteger(key string, bits int) (int64, error) {
+	if v, ok := ex[key]; ok {
+		i, err := asInteger(v, bits)
+		if err != nil {
+			return 0, fmt.Errorf("extend key [%s] %v", key, err)
+		}
+		return i, nil
+	}
+	return 0, nil
+}
+
+func (ex Extend) TryFloat64(key string) (float64, error) {
+	if v, ok := ex[key]; ok {
+		if f, ok := v.(float64); ok {
+			return f, nil
+		}
+		return 0, ex.typeError(key, "number")
+	}
+	return 0, nil
+}
+
+func (ex Extend) TryFloat64s(key string) ([]float64, error) {
+	return trySlice(ex, key, asNumber)
+}
+
+func (ex Extend) TryString(key string) (string, error) {
 	if v, ok := ex[key]; ok {
-		vs := v.([]interface{})
-		for i := range vs {
-			fn(vs[i])
+		if s, ok := v.(string); ok {
+			return s, nil
 		}
+		return "", ex.typeError(key, "string")
+	}
+	return "", nil
+}
+
+func (ex Extend) TryStrings(key string) ([]string, error) {
+	return trySlice(ex, key, asString)
+}
+
+func (ex Extend) TryBool(key string) (bool, error) {
+	if v, ok := ex[key]; ok {
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return false, ex.typeError(key, "bool")
+	}
+	return false, nil
+}
+
+func (ex Extend) TryTime(key string) (time.Time, error) {
+	s, err := ex.TryString(key)
+	if err != nil || s == "" {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("extend key [%s] invalid time, %v", key, err)
+	}
+	return t, nil
+}
+
+func (ex Extend) TryDuration(key string) (time.Duration, error) {
+	switch v := ex[key].(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return time.Duration(v), nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("extend key [%s] invalid duration, %v", key, err)
+		}
+		return d, nil
+	}
+	return 0, ex.typeError(key, "duration")
+}
+
+func (ex Extend) TryExtend(key string) (Extend, error) {
+	if v, ok := ex[key]; ok {
+		if m, ok := v.(map[string]interface{}); ok {
+			return m, nil
+		}
+		return Extend{}, ex.typeError(key, "object")
+	}
+	return Extend{}, nil
+}
+
+func (ex Extend) TryExtends(key string) ([]Extend, error) {
+	return trySlice(ex, key, asExtend)
+}
+
+func (ex Extend) typeError(key, expect string) error {
+	return fmt.Errorf("extend key [%s] expect %s, got %T", key, expect, ex[key])
+}
+
+// trySlice 逐个转换数组元素, 键不存在时返回空切片
+func trySlice[T any](ex Extend, key string, conv func(interface{}) (T, error)) ([]T, error) {
+	out := make([]T, 0)
+	v, ok := ex[key]
+	if !ok {
+		return out, nil
+	}
+	vs, ok := v.([]interface{})
+	if !ok {
+		return nil, ex.typeError(key, "array")
+	}
+	for i := range vs {
+		e, err := conv(vs[i])
+		if err != nil {
+			return nil, fmt.Errorf("extend key [%s] index %d %v", key, i, err)
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func asNumber(v interface{}) (float64, error) {
+	if f, ok := v.(float64); ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("expect number, got %T", v)
+}
+
+// asInteger 转换为bits位有符号整数, 拒绝小数, NaN及溢出
+func asInteger(v interface{}, bits int) (int64, error) {
+	f, err := asNumber(v)
+	if err != nil {
+		return 0, err
+	}
+	limit := math.Ldexp(1, bits-1)
+	if f != math.Trunc(f) || f < -limit || f >= limit {
+		return 0, fmt.Errorf("%v is not an int%d", f, bits)
+	}
+	return int64(f), nil
+}
+
+func asString(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("expect string, got %T", v)
+}
+
+func asExtend(v interface{}) (Extend, error) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("expect object, got %T", v)
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
 	}
+	return v
 }
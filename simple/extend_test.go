@@ -0,0 +1,60 @@
+package simple
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtendTry(t *testing.T) {
+	var ex Extend
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"int": 3, "frac": 1.5, "big": 3000000000, "huge": 1e19,
+		"ints": [1, 2], "bad_ints": [1, 2.5], "mixed": [1, "a"],
+		"strs": ["a", "b"], "objs": [{"a": 1}], "obj": {"a": 1}
+	}`), &ex))
+
+	i, err := ex.TryInt("int")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, i)
+	_, err = ex.TryInt("frac")
+	assert.ErrorContains(t, err, "extend key [frac] 1.5 is not an int")
+	_, err = ex.TryInt32("big")
+	assert.EqualError(t, err, "extend key [big] 3e+09 is not an int32")
+	i64, err := ex.TryInt64("big")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3000000000), i64)
+	_, err = ex.TryInt64("huge")
+	assert.Error(t, err)
+	_, err = ex.TryInt("obj")
+	assert.EqualError(t, err, "extend key [obj] expect number, got map[string]interface {}")
+
+	is, err := ex.TryInts("ints")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, is)
+	_, err = ex.TryInt32s("bad_ints")
+	assert.EqualError(t, err, "extend key [bad_ints] index 1 2.5 is not an int32")
+	_, err = ex.TryInt64s("mixed")
+	assert.EqualError(t, err, "extend key [mixed] index 1 expect number, got string")
+	_, err = ex.TryFloat64s("int")
+	assert.EqualError(t, err, "extend key [int] expect array, got float64")
+	ss, err := ex.TryStrings("strs")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, ss)
+	_, err = ex.TryStrings("ints")
+	assert.Error(t, err)
+	es, err := ex.TryExtends("objs")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, es[0].Int("a"))
+	_, err = ex.TryExtends("strs")
+	assert.EqualError(t, err, "extend key [strs] index 0 expect object, got string")
+
+	is, err = ex.TryInts("missing")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{}, is)
+
+	assert.Equal(t, []int{1, 2}, ex.Ints("bad_ints")) // 非Try版本保持截断
+	assert.PanicsWithError(t, "extend key [mixed] index 1 expect number, got string", func() { ex.Ints("mixed") })
+	assert.Panics(t, func() { ex.Strings("obj") })
+}
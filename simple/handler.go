@@ -27,7 +27,11 @@ func Handler(
 		DLStorage:  dlStorage,
 		Idempotent: idempotent,
 		HandleFunc: func(msg *bus.Message) bool {
-			return handler(ctx, msg) == nil
+			err := handler(ctx, msg)
+			if delay, ok := bus.RetryDelayOf(err); ok {
+				msg.RetryAfter(delay) // 错误指定了重试延迟
+			}
+			return err == nil
 		},
 		EnsureFunc: func(msg *bus.Message) bool {
 			return ensure == nil || ensure(ctx, msg)
@@ -47,14 +51,15 @@ func Handler(
 type commonEnsure func(ctx context.Context, id string) bool
 type commonHandler func(ctx context.Context, id string) error
 
+// Deprecated: 请使用 RunTypedHandler[Common]
 func RunCommonHandler(topic, routeKey, queue string, handler commonHandler, ensure commonEnsure, opts ...bus.HandlerOpt) *bus.Handler {
-	return Handler(
-		fmt.Sprintf("%s.%s", topic, queue), topic, routeKey,
-		func(ctx context.Context, message *bus.Message) error {
-			return handler(ctx, LoadCommon(message).ID)
+	return RunTypedHandler(
+		topic, routeKey, queue,
+		func(ctx context.Context, evt Common) error {
+			return handler(ctx, evt.ID)
 		},
-		func(ctx context.Context, message *bus.Message) bool {
-			return ensure == nil || ensure(ctx, LoadCommon(message).ID)
+		func(ctx context.Context, evt Common) bool {
+			return ensure == nil || ensure(ctx, evt.ID)
 		},
 		opts...,
 	)
@@ -64,15 +69,14 @@ func RunCommonHandler(topic, routeKey, queue string, handler commonHandler, ensu
 type commonExEnsure func(ctx context.Context, id string, ex Extend) bool
 type commonExHandler func(ctx context.Context, id string, ex Extend) error
 
+// Deprecated: 请使用 RunTypedHandler[CommonEX]
 func RunCommonExHandler(topic, routeKey, queue string, handler commonExHandler, ensure commonExEnsure, opts ...bus.HandlerOpt) *bus.Handler {
-	return Handler(
-		fmt.Sprintf("%s.%s", topic, queue), topic, routeKey,
-		func(ctx context.Context, message *bus.Message) error {
-			var evt = LoadCommonEx(message)
+	return RunTypedHandler(
+		topic, routeKey, queue,
+		func(ctx context.Context, evt CommonEX) error {
 			return handler(ctx, evt.ID, evt.EX)
 		},
-		func(ctx context.Context, message *bus.Message) bool {
-			var evt = LoadCommonEx(message)
+		func(ctx context.Context, evt CommonEX) bool {
 			return ensure == nil || ensure(ctx, evt.ID, evt.EX)
 		},
 		opts...,
@@ -83,15 +87,14 @@ func RunCommonExHandler(topic, routeKey, queue string, handler commonExHandler,
 type batchExEnsure func(ctx context.Context, id []string, ex Extend) bool
 type batchExHandler func(ctx context.Context, id []string, ex Extend) error
 
+// Deprecated: 请使用 RunTypedHandler[BatchEX]
 func RunBatchExHandler(topic, routeKey, queue string, handler batchExHandler, ensure batchExEnsure, opts ...bus.HandlerOpt) *bus.Handler {
-	return Handler(
-		fmt.Sprintf("%s.%s", topic, queue), topic, routeKey,
-		func(ctx context.Context, message *bus.Message) error {
-			var evt = LoadBatchEx(message)
+	return RunTypedHandler(
+		topic, routeKey, queue,
+		func(ctx context.Context, evt BatchEX) error {
 			return handler(ctx, evt.IDS, evt.EX)
 		},
-		func(ctx context.Context, message *bus.Message) bool {
-			var evt = LoadBatchEx(message)
+		func(ctx context.Context, evt BatchEX) bool {
 			return ensure == nil || ensure(ctx, evt.IDS, evt.EX)
 		},
 		opts...,
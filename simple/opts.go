@@ -0,0 +1,33 @@
+package simple
+
+import "github.com/easy-bus/bus"
+
+// WithDriver 处理器使用独立的驱动实例
+func WithDriver(drv bus.DriverInterface) bus.HandlerOpt { return bus.WithDriver(drv) }
+
+// WithDLStorage 处理器使用独立的死信存储
+func WithDLStorage(dls bus.DLStorageInterface) bus.HandlerOpt { return bus.WithDLStorage(dls) }
+
+// WithIdempotent 处理器使用独立的幂等实现
+func WithIdempotent(ide bus.IdempotentInterface) bus.HandlerOpt { return bus.WithIdempotent(ide) }
+
+// WithLogger 处理器使用独立的异常日志
+func WithLogger(log bus.LoggerInterface) bus.HandlerOpt { return bus.WithLogger(log) }
+
+// SenderOpt 发送器配置项
+type SenderOpt func(s *bus.Sender)
+
+// SenderDriver 发送器使用独立的驱动实例
+func SenderDriver(drv bus.DriverInterface) SenderOpt {
+	return func(s *bus.Sender) { s.Driver = drv }
+}
+
+// SenderTxStorage 发送器使用独立的事务消息存储
+func SenderTxStorage(txs bus.TXStorageInterface) SenderOpt {
+	return func(s *bus.Sender) { s.TxOptions.TxStorage = txs }
+}
+
+// SenderLogger 发送器使用独立的异常日志
+func SenderLogger(log bus.LoggerInterface) SenderOpt {
+	return func(s *bus.Sender) { s.Logger = log }
+}
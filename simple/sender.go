@@ -6,7 +6,7 @@ import (
 	"github.com/easy-bus/bus"
 )
 
-func Sender(topic string, ensure func(*bus.Message) bool, timeout time.Duration) *bus.Sender {
+func Sender(topic string, ensure func(*bus.Message) bool, timeout time.Duration, opts ...SenderOpt) *bus.Sender {
 	s := &bus.Sender{
 		Topic:  topic,
 		Driver: driver,
@@ -23,5 +23,8 @@ func Sender(topic string, ensure func(*bus.Message) bool, timeout time.Duration)
 			},
 		},
 	}
+	for _, opt := range opts {
+		opt(s) // set option
+	}
 	return senderGroup.add(s.Prepare())
 }
@@ -11,8 +11,8 @@ type Topic string
 
 func (t Topic) Sender(
 	ensure func(*bus.Message) bool,
-	timeout time.Duration) *bus.Sender {
-	return Sender(string(t), ensure, timeout)
+	timeout time.Duration, opts ...SenderOpt) *bus.Sender {
+	return Sender(string(t), ensure, timeout, opts...)
 }
 
 func (t Topic) Handler(
@@ -0,0 +1,35 @@
+package simple
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/easy-bus/bus"
+)
+
+// RunTypedHandler 类型安全的处理器
+// 消息内容自动解码为T后交由回调处理, 无法解码的消息将进入死信存储
+func RunTypedHandler[T any](
+	topic, routeKey, queue string,
+	handler func(ctx context.Context, payload T) error,
+	ensure func(ctx context.Context, payload T) bool,
+	opts ...bus.HandlerOpt,
+) *bus.Handler {
+	return Handler(
+		fmt.Sprintf("%s.%s", topic, queue), topic, routeKey,
+		func(ctx context.Context, message *bus.Message) error {
+			return handler(ctx, LoadTyped[T](message))
+		},
+		func(ctx context.Context, message *bus.Message) bool {
+			return ensure == nil || ensure(ctx, LoadTyped[T](message))
+		},
+		opts...,
+	)
+}
+
+// LoadTyped 将消息内容解码为T
+func LoadTyped[T any](msg *bus.Message) T {
+	var payload T
+	msg.Scan(&payload)
+	return payload
+}
@@ -0,0 +1,309 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/websocket"
+)
+
+// SlowPolicy 慢连接的处理策略
+type SlowPolicy int
+
+const (
+	// SlowDrop 连接缓冲已满时丢弃新消息
+	SlowDrop SlowPolicy = iota
+	// SlowDisconnect 连接缓冲已满时断开连接
+	SlowDisconnect
+)
+
+// BridgeEvent 推送给连接的事件
+// 消息内容为合法JSON时置于Payload, 否则置于Raw并以base64编码
+type BridgeEvent struct {
+	Topic    string          `json:"topic"`
+	RouteKey string          `json:"route_key,omitempty"`
+	ID       string          `json:"id"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Raw      []byte          `json:"raw,omitempty"`
+}
+
+// StreamBridge 主题订阅桥接
+// 通过SSE或WebSocket向浏览器等客户端推送指定主题的实时消息
+// 客户端以查询参数 topic 指定主题, route_key 指定路由键(可重复, 默认为空)
+// 桥接按连接的路由键动态订阅主题, 无连接时取消订阅, 连接建立前的消息不会补发
+type StreamBridge struct {
+	sync.Once
+	Context context.Context
+
+	// Queue 桥接队列名称前缀, 每个主题使用 Queue.主题 作为队列
+	// 多个桥接实例需使用不同的前缀
+	Queue string
+
+	// Topics 允许订阅的主题
+	Topics []string
+
+	// Driver 驱动实例
+	Driver DriverInterface
+
+	// Logger 异常日志
+	Logger LoggerInterface
+
+	// Buffer 每个连接的缓冲容量, 默认为64
+	Buffer int
+
+	// SlowPolicy 连接缓冲已满时的处理策略, 默认丢弃新消息
+	SlowPolicy SlowPolicy
+
+	handlers []*Handler
+	dropped  uint64
+	mu       sync.Mutex
+	refs     map[string]map[string]int
+	conns    map[string]map[*bridgeConn]bool
+}
+
+// bridgeConn 桥接连接
+type bridgeConn struct {
+	topic     string
+	routeKeys map[string]bool
+	events    chan *BridgeEvent
+	closed    chan struct{}
+	once      sync.Once
+}
+
+// close 关闭连接
+func (bc *bridgeConn) close() { bc.once.Do(func() { close(bc.closed) }) }
+
+// Prepare 准备就绪
+func (sb *StreamBridge) Prepare() *StreamBridge {
+	sb.Do(func() {
+		if sb.Queue == "" {
+			throw("the stream bridge missing queue name")
+		}
+		if sb.Driver == nil {
+			throw("the stream bridge [%s] missing driver instance", sb.Queue)
+		}
+		if len(sb.Topics) == 0 {
+			throw("the stream bridge [%s] missing topics", sb.Queue)
+		}
+		if sb.Logger == nil {
+			sb.Logger = stderrLogger{}
+		}
+		if sb.Buffer <= 0 {
+			sb.Buffer = 64
+		}
+		sb.refs = make(map[string]map[string]int)
+		sb.conns = make(map[string]map[*bridgeConn]bool)
+		for _, topic := range sb.Topics {
+			topic := topic
+			sb.refs[topic] = make(map[string]int)
+			sb.conns[topic] = make(map[*bridgeConn]bool)
+			h := &Handler{
+				Context:    sb.Context,
+				Queue:      sb.queue(topic),
+				Driver:     sb.Driver,
+				Logger:     sb.Logger,
+				HandleFunc: func(msg *Message) bool { sb.fanout(topic, msg); return true },
+				EnsureFunc: func(*Message) bool { return true },
+			}
+			sb.handlers = append(sb.handlers, h.Prepare())
+		}
+	})
+	return sb
+}
+
+// Run 启动桥接
+func (sb *StreamBridge) Run() {
+	var wg sync.WaitGroup
+	for _, h := range sb.handlers {
+		wg.Add(1)
+		go func(h *Handler) {
+			defer wg.Done()
+			h.Run()
+		}(h)
+	}
+	wg.Wait()
+}
+
+// Wait 等待退出
+func (sb *StreamBridge) Wait() {
+	for _, h := range sb.handlers {
+		h.Wait()
+	}
+}
+
+// SSE 基于Server-Sent Events的推送, 每条消息以BridgeEvent的JSON作为data字段发送
+func (sb *StreamBridge) SSE() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		bc, err := sb.connect(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer sb.disconnect(bc)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-bc.closed:
+				return
+			case ev := <-bc.events:
+				if err := writeSSE(w, ev); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+// WebSocket 基于WebSocket的推送, 每条消息以BridgeEvent的JSON文本帧发送
+func (sb *StreamBridge) WebSocket() http.Handler {
+	return websocket.Server{Handler: func(ws *websocket.Conn) {
+		bc, err := sb.connect(ws.Request())
+		if err != nil {
+			_ = websocket.JSON.Send(ws, map[string]string{"error": err.Error()})
+			return
+		}
+		defer sb.disconnect(bc)
+		go func() {
+			_, _ = io.Copy(io.Discard, ws) // 客户端关闭时结束推送
+			bc.close()
+		}()
+		for {
+			select {
+			case <-bc.closed:
+				return
+			case ev := <-bc.events:
+				if err := websocket.JSON.Send(ws, ev); err != nil {
+					return
+				}
+			}
+		}
+	}}
+}
+
+// Connections 获取当前的连接数量
+func (sb *StreamBridge) Connections() (n int) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	for _, conns := range sb.conns {
+		n += len(conns)
+	}
+	return n
+}
+
+// Dropped 获取因缓冲已满而丢弃的消息总数
+func (sb *StreamBridge) Dropped() uint64 { return atomic.LoadUint64(&sb.dropped) }
+
+// connect 根据请求参数建立连接并订阅主题
+func (sb *StreamBridge) connect(r *http.Request) (*bridgeConn, error) {
+	topic := r.URL.Query().Get("topic")
+	if _, ok := sb.conns[topic]; !ok {
+		return nil, fmt.Errorf("topic [%s] not allowed", topic)
+	}
+	routeKeys := r.URL.Query()["route_key"]
+	if len(routeKeys) == 0 {
+		routeKeys = []string{""}
+	}
+	bc := &bridgeConn{
+		topic:     topic,
+		routeKeys: make(map[string]bool),
+		events:    make(chan *BridgeEvent, sb.Buffer),
+		closed:    make(chan struct{}),
+	}
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	for _, rk := range routeKeys {
+		if bc.routeKeys[rk] {
+			continue
+		}
+		if sb.refs[topic][rk] == 0 {
+			if err := sb.Driver.Subscribe(topic, sb.queue(topic), rk); err != nil {
+				sb.release(bc)
+				return nil, fmt.Errorf("subscribe topic [%s] failed, %v", topic, err)
+			}
+		}
+		sb.refs[topic][rk]++
+		bc.routeKeys[rk] = true
+	}
+	sb.conns[topic][bc] = true
+	return bc, nil
+}
+
+// disconnect 断开连接, 无连接的路由键取消订阅
+func (sb *StreamBridge) disconnect(bc *bridgeConn) {
+	bc.close()
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	delete(sb.conns[bc.topic], bc)
+	sb.release(bc)
+}
+
+// release 释放连接持有的订阅
+func (sb *StreamBridge) release(bc *bridgeConn) {
+	for rk := range bc.routeKeys {
+		if sb.refs[bc.topic][rk]--; sb.refs[bc.topic][rk] > 0 {
+			continue
+		}
+		delete(sb.refs[bc.topic], rk)
+		if err := sb.Driver.UnSubscribe(bc.topic, sb.queue(bc.topic), rk); err != nil {
+			sb.Logger.Errorf("stream bridge [%s] unsubscribe topic [%s] failed, %v", sb.Queue, bc.topic, err)
+		}
+	}
+}
+
+// fanout 将消息推送给匹配路由键的连接
+func (sb *StreamBridge) fanout(topic string, msg *Message) {
+	ev := &BridgeEvent{Topic: topic, RouteKey: msg.RouteKey, ID: msg.BizUID}
+	if json.Valid(msg.Payload) {
+		ev.Payload = msg.Payload
+	} else {
+		ev.Raw = msg.Payload
+	}
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	for bc := range sb.conns[topic] {
+		if !bc.routeKeys[msg.RouteKey] {
+			continue
+		}
+		select {
+		case bc.events <- ev:
+		default:
+			atomic.AddUint64(&sb.dropped, 1)
+			if sb.SlowPolicy == SlowDisconnect {
+				bc.close()
+			}
+		}
+	}
+}
+
+// writeSSE 写入SSE事件, data字段为单行的BridgeEvent JSON, 与WebSocket一致
+func writeSSE(w io.Writer, ev *BridgeEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", sseField(ev.ID), sseField(ev.Topic), data)
+	return err
+}
+
+// sseField 移除字段中的换行, 避免破坏事件帧
+func sseField(s string) string { return strings.NewReplacer("\r", "", "\n", "").Replace(s) }
+
+// queue 主题对应的桥接队列
+func (sb *StreamBridge) queue(topic string) string { return sb.Queue + "." + topic }
@@ -0,0 +1,208 @@
+// Package topology 从YAML或JSON配置声明主题, 队列及其处理策略
+package topology
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/easy-bus/bus"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration 配置中的时长, 支持 "10s", "1m30s" 等字符串格式
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration [%s], %v", s, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config 拓扑配置
+// 声明主题, 队列, 订阅关系, 延迟及重试策略
+type Config struct {
+	// Topics 主题列表
+	Topics []Topic `yaml:"topics"`
+
+	// Queues 队列列表
+	Queues []Queue `yaml:"queues"`
+}
+
+// Topic 主题配置
+type Topic struct {
+	Name string `yaml:"name"`
+}
+
+// Queue 队列配置
+type Queue struct {
+	Name string `yaml:"name"`
+
+	// Delay 消息处理延迟时长
+	Delay Duration `yaml:"delay"`
+
+	// Subscribe 订阅的主题及路由键
+	Subscribe *Subscribe `yaml:"subscribe"`
+
+	// Concurrency 消息并发处理数量
+	Concurrency int `yaml:"concurrency"`
+
+	// Weights 路由键的调度权重, 配置后按路由键加权公平调度
+	Weights map[string]int `yaml:"weights"`
+
+	// Retry 重试策略, 为空则不重试
+	Retry *Retry `yaml:"retry"`
+}
+
+// Retry 重试策略配置
+type Retry struct {
+	// Attempts 最多重试次数
+	Attempts int `yaml:"attempts"`
+
+	// Delay 首次重试延迟
+	Delay Duration `yaml:"delay"`
+
+	// Multiplier 每次重试的延迟倍数, 默认为1
+	Multiplier float64 `yaml:"multiplier"`
+
+	// MaxDelay 重试延迟上限, 为0则不限制
+	MaxDelay Duration `yaml:"max_delay"`
+}
+
+// RetryDelay 转换为处理器的重试延迟机制
+func (rc *Retry) RetryDelay() func(attempts int) time.Duration {
+	return func(attempts int) time.Duration {
+		if attempts > rc.Attempts {
+			return -1
+		}
+		multiplier := rc.Multiplier
+		if multiplier <= 0 {
+			multiplier = 1
+		}
+		delay := time.Duration(float64(rc.Delay) * math.Pow(multiplier, float64(attempts-1)))
+		if rc.MaxDelay > 0 && delay > time.Duration(rc.MaxDelay) {
+			delay = time.Duration(rc.MaxDelay)
+		}
+		return delay
+	}
+}
+
+// Subscribe 订阅配置
+type Subscribe struct {
+	Topic    string `yaml:"topic"`
+	RouteKey string `yaml:"route_key"`
+}
+
+// Load 从文件加载拓扑配置
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("topology load failed, %v", err)
+	}
+	return Parse(data)
+}
+
+// Parse 解析拓扑配置, 支持YAML及JSON格式
+func Parse(data []byte) (*Config, error) {
+	var tc Config
+	if err := yaml.Unmarshal(data, &tc); err != nil {
+		return nil, fmt.Errorf("topology parse failed, %v", err)
+	}
+	if err := tc.check(); err != nil {
+		return nil, err
+	}
+	return &tc, nil
+}
+
+// check 检查配置的完整性
+func (tc *Config) check() error {
+	topics := map[string]bool{}
+	for _, t := range tc.Topics {
+		if t.Name == "" {
+			return fmt.Errorf("topology topic missing name")
+		} else if topics[t.Name] {
+			return fmt.Errorf("topology topic [%s] duplicated", t.Name)
+		}
+		topics[t.Name] = true
+	}
+	queues := map[string]bool{}
+	for _, q := range tc.Queues {
+		if q.Name == "" {
+			return fmt.Errorf("topology queue missing name")
+		} else if queues[q.Name] {
+			return fmt.Errorf("topology queue [%s] duplicated", q.Name)
+		}
+		queues[q.Name] = true
+		if q.Subscribe != nil && !topics[q.Subscribe.Topic] {
+			return fmt.Errorf("topology queue [%s] subscribe undeclared topic [%s]", q.Name, q.Subscribe.Topic)
+		}
+	}
+	return nil
+}
+
+// NewSender 根据配置创建主题的发送器
+func (tc *Config) NewSender(topic string, driver bus.DriverInterface) (*bus.Sender, error) {
+	for _, t := range tc.Topics {
+		if t.Name == topic {
+			return &bus.Sender{Topic: t.Name, Driver: driver}, nil
+		}
+	}
+	return nil, fmt.Errorf("topology topic [%s] undeclared", topic)
+}
+
+// NewHandler 根据配置创建队列的处理器
+func (tc *Config) NewHandler(queue string, driver bus.DriverInterface, fn func(msg *bus.Message) bool, opts ...bus.HandlerOpt) (*bus.Handler, error) {
+	for _, q := range tc.Queues {
+		if q.Name != queue {
+			continue
+		}
+		h := &bus.Handler{
+			Queue:       q.Name,
+			Delay:       time.Duration(q.Delay),
+			Driver:      driver,
+			HandleFunc:  fn,
+			Concurrency: q.Concurrency,
+		}
+		if q.Subscribe != nil {
+			h.Subscribe = bus.Subscribe{Topic: q.Subscribe.Topic, RouteKey: q.Subscribe.RouteKey}
+		}
+		if q.Retry != nil {
+			h.RetryDelay = q.Retry.RetryDelay()
+		}
+		if len(q.Weights) > 0 {
+			h.FairDispatch = &bus.FairDispatch{Weights: q.Weights}
+		}
+		for _, opt := range opts {
+			opt(h)
+		}
+		return h, nil
+	}
+	return nil, fmt.Errorf("topology queue [%s] undeclared", queue)
+}
+
+// Group 根据配置创建全部主题的发送器及队列的处理器
+// funcs 为队列名称对应的消息处理函数, 每个队列都必须提供
+func (tc *Config) Group(driver bus.DriverInterface, funcs map[string]func(msg *bus.Message) bool, opts ...bus.HandlerOpt) (*bus.Group, error) {
+	g := &bus.Group{}
+	for _, t := range tc.Topics {
+		s, _ := tc.NewSender(t.Name, driver)
+		g.AddSender(s)
+	}
+	for _, q := range tc.Queues {
+		fn, ok := funcs[q.Name]
+		if !ok {
+			return nil, fmt.Errorf("topology queue [%s] missing handle function", q.Name)
+		}
+		h, _ := tc.NewHandler(q.Name, driver, fn, opts...)
+		g.AddHandler(h)
+	}
+	return g, nil
+}
@@ -0,0 +1,61 @@
+package topology
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/easy-bus/bus"
+	"github.com/easy-bus/bus/memdriver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopology(t *testing.T) {
+	driver := &memdriver.Driver{}
+	tc, err := Parse([]byte(`
+topics:
+  - name: order.created
+queues:
+  - name: order.notify
+    delay: 1s
+    concurrency: 4
+    subscribe:
+      topic: order.created
+      route_key: vip
+    retry:
+      attempts: 3
+      delay: 10s
+      multiplier: 2
+      max_delay: 30s
+`))
+	assert.Nil(t, err)
+	h, err := tc.NewHandler("order.notify", driver, func(*bus.Message) bool { return true })
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, h.Delay)
+	assert.Equal(t, 4, h.Concurrency)
+	assert.Equal(t, bus.Subscribe{Topic: "order.created", RouteKey: "vip"}, h.Subscribe)
+	assert.Equal(t, 10*time.Second, h.RetryDelay(1))
+	assert.Equal(t, 20*time.Second, h.RetryDelay(2))
+	assert.Equal(t, 30*time.Second, h.RetryDelay(3))
+	assert.Equal(t, time.Duration(-1), h.RetryDelay(4))
+	_, err = tc.NewHandler("missing", driver, nil)
+	assert.NotNil(t, err)
+
+	tc, err = Parse([]byte(`{"topics":[{"name":"a"}],"queues":[{"name":"q","subscribe":{"topic":"a"}}]}`))
+	assert.Nil(t, err)
+	s, err := tc.NewSender("a", driver)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", s.Topic)
+	_, err = tc.Group(driver, nil)
+	assert.NotNil(t, err)
+	g, err := tc.Group(driver, map[string]func(*bus.Message) bool{"q": func(*bus.Message) bool { return true }})
+	assert.Nil(t, err)
+	assert.Nil(t, driver.CreateTopic("a"))
+	assert.Nil(t, g.Start(context.TODO()))
+	assert.Nil(t, g.Shutdown(context.TODO()))
+
+	_, err = Parse([]byte(`{"queues":[{"name":"q","subscribe":{"topic":"a"}}]}`))
+	assert.NotNil(t, err)
+	_, err = Parse([]byte(`{"queues":[{"name":"q","delay":"soon"}]}`))
+	assert.NotNil(t, err)
+}
@@ -1,6 +1,7 @@
 package bus
 
 import (
+	"fmt"
 	"github.com/sony/sonyflake"
 	"math"
 	"math/rand"
@@ -46,3 +47,17 @@ func generateSeqId() string {
 	id, _ := sf.NextID()
 	return strconv.FormatUint(id, 36)
 }
+
+// commonCapabilities 获取全部驱动共同具备的能力
+func commonCapabilities(drivers ...DriverInterface) Capability {
+	caps := ^Capability(0)
+	for _, drv := range drivers {
+		caps &= Capabilities(drv)
+	}
+	return caps
+}
+
+// errUnsupported 驱动不具备能力的错误
+func errUnsupported(driver DriverInterface, feature string) error {
+	return fmt.Errorf("driver %T does not support %s", driver, feature)
+}
@@ -0,0 +1,193 @@
+package bus
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 推送请求头
+const (
+	WebhookIdHeader        = "X-Bus-Id"
+	WebhookAttemptHeader   = "X-Bus-Attempt"
+	WebhookTimestampHeader = "X-Bus-Timestamp"
+	WebhookSignatureHeader = "X-Bus-Signature"
+)
+
+// WebhookDeliveredHeader 记录已推送成功的端点的消息头
+// 部分端点失败重试时跳过已成功的端点
+const WebhookDeliveredHeader = "webhook-delivered"
+
+// WebhookEndpoint 推送端点
+type WebhookEndpoint struct {
+	// Name 端点名称, 用于记录推送进度, 默认为URL
+	Name string
+
+	// URL 推送地址
+	URL string
+
+	// Secret 签名密钥, 为空则不签名
+	Secret string
+
+	// Headers 附加的请求头
+	Headers map[string]string
+}
+
+// Webhook 消息推送
+// 消费源队列的消息, 以HTTP POST推送至全部端点
+// 端点返回5xx或429时按源处理器的RetryDelay重试, 并遵循Retry-After
+// 其余非2xx响应视为永久失败, 消息直接进入死信
+type Webhook struct {
+	sync.Once
+
+	// Source 源队列处理器
+	// 其HandleFunc由推送接管, 无需设置
+	Source *Handler
+
+	// Endpoints 推送端点列表
+	Endpoints []WebhookEndpoint
+
+	// Client HTTP客户端, 默认超时10秒
+	Client *http.Client
+
+	// Body 请求体编码, 默认为消息内容
+	Body func(msg *Message) ([]byte, error)
+}
+
+// Prepare 准备就绪
+func (w *Webhook) Prepare() *Webhook {
+	w.Do(func() {
+		if w.Source == nil {
+			throw("the webhook missing source handler")
+		}
+		if len(w.Endpoints) == 0 {
+			throw("the webhook [%s] missing endpoints", w.Source.Queue)
+		}
+		for i := range w.Endpoints {
+			if w.Endpoints[i].URL == "" {
+				throw("the webhook [%s] endpoint missing url", w.Source.Queue)
+			}
+			if w.Endpoints[i].Name == "" {
+				w.Endpoints[i].Name = w.Endpoints[i].URL
+			}
+		}
+		if w.Client == nil {
+			w.Client = &http.Client{Timeout: 10 * time.Second}
+		}
+		if w.Body == nil {
+			w.Body = func(msg *Message) ([]byte, error) { return msg.Payload, nil }
+		}
+		if w.Source.EnsureFunc == nil {
+			w.Source.EnsureFunc = func(*Message) bool { return true }
+		}
+		w.Source.HandleFunc = w.handle
+		w.Source.Prepare()
+	})
+	return w
+}
+
+// Run 启动推送
+func (w *Webhook) Run() { w.Source.Run() }
+
+// Wait 等待退出
+func (w *Webhook) Wait() { w.Source.Wait() }
+
+// SignWebhook 计算推送签名
+// 签名内容为 "时间戳.请求体", 使用HMAC-SHA256并以十六进制表示
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// handle 推送消息至尚未成功的端点
+func (w *Webhook) handle(msg *Message) bool {
+	body, err := w.Body(msg)
+	if err != nil {
+		w.Source.Logger.Errorf("webhook [%s] encode body failed, %v", w.Source.Queue, err)
+		return false
+	}
+	delivered := map[string]bool{}
+	for _, name := range strings.Fields(msg.Header(WebhookDeliveredHeader)) {
+		delivered[name] = true
+	}
+	var failed, permanent bool
+	var retryAfter time.Duration
+	for _, ep := range w.Endpoints {
+		if delivered[ep.Name] {
+			continue
+		}
+		status, wait, err := w.post(&ep, msg, body)
+		if err == nil {
+			delivered[ep.Name] = true
+			continue
+		}
+		failed = true
+		w.Source.Logger.Errorf("webhook [%s] post to [%s] failed, %v", w.Source.Queue, ep.Name, err)
+		if status != 0 && status != http.StatusTooManyRequests && status < 500 {
+			permanent = true
+		}
+		if wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if !failed {
+		return true
+	}
+	names := make([]string, 0, len(delivered))
+	for _, ep := range w.Endpoints {
+		if delivered[ep.Name] {
+			names = append(names, ep.Name)
+		}
+	}
+	MessageHeader(WebhookDeliveredHeader, strings.Join(names, " "))(msg)
+	if permanent {
+		msg.RetryAfter(-1) // 永久失败不再重试
+	} else if retryAfter > 0 {
+		msg.RetryAfter(retryAfter)
+	}
+	return false
+}
+
+// post 推送至端点, 返回响应状态码及端点要求的重试延迟
+func (w *Webhook) post(ep *WebhookEndpoint, msg *Message, body []byte) (int, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range ep.Headers {
+		req.Header.Set(k, v)
+	}
+	ts := time.Now().Unix()
+	req.Header.Set(WebhookIdHeader, msg.BizUID)
+	req.Header.Set(WebhookAttemptHeader, strconv.Itoa(msg.Retried+1))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(ts, 10))
+	if ep.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(ep.Secret, ts, body))
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, 0, nil
+	}
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		wait = time.Duration(seconds) * time.Second
+	}
+	return resp.StatusCode, wait, fmt.Errorf("unexpected status [%d]", resp.StatusCode)
+}